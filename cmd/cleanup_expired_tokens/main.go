@@ -0,0 +1,67 @@
+package main
+
+import (
+	"gin_stuff/internals/config"
+	"gin_stuff/internals/database"
+	"gin_stuff/internals/repositories"
+	"log"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+/*
+- Remove expired & consumed rows from `user_tokens`, expired ones from `stream_tickets`.
+
+- Remove the stream events older than `stream.event_retention` (72h by default).
+
+- Meant to be run periodically (cron or similar).
+*/
+func main() {
+	err := perform()
+	if err != nil {
+		log.Printf("Error performing task %v\n", err)
+	}
+}
+
+func perform() error {
+	err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config %v", err)
+	}
+	dbConfig := database.DBConfig{
+		MaxIdleConnections: viper.GetInt("database.max_db_conns"),
+		MaxOpenConnections: viper.GetInt("database.max_open_conns"),
+		MaxIdleTime:        viper.GetDuration("database.max_idle_time"),
+	}
+
+	dbInstance, err := database.OpenDB(viper.GetString("database.uri"), dbConfig)
+	if err != nil {
+		log.Fatalf("error open connection to database %v\n", err)
+	}
+	defer dbInstance.Close()
+
+	repo := repositories.New(dbInstance)
+	deleted, err := repo.Token.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted %d token(s)\n", deleted)
+	deleted, err = repo.StreamTicket.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted %d stream ticket(s)\n", deleted)
+
+	retention := viper.GetDuration("stream.event_retention")
+	if retention <= 0 {
+		retention = 72 * time.Hour
+	}
+	deleted, err = repo.Event.DeleteBefore(time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted %d event(s)\n", deleted)
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gin_stuff/internals/config"
+	"gin_stuff/internals/database"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"log"
+	"os"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+/*
+- Export the published chapters of a book to an EPUB file, same as `GET /api/book/:id/export/epub`.
+
+- go run ./cmd/export_book -book 12 [-output the-book.epub]
+*/
+func main() {
+	err := perform()
+	if err != nil {
+		log.Printf("Error performing task %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func perform() error {
+	bookId := flag.Int64("book", 0, "id of the book")
+	output := flag.String("output", "", "path of the file, <book title>.epub by default")
+	flag.Parse()
+	if *bookId < 1 {
+		flag.Usage()
+		return fmt.Errorf("missing book id")
+	}
+
+	err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config %v", err)
+	}
+	dbConfig := database.DBConfig{
+		MaxIdleConnections: viper.GetInt("database.max_db_conns"),
+		MaxOpenConnections: viper.GetInt("database.max_open_conns"),
+		MaxIdleTime:        viper.GetDuration("database.max_idle_time"),
+	}
+
+	dbInstance, err := database.OpenDB(viper.GetString("database.uri"), dbConfig)
+	if err != nil {
+		log.Fatalf("error open connection to database %v\n", err)
+	}
+	defer dbInstance.Close()
+
+	repo := repositories.New(dbInstance)
+	export, err := repo.Export.Get(*bookId)
+	if err != nil {
+		return err
+	}
+	path := *output
+	if path == "" {
+		path = utils.Slugify(export.Book.Title)
+		if path == "" {
+			path = fmt.Sprintf("book-%d", export.Book.ID)
+		}
+		path += ".epub"
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := services.NewEpubService().Write(file, export.Epub()); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	log.Printf("Exported %d chapter(s) of \"%s\" to %s\n", len(export.Chapters), export.Book.Title, path)
+	return nil
+}
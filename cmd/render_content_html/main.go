@@ -0,0 +1,68 @@
+package main
+
+import (
+	"gin_stuff/internals/config"
+	"gin_stuff/internals/database"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"log"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+// contents rendered per query
+const batchSize = 100
+
+/*
+- Store the rendered HTML of the contents saved before `html` was added to the `contents` table.
+
+- Reading these contents renders them every time until this is run. Safe to run again & while the API serves.
+*/
+func main() {
+	err := perform()
+	if err != nil {
+		log.Printf("Error performing task %v\n", err)
+	}
+}
+
+func perform() error {
+	err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config %v", err)
+	}
+	dbConfig := database.DBConfig{
+		MaxIdleConnections: viper.GetInt("database.max_db_conns"),
+		MaxOpenConnections: viper.GetInt("database.max_open_conns"),
+		MaxIdleTime:        viper.GetDuration("database.max_idle_time"),
+	}
+
+	dbInstance, err := database.OpenDB(viper.GetString("database.uri"), dbConfig)
+	if err != nil {
+		log.Fatalf("error open connection to database %v\n", err)
+	}
+	defer dbInstance.Close()
+
+	repo := repositories.New(dbInstance)
+	contentService := services.NewContentService()
+	rendered := 0
+	for {
+		contents, err := repo.Content.FindWithoutHTML(batchSize)
+		if err != nil {
+			return err
+		}
+		for _, content := range contents {
+			// never NULL once stored, even when the text renders to nothing
+			content.HTML = contentService.Render(content.TextContent, content.Format)
+			if err := repo.Content.SaveHTML(content); err != nil {
+				return err
+			}
+		}
+		rendered += len(contents)
+		if len(contents) < batchSize {
+			break
+		}
+	}
+	log.Printf("Rendered %d content(s)\n", rendered)
+	return nil
+}
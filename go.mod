@@ -14,7 +14,9 @@ require (
 	github.com/rs/zerolog v1.31.0
 	github.com/spf13/viper v1.16.0
 	github.com/wneessen/go-mail v0.4.0
+	github.com/yuin/goldmark v1.7.13
 	golang.org/x/crypto v0.23.0
+	golang.org/x/net v0.25.0
 	google.golang.org/api v0.178.0
 )
 
@@ -60,7 +62,6 @@ require (
 	go.opentelemetry.io/otel v1.26.0 // indirect
 	go.opentelemetry.io/otel/metric v1.26.0 // indirect
 	go.opentelemetry.io/otel/trace v1.26.0 // indirect
-	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/oauth2 v0.20.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"gin_stuff/internals/config"
 	"gin_stuff/internals/database"
@@ -8,8 +10,13 @@ import (
 	"gin_stuff/internals/repositories"
 	router "gin_stuff/internals/routers"
 	"gin_stuff/internals/services"
+	"gin_stuff/internals/workers"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -19,9 +26,14 @@ import (
 	"github.com/spf13/viper"
 )
 
+// the in-flight requests get this long to finish when the server is asked to stop
+const shutdownTimeout = 10 * time.Second
+
 type Application struct {
 	EchoInstance *echo.Echo
 	DB           *sqlx.DB
+	// run once the server stopped serving requests, the last registered first
+	stopHooks []func()
 }
 
 func NewApplication() *Application {
@@ -105,27 +117,92 @@ func NewApplication() *Application {
 	}
 
 	// mailer
-	mailer, err := services.NewMailerService(services.MailerSMTPConfig{
-		Host:     viper.GetString("mailer.host"),
-		Port:     viper.GetInt64("mailer.port"),
-		Login:    viper.GetString("mailer.login"),
-		Password: viper.GetString("mailer.password"),
-		Timeout:  viper.GetDuration("mailer.timeout"),
+	mailer, err := services.NewMailer(services.MailerConfig{
+		Transport: viper.GetString("mailer.transport"),
+		Directory: viper.GetString("mailer.directory"),
+		SMTP: services.MailerSMTPConfig{
+			Host:     viper.GetString("mailer.host"),
+			Port:     viper.GetInt64("mailer.port"),
+			Login:    viper.GetString("mailer.login"),
+			Password: viper.GetString("mailer.password"),
+			Timeout:  viper.GetDuration("mailer.timeout"),
+		},
 	})
 	if err != nil {
 		loggerService.LogFatal(err, "fail to initialize mailer service")
 	}
+	mailTemplateService, err := services.NewMailTemplateService(services.MailTemplateConfig{
+		Sender:        viper.GetString("mailer.sender"),
+		FrontendURL:   viper.GetString("general.frontend_url"),
+		DefaultLocale: viper.GetString("mailer.default_locale"),
+	})
+	if err != nil {
+		loggerService.LogFatal(err, "fail to load the mail templates")
+	}
 
 	// handle shut down of stuff
-	app.EchoInstance.Server.RegisterOnShutdown(func() {
-		err = app.DB.Close()
+	app.OnStop(func() {
+		err := app.DB.Close()
 		if err != nil {
 			loggerService.LogError(err, "fail to gracefully shutdown database connection")
 		}
 	})
 
+	// sessions are checked on every authenticated request, keep the result for a short while
+	sessionCacheTTL := viper.GetDuration("jwt.session_cache_ttl")
+	if sessionCacheTTL == 0 {
+		sessionCacheTTL = 30 * time.Second
+	}
+	sessionCache := services.NewCacheService[string, bool](sessionCacheTTL)
+
+	// live streams, woken up by the events inserted on any replica
+	streamService := services.NewStreamService()
+	listener, err := database.Listen(dbUri, "events")
+	if err != nil {
+		app.LogFatalf("Can't listen to database events: %v", err)
+	}
+	app.EchoInstance.Server.RegisterOnShutdown(streamService.Shutdown)
+	app.OnStop(func() {
+		if err := listener.Close(); err != nil {
+			loggerService.LogError(err, "fail to gracefully shutdown database listener")
+		}
+	})
+
 	repo := repositories.New(app.DB)
-	r := router.New(&repo, mailer, &loggerService)
+
+	// emails are queued in the outbox by the handlers & sent from here
+	mailWorker := workers.NewMailWorker(repo.Outbox, mailer, &loggerService, workers.MailWorkerConfig{
+		PollInterval: viper.GetDuration("outbox.poll_interval"),
+		BatchSize:    viper.GetInt("outbox.batch_size"),
+		MaxAttempts:  viper.GetInt("outbox.max_attempts"),
+		BaseBackoff:  viper.GetDuration("outbox.base_backoff"),
+		MaxBackoff:   viper.GetDuration("outbox.max_backoff"),
+		Lease:        viper.GetDuration("outbox.lease"),
+	})
+	mailWorkerCtx, stopMailWorker := context.WithCancel(context.Background())
+	mailWorkerDone := make(chan struct{})
+	app.OnStop(func() {
+		stopMailWorker()
+		<-mailWorkerDone
+	})
+	go func() {
+		defer close(mailWorkerDone)
+		mailWorker.Run(mailWorkerCtx)
+	}()
+
+	r := router.New(&repo, mailer, mailTemplateService, &loggerService, sessionCache, streamService)
+	go r.DispatchEvents(listener)
+	notificationCtx, stopNotifications := context.WithCancel(context.Background())
+	notificationsDone := make(chan struct{})
+	// the deliveries still queued are made before the mails they enqueue get sent & the database is closed
+	app.OnStop(func() {
+		stopNotifications()
+		<-notificationsDone
+	})
+	go func() {
+		defer close(notificationsDone)
+		r.DeliverNotifications(notificationCtx)
+	}()
 	app.RegisterRoute(r)
 
 	return app
@@ -145,8 +222,12 @@ func (app *Application) LogInfof(format string, args ...interface{}) {
 
 // Register the routes in server
 func (app Application) RegisterRoute(r router.Router) {
-	requireAccessToken := middlewares.NewJWTMiddleware("access")
+	requireAccessToken := middlewares.NewJWTMiddleware("access", r.Repository.Session, r.SessionCache)
+	optionalAccessToken := middlewares.NewOptionalJWTMiddleware("access", r.Repository.Session, r.SessionCache)
 	requireUserVerification := middlewares.NewUserVerificationRequireMiddleware(r.Repository.User)
+	requirePermission := func(permission string) echo.MiddlewareFunc {
+		return middlewares.NewPermissionRequireMiddleware(r.Repository.Role, permission)
+	}
 	//gloabl prefix
 	api := app.EchoInstance.Group("/api")
 
@@ -154,32 +235,200 @@ func (app Application) RegisterRoute(r router.Router) {
 	auth := api.Group("/auth")
 	auth.POST("/sign-in", r.Login)
 	auth.POST("/sign-up", r.Register)
+	auth.POST("/refresh", r.RefreshToken)
 	auth.POST("/verify-email", r.VerifyEmail)
 	auth.POST("/resend-verification-mail", r.ResendVerificationEmail, requireAccessToken)
 	auth.POST("/forget-password", r.ForgetPassword)
 	auth.POST("/reset-password", r.ResetPassword)
+	auth.POST("/sign-out", r.Logout, requireAccessToken)
 	auth.GET("/me", r.Me, requireAccessToken)
+	auth.PUT("/me/locale", r.UpdateLocale, requireAccessToken)
+	auth.GET("/sessions", r.FindSessions, requireAccessToken)
+	auth.DELETE("/sessions", r.RevokeAllSessions, requireAccessToken)
+	auth.DELETE("/sessions/:sessionId", r.RevokeSession, requireAccessToken)
+
+	// public catalog
+	api.GET("/catalog", r.FindCatalog)
+	api.GET("/genres", r.FindGenres)
+	api.GET("/tags", r.FindTags)
+	api.GET("/tags/:tagId", r.GetTag)
 
 	//book group
 	bookAPI := api.Group("/book")
 	bookAPI.GET("", r.FindBooks, requireAccessToken)
-	bookAPI.GET("/:id", r.GetBook)
-	bookAPI.POST("", r.CreateBook, requireAccessToken, requireUserVerification)
+	bookAPI.GET("/:id", r.GetBook, optionalAccessToken)
+	bookAPI.POST("", r.CreateBook, requireAccessToken, requireUserVerification, requirePermission(repositories.PermissionBookCreate))
+	importBodyLimit := middleware.BodyLimit("21M")
+	bookAPI.POST("/import/preview", r.PreviewBookImport, importBodyLimit, requireAccessToken, requireUserVerification, requirePermission(repositories.PermissionBookCreate))
+	bookAPI.POST("/import", r.ImportBook, importBodyLimit, requireAccessToken, requireUserVerification, requirePermission(repositories.PermissionBookCreate))
 	bookAPI.PATCH("/:id", r.UpdateBook, requireAccessToken, requireUserVerification)
 	bookAPI.DELETE("/:id", r.DeleteBook, requireAccessToken, requireUserVerification)
 
+	// book collaborators
+	bookAPI.GET("/:id/collaborators", r.FindCollaborators, requireAccessToken)
+	bookAPI.POST("/:id/collaborators", r.InviteCollaborator, requireAccessToken, requireUserVerification)
+	bookAPI.DELETE("/:id/collaborators/:collaboratorId", r.RemoveCollaborator, requireAccessToken)
+
+	// book reviews
+	requireReviewWrite := requirePermission(repositories.PermissionReviewWrite)
+	bookAPI.GET("/:id/reviews", r.FindReviews, optionalAccessToken)
+	bookAPI.POST("/:id/reviews", r.CreateReview, requireAccessToken, requireUserVerification, requireReviewWrite)
+	bookAPI.PATCH("/:id/reviews/:reviewId", r.UpdateReview, requireAccessToken, requireReviewWrite)
+	bookAPI.DELETE("/:id/reviews/:reviewId", r.DeleteReview, requireAccessToken)
+	bookAPI.PUT("/:id/reviews/:reviewId/reply", r.ReplyToReview, requireAccessToken, requireUserVerification)
+	bookAPI.DELETE("/:id/reviews/:reviewId/reply", r.DeleteReviewReply, requireAccessToken)
+
+	// reading progress & bookmarks of the current user
+	bookAPI.GET("/:id/progress", r.GetReadingProgress, requireAccessToken)
+	bookAPI.PUT("/:id/progress", r.SaveReadingProgress, requireAccessToken)
+	bookAPI.DELETE("/:id/progress", r.DeleteReadingProgress, requireAccessToken)
+	bookAPI.GET("/:id/bookmarks", r.FindBookmarks, requireAccessToken)
+	bookAPI.POST("/:id/bookmarks", r.CreateBookmark, requireAccessToken)
+	bookAPI.PATCH("/:id/bookmarks/:bookmarkId", r.UpdateBookmark, requireAccessToken)
+	bookAPI.DELETE("/:id/bookmarks/:bookmarkId", r.DeleteBookmark, requireAccessToken)
+	api.GET("/reading", r.ContinueReading, requireAccessToken)
+
+	// library shelves
+	shelfAPI := api.Group("/shelves")
+	shelfAPI.GET("", r.FindShelves, requireAccessToken)
+	shelfAPI.POST("", r.CreateShelf, requireAccessToken)
+	shelfAPI.PATCH("/:shelfId", r.UpdateShelf, requireAccessToken)
+	shelfAPI.DELETE("/:shelfId", r.DeleteShelf, requireAccessToken)
+	shelfAPI.GET("/:shelfId/books", r.FindShelfBooks, optionalAccessToken)
+	shelfAPI.PUT("/:shelfId/books/:bookId", r.AddShelfBook, requireAccessToken)
+	shelfAPI.DELETE("/:shelfId/books/:bookId", r.RemoveShelfBook, requireAccessToken)
+	shelfAPI.POST("/:shelfId/books/:bookId/move", r.MoveShelfBook, requireAccessToken)
+	api.GET("/users/:userId/shelves", r.FindUserShelves, optionalAccessToken)
+
+	// follows & book subscriptions
+	userAPI := api.Group("/users/:userId")
+	userAPI.GET("/followers", r.FindFollowers)
+	userAPI.GET("/following", r.FindFollowing)
+	userAPI.POST("/follow", r.FollowUser, requireAccessToken)
+	userAPI.DELETE("/follow", r.UnfollowUser, requireAccessToken)
+	bookAPI.POST("/:id/subscription", r.SubscribeBook, requireAccessToken)
+	bookAPI.DELETE("/:id/subscription", r.UnsubscribeBook, requireAccessToken)
+	api.GET("/subscriptions", r.FindSubscriptions, requireAccessToken)
+
+	// downloads of the published chapters
+	bookAPI.GET("/:id/export/epub", r.ExportEpub, optionalAccessToken)
+	// the whole manuscript, drafts included, for its authors
+	bookAPI.GET("/:id/manuscript/markdown", r.ExportManuscriptMarkdown, requireAccessToken)
+	bookAPI.GET("/:id/manuscript/zip", r.ExportManuscriptZip, requireAccessToken)
+	bookAPI.GET("/:id/manuscript/json", r.ExportManuscriptJSON, requireAccessToken)
+
+	// notifications of the current user
+	notificationAPI := api.Group("/notifications", requireAccessToken)
+	notificationAPI.GET("", r.FindNotifications)
+	notificationAPI.GET("/unread-count", r.CountUnreadNotifications)
+	notificationAPI.POST("/read", r.MarkNotificationsRead)
+	notificationAPI.POST("/:notificationId/read", r.MarkNotificationRead)
+	notificationAPI.GET("/preferences", r.GetNotificationPreferences)
+	notificationAPI.PUT("/preferences", r.UpdateNotificationPreferences)
+
+	// live stream (Server-Sent Events) of the current user
+	api.POST("/stream/ticket", r.CreateStreamTicket, requireAccessToken)
+	api.GET("/stream", r.StreamEvents)
+
+	// collaboration invitations of the current user
+	invitationAPI := api.Group("/invitations", requireAccessToken)
+	invitationAPI.GET("", r.FindInvitations)
+	invitationAPI.POST("/:invitationId/accept", r.AcceptInvitation, requireUserVerification)
+	invitationAPI.POST("/:invitationId/decline", r.DeclineInvitation)
+
 	// chapter API
 	chapterAPI := bookAPI.Group("/:bookId/chapter")
-	chapterAPI.GET("", r.FindChapters)
+	chapterAPI.GET("", r.FindChapters, optionalAccessToken)
 	chapterAPI.POST("", r.CreateChapter, requireAccessToken, requireUserVerification)
 	chapterAPI.PATCH("/:chapterNo", r.UpdateChapter, requireAccessToken, requireUserVerification)
 	chapterAPI.DELETE("/:chapterNo", r.DeleteChapter, requireAccessToken, requireUserVerification)
 
+	// chapter comments
+	requireCommentWrite := requirePermission(repositories.PermissionCommentWrite)
+	commentAPI := chapterAPI.Group("/:chapterNo/comments")
+	commentAPI.GET("", r.FindComments, optionalAccessToken)
+	commentAPI.POST("", r.CreateComment, requireAccessToken, requireUserVerification, requireCommentWrite)
+	commentAPI.PATCH("/:commentId", r.UpdateComment, requireAccessToken, requireCommentWrite)
+	commentAPI.DELETE("/:commentId", r.DeleteComment, requireAccessToken)
+	commentAPI.POST("/:commentId/like", r.LikeComment, requireAccessToken)
+	commentAPI.DELETE("/:commentId/like", r.UnlikeComment, requireAccessToken)
+	commentAPI.POST("/:commentId/pin", r.PinComment, requireAccessToken)
+	commentAPI.DELETE("/:commentId/pin", r.UnpinComment, requireAccessToken)
+
 	// chapter content
 	contentAPI := api.Group("/chapter/:chapterUID/content")
-	contentAPI.GET("", r.GetContent, requireAccessToken)
+	contentAPI.GET("", r.GetContent, optionalAccessToken)
+	contentAPI.POST("", r.CreateContent, requireAccessToken, requireUserVerification)
+	contentAPI.PUT("", r.ReplaceContent, requireAccessToken, requireUserVerification)
+	contentAPI.PATCH("", r.PatchContent, requireAccessToken, requireUserVerification)
+
+	// inline annotations on the chapter content
+	annotationAPI := contentAPI.Group("/annotations", requireAccessToken)
+	annotationAPI.GET("", r.GetAnnotatedContent)
+	annotationAPI.POST("", r.CreateAnnotation, requireUserVerification)
+	annotationAPI.PATCH("/:annotationId", r.UpdateAnnotation, requireUserVerification)
+	annotationAPI.DELETE("/:annotationId", r.DeleteAnnotation)
+
+	// chapter content versions
+	versionAPI := contentAPI.Group("/versions")
+	versionAPI.GET("", r.FindVersions, requireAccessToken)
+	versionAPI.GET("/diff", r.DiffVersions, requireAccessToken)
+	versionAPI.GET("/:versionId", r.GetVersion, requireAccessToken)
+	versionAPI.POST("/:versionId/restore", r.RestoreVersion, requireAccessToken, requireUserVerification)
+
+	// admin
+	adminAPI := api.Group("/admin", requireAccessToken)
+	requireRoleManage := requirePermission(repositories.PermissionRoleManage)
+	adminAPI.GET("/roles", r.FindRoles, requireRoleManage)
+	adminAPI.GET("/users/:userId/roles", r.GetUserRoles, requireRoleManage)
+	adminAPI.PUT("/users/:userId/roles", r.SetUserRoles, requireRoleManage)
+
+	// genres & tags
+	requireTaxonomyManage := requirePermission(repositories.PermissionTaxonomyManage)
+	adminAPI.POST("/genres", r.CreateGenre, requireTaxonomyManage)
+	adminAPI.PATCH("/genres/:genreId", r.UpdateGenre, requireTaxonomyManage)
+	adminAPI.DELETE("/genres/:genreId", r.DeleteGenre, requireTaxonomyManage)
+	adminAPI.POST("/tags", r.CreateTag, requireTaxonomyManage)
+	adminAPI.PATCH("/tags/:tagId", r.UpdateTag, requireTaxonomyManage)
+	adminAPI.DELETE("/tags/:tagId", r.DeleteTag, requireTaxonomyManage)
+	adminAPI.POST("/tags/:tagId/synonyms", r.AddTagSynonym, requireTaxonomyManage)
+	adminAPI.DELETE("/tags/:tagId/synonyms/:synonym", r.RemoveTagSynonym, requireTaxonomyManage)
+
+	// emails that could not be sent
+	requireEmailManage := requirePermission(repositories.PermissionEmailManage)
+	adminAPI.GET("/emails/dead", r.FindDeadEmails, requireEmailManage)
+	adminAPI.POST("/emails/:emailId/retry", r.RetryEmail, requireEmailManage)
+}
+
+// register a function to run once the server stopped serving requests
+func (app *Application) OnStop(hook func()) {
+	app.stopHooks = append(app.stopHooks, hook)
 }
 
+// serve until the server fails or the process is asked to stop with SIGINT or SIGTERM.
+// The in-flight requests finish before the stop hooks run
 func (app Application) Run(addr string) error {
-	return app.EchoInstance.Start(addr)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- app.EchoInstance.Start(addr)
+	}()
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	var err error
+	select {
+	case err = <-serverErr:
+	case <-quit:
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = app.EchoInstance.Shutdown(ctx)
+	}
+	for i := len(app.stopHooks) - 1; i >= 0; i-- {
+		app.stopHooks[i]()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,20 @@
+package database
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// dedicated connection LISTENing to the channels, it reconnects on its own.
+// a nil notification is received once reconnected, whatever was notified meanwhile is lost
+func Listen(uri string, channels ...string) (*pq.Listener, error) {
+	listener := pq.NewListener(uri, 10*time.Second, time.Minute, nil)
+	for _, channel := range channels {
+		if err := listener.Listen(channel); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
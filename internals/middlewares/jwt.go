@@ -3,6 +3,10 @@ package middlewares
 import (
 	"errors"
 	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"net/http"
 
 	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
@@ -11,10 +15,32 @@ import (
 )
 
 // @param{kind}: "access"
-func NewJWTMiddleware(kind string) echo.MiddlewareFunc {
+// the `jti` of the token is checked against the sessions table, results are kept in sessionCache
+// so a revoked session may still get through on other replicas until its cache entry expires
+func NewJWTMiddleware(kind string, sessions repositories.SessionQueries, sessionCache *services.CacheService[string, bool]) echo.MiddlewareFunc {
+	return echojwt.WithConfig(newJWTConfig(kind, sessions, sessionCache))
+}
+
+// same as NewJWTMiddleware but requests without a token go through anonymously,
+// handlers can tell them apart since there is no user in the context.
+// a token that is present but invalid is still rejected
+func NewOptionalJWTMiddleware(kind string, sessions repositories.SessionQueries, sessionCache *services.CacheService[string, bool]) echo.MiddlewareFunc {
+	config := newJWTConfig(kind, sessions, sessionCache)
+	config.ContinueOnIgnoredError = true
+	config.ErrorHandler = func(c echo.Context, err error) error {
+		var extractionErr *echojwt.TokenExtractionError
+		if errors.As(err, &extractionErr) {
+			return nil
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt").SetInternal(err)
+	}
+	return echojwt.WithConfig(config)
+}
+
+func newJWTConfig(kind string, sessions repositories.SessionQueries, sessionCache *services.CacheService[string, bool]) echojwt.Config {
 	jwtSecret := viper.GetViper().GetString(fmt.Sprintf("jwt.%s_secret", kind))
 
-	return echojwt.WithConfig(echojwt.Config{
+	return echojwt.Config{
 		ContextKey:  "user",
 		TokenLookup: "header:Authorization:Bearer ",
 		SigningKey:  []byte(jwtSecret),
@@ -39,7 +65,27 @@ func NewJWTMiddleware(kind string) echo.MiddlewareFunc {
 			if !ok {
 				return nil, errors.New("unexpected jwt format")
 			}
+			if content.ID == "" {
+				return nil, utils.ErrorInvalidToken
+			}
+			active, found := sessionCache.Get(content.ID)
+			if !found {
+				session, err := sessions.GetByJTI(content.ID)
+				switch {
+				case err == nil:
+					active = session.Active() && session.UserID == int64(content.Claims)
+				case errors.Is(err, utils.ErrorRecordsNotFound):
+					active = false
+				default:
+					return nil, err
+				}
+				sessionCache.Set(content.ID, active)
+			}
+			if !active {
+				return nil, utils.ErrorSessionRevoked
+			}
+			c.Set("session", content.ID)
 			return content.Claims, nil
 		},
-	})
+	}
 }
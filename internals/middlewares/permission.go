@@ -0,0 +1,36 @@
+package middlewares
+
+import (
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// for permissions that don't depend on a resource owner (book:create, role:manage...).
+// ownership based checks are done inside the handlers through Router.authorize
+func NewPermissionRequireMiddleware(repository repositories.RoleQueries, permission string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			jwtService := services.JWTService{}
+			userId, err := jwtService.RetrieveUserIdFromContext(c)
+			if err != nil {
+				log.Println(fmt.Errorf("can't find user id inside context object: %v", err))
+				return utils.ErrorUnauthorized
+			}
+			permissions, err := repository.GetUserPermissions(int64(userId))
+			if err != nil {
+				log.Println(fmt.Errorf("can't find user permissions: %v", err))
+				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			}
+			if !utils.IsItemInCollection(permission, permissions) && !utils.IsItemInCollection(permission+repositories.PermissionScopeAny, permissions) {
+				return utils.ErrorForbiddenResource
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// feedback on a range of a chapter content, only visible to the people working on the book.
+// annotations are re-anchored every time the content changes, the ones whose text
+// can't be found anymore are flagged as orphaned instead of being deleted
+type Annotation struct {
+	ID                int64               `db:"id" json:"id"`
+	ContentID         int64               `db:"content_id" json:"-"`
+	UserID            int64               `db:"user_id" json:"-"`
+	User              *PublicUser         `json:"user"`
+	Anchor            services.TextAnchor `json:"anchor"`
+	Body              string              `db:"body" json:"body"`
+	OrphanedAt        *time.Time          `db:"orphaned_at" json:"orphanedAt"`
+	OrphanedVersionID *int64              `db:"orphaned_version_id" json:"orphanedVersionId"`
+	ResolvedAt        *time.Time          `db:"resolved_at" json:"resolvedAt"`
+	CreatedAt         *time.Time          `db:"created_at" json:"createdAt"`
+	UpdatedAt         *time.Time          `db:"updated_at" json:"updatedAt"`
+}
+
+type AnnotationQueries interface {
+	Insert(annotation *Annotation) error
+	Get(id int64) (*Annotation, error)
+	Update(annotation *Annotation) error
+	Delete(id int64) error
+	FindByContent(contentID int64, includeResolved bool) ([]*Annotation, error)
+}
+
+type AnnotationRepository struct {
+	DB *sqlx.DB
+}
+
+const annotationColumns = `
+	a.id, a.content_id, a.user_id, a.paragraph_index, a.paragraph_anchor, a.start_offset, a.end_offset, a.quote,
+	a.body, a.orphaned_at, a.orphaned_version_id, a.resolved_at, a.created_at, a.updated_at, u.username
+`
+
+func scanAnnotation(row interface{ Scan(...any) error }) (*Annotation, error) {
+	annotation := new(Annotation)
+	annotation.User = new(PublicUser)
+	err := row.Scan(
+		&annotation.ID,
+		&annotation.ContentID,
+		&annotation.UserID,
+		&annotation.Anchor.Paragraph,
+		&annotation.Anchor.Anchor,
+		&annotation.Anchor.Start,
+		&annotation.Anchor.End,
+		&annotation.Anchor.Quote,
+		&annotation.Body,
+		&annotation.OrphanedAt,
+		&annotation.OrphanedVersionID,
+		&annotation.ResolvedAt,
+		&annotation.CreatedAt,
+		&annotation.UpdatedAt,
+		&annotation.User.Username,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	annotation.User.ID = annotation.UserID
+	return annotation, nil
+}
+
+// the anchor is expected to come from services.AnchorService.Locate on the current text
+func (m AnnotationRepository) Insert(annotation *Annotation) error {
+	statement := `
+		INSERT INTO annotations (content_id, user_id, paragraph_index, paragraph_anchor, start_offset, end_offset, quote, body)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		annotation.ContentID, annotation.UserID,
+		annotation.Anchor.Paragraph, annotation.Anchor.Anchor, annotation.Anchor.Start, annotation.Anchor.End, annotation.Anchor.Quote,
+		annotation.Body,
+	}
+	return m.DB.QueryRowContext(ctx, statement, args...).Scan(&annotation.ID, &annotation.CreatedAt)
+}
+
+func (m AnnotationRepository) Get(id int64) (*Annotation, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `SELECT ` + annotationColumns + `
+		FROM annotations a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanAnnotation(m.DB.QueryRowContext(ctx, statement, id))
+}
+
+// edit the body & resolution, the anchor is only moved by content changes
+func (m AnnotationRepository) Update(annotation *Annotation) error {
+	statement := `
+		UPDATE annotations SET body = $2, resolved_at = $3, updated_at = $4
+		WHERE id = $1
+		RETURNING updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{annotation.ID, annotation.Body, annotation.ResolvedAt, pq.FormatTimestamp(time.Now().UTC())}
+	if err := m.DB.QueryRowContext(ctx, statement, args...).Scan(&annotation.UpdatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordsNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m AnnotationRepository) Delete(id int64) error {
+	if id < 1 {
+		return utils.ErrorRecordsNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM annotations WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+// annotations in reading order, orphaned ones last
+func (m AnnotationRepository) FindByContent(contentID int64, includeResolved bool) ([]*Annotation, error) {
+	statement := `SELECT ` + annotationColumns + `
+		FROM annotations a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.content_id = $1 AND (a.resolved_at IS NULL OR $2)
+		ORDER BY a.orphaned_at IS NOT NULL, a.paragraph_index ASC, a.start_offset ASC, a.id ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, contentID, includeResolved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	annotations := []*Annotation{}
+	for rows.Next() {
+		annotation, err := scanAnnotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, annotation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return annotations, nil
+}
+
+// move every annotation of the content onto its new text.
+// must be called inside the transaction overwriting the content, versionID being the snapshot of the previous text
+func reanchorAnnotations(ctx context.Context, tx *sqlx.Tx, contentID int64, text string, versionID int64) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, paragraph_index, paragraph_anchor, start_offset, end_offset, quote
+		FROM annotations
+		WHERE content_id = $1
+		FOR UPDATE
+	`, contentID)
+	if err != nil {
+		return err
+	}
+	type anchored struct {
+		id     int64
+		anchor services.TextAnchor
+	}
+	annotations := []anchored{}
+	for rows.Next() {
+		var a anchored
+		if err := rows.Scan(&a.id, &a.anchor.Paragraph, &a.anchor.Anchor, &a.anchor.Start, &a.anchor.End, &a.anchor.Quote); err != nil {
+			rows.Close()
+			return err
+		}
+		annotations = append(annotations, a)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	anchorService := services.NewAnchorService()
+	paragraphs := anchorService.Paragraphs(text)
+	now := pq.FormatTimestamp(time.Now().UTC())
+	for _, a := range annotations {
+		anchor, found := anchorService.Reanchor(a.anchor, paragraphs)
+		if !found {
+			// keep the first snapshot it went missing from, later saves don't bring it closer
+			_, err = tx.ExecContext(ctx, `
+				UPDATE annotations SET orphaned_at = COALESCE(orphaned_at, $2),
+				orphaned_version_id = CASE WHEN orphaned_at IS NULL THEN $3 ELSE orphaned_version_id END
+				WHERE id = $1
+			`, a.id, now, versionID)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE annotations
+				SET paragraph_index = $2, paragraph_anchor = $3, start_offset = $4, end_offset = $5,
+				orphaned_at = NULL, orphaned_version_id = NULL
+				WHERE id = $1
+			`, a.id, anchor.Paragraph, anchor.Anchor, anchor.Start, anchor.End)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
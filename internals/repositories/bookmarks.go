@@ -0,0 +1,188 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// named position in a chapter content, private to the reader who set it.
+// like the reading progress, UpdatedAt comes from the device & the most recent edit wins
+type Bookmark struct {
+	ID        int64            `db:"id" json:"id"`
+	UserID    int64            `db:"user_id" json:"-"`
+	BookID    int64            `db:"book_id" json:"bookId"`
+	ChapterID int64            `db:"chapter_id" json:"chapterId"`
+	Chapter   *ProgressChapter `json:"chapter"`
+	Name      string           `db:"name" json:"name"`
+	Position  int              `db:"position" json:"position"`
+	CreatedAt *time.Time       `db:"created_at" json:"createdAt"`
+	UpdatedAt time.Time        `db:"updated_at" json:"updatedAt"`
+}
+
+type BookmarkQueries interface {
+	Insert(bookmark *Bookmark) error
+	Get(id int64) (*Bookmark, error)
+	Update(bookmark *Bookmark) (bool, error)
+	Delete(id int64) error
+	Find(userID int64, bookID int64, chapterID int64, filter Filter) ([]*Bookmark, Metadata, error)
+}
+
+type BookmarkRepository struct {
+	DB *sqlx.DB
+}
+
+const bookmarkColumns = `
+	bm.id, bm.user_id, bm.book_id, bm.chapter_id, bm.name, bm.position, bm.created_at, bm.updated_at, ch.chapter_no, ch.title
+`
+
+func scanBookmark(row interface{ Scan(...any) error }, dest ...any) (*Bookmark, error) {
+	bookmark := new(Bookmark)
+	bookmark.Chapter = new(ProgressChapter)
+	dest = append(dest,
+		&bookmark.ID,
+		&bookmark.UserID,
+		&bookmark.BookID,
+		&bookmark.ChapterID,
+		&bookmark.Name,
+		&bookmark.Position,
+		&bookmark.CreatedAt,
+		&bookmark.UpdatedAt,
+		&bookmark.Chapter.ChapterNO,
+		&bookmark.Chapter.Title,
+	)
+	if err := row.Scan(dest...); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	bookmark.Chapter.ID = bookmark.ChapterID
+	return bookmark, nil
+}
+
+func (m BookmarkRepository) Insert(bookmark *Bookmark) error {
+	statement := `
+		INSERT INTO bookmarks (user_id, book_id, chapter_id, name, position, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		bookmark.UserID, bookmark.BookID, bookmark.ChapterID, bookmark.Name, bookmark.Position,
+		pq.FormatTimestamp(bookmark.UpdatedAt.UTC()),
+	}
+	return m.DB.QueryRowContext(ctx, statement, args...).Scan(&bookmark.ID, &bookmark.CreatedAt)
+}
+
+// bookmarks of deleted chapters are not found
+func (m BookmarkRepository) Get(id int64) (*Bookmark, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `SELECT ` + bookmarkColumns + `
+		FROM bookmarks bm
+		JOIN chapters ch ON ch.id = bm.chapter_id AND ch.deleted_at IS NULL
+		WHERE bm.id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanBookmark(m.DB.QueryRowContext(ctx, statement, id))
+}
+
+// last write wins, same as ReadingProgressQueries.Save
+func (m BookmarkRepository) Update(bookmark *Bookmark) (bool, error) {
+	statement := `
+		UPDATE bookmarks SET chapter_id = $2, name = $3, position = $4, updated_at = $5
+		WHERE id = $1 AND updated_at < $5
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		bookmark.ID, bookmark.ChapterID, bookmark.Name, bookmark.Position,
+		pq.FormatTimestamp(bookmark.UpdatedAt.UTC()),
+	}
+	result, err := m.DB.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	stored, err := m.Get(bookmark.ID)
+	if err != nil {
+		return false, err
+	}
+	*bookmark = *stored
+	return rowAffected > 0, nil
+}
+
+func (m BookmarkRepository) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM bookmarks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+// bookmarks of a user in a book, optionally in 1 chapter when chapterID isn't 0
+func (m BookmarkRepository) Find(userID int64, bookID int64, chapterID int64, filter Filter) ([]*Bookmark, Metadata, error) {
+	order := fmt.Sprintf("bm.%s %s", filter.SortColumn(), filter.SortDirection())
+	if filter.SortColumn() == "position" {
+		// reading order
+		order = fmt.Sprintf("ch.chapter_no %[1]s, bm.position %[1]s", filter.SortDirection())
+	}
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM bookmarks bm
+		JOIN chapters ch ON ch.id = bm.chapter_id AND ch.deleted_at IS NULL
+		WHERE bm.user_id = $1 AND bm.book_id = $2 AND (bm.chapter_id = $3 OR $3 = 0)
+		ORDER BY %s, bm.id ASC
+		LIMIT $4
+		OFFSET $5
+	`, bookmarkColumns, order)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, userID, bookID, chapterID, filter.Limit(), filter.Offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	bookmarks := []*Bookmark{}
+	totalRecords := 0
+	for rows.Next() {
+		bookmark, err := scanBookmark(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return bookmarks, CalculateMetadata(totalRecords, filter.PageSize, filter.Page), nil
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"gin_stuff/internals/services"
 	"gin_stuff/internals/utils"
 	"time"
 
@@ -13,55 +14,145 @@ import (
 )
 
 type Book struct {
-	ID          int64      `db:"id" json:"id"`
-	UserID      int64      `db:"user_id" json:"-"`
-	User        *User      `json:"-"`
-	Title       string     `db:"title" json:"title"`
-	Description string     `db:"description" json:"description"`
-	CreatedAt   *time.Time `db:"created_at" json:"createdAt"`
-	UpdatedAt   *time.Time `db:"updated_at" json:"updatedAt"`
-	DeletedAt   *time.Time `db:"deleted_at" json:"deletedAt"`
+	ID              int64       `db:"id" json:"id"`
+	UserID          int64       `db:"user_id" json:"-"`
+	User            *User       `json:"-"`
+	Author          *PublicUser `json:"author,omitempty"`
+	Title           string      `db:"title" json:"title"`
+	Description     string      `db:"description" json:"description"`
+	Status          string      `db:"status" json:"status"`
+	PublishedAt     *time.Time  `db:"published_at" json:"publishedAt"`
+	Genres          []string    `json:"genres"`
+	Tags            []string    `json:"tags"`
+	RatingAverage   float64     `db:"rating_average" json:"ratingAverage"`
+	RatingCount     int64       `db:"rating_count" json:"ratingCount"`
+	SubscriberCount *int64      `json:"subscriberCount,omitempty"`
+	Subscribed      *bool       `json:"subscribed,omitempty"`
+	CreatedAt       *time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt       *time.Time  `db:"updated_at" json:"updatedAt"`
+	DeletedAt       *time.Time  `db:"deleted_at" json:"deletedAt"`
 }
 
+// anyone can open a book that isn't a draft
+func (b Book) Visible() bool {
+	return b.Status != "" && b.Status != PublicationStatusDraft
+}
+
+// only published books show up in listings
+func (b Book) Listed() bool {
+	return b.Status == PublicationStatusPublished
+}
+
+// books must have every included genre & tag and none of the excluded ones.
+// genres are slugs, tags are expected to be resolved already (see TagQueries.Resolve)
+type BookTaxonomyFilter struct {
+	Genres        []string
+	ExcludeGenres []string
+	Tags          []string
+	ExcludeTags   []string
+}
+
+// conditions on the `b` books alias, placeholders start at $first
+func (f BookTaxonomyFilter) conditions(first int) (string, []interface{}) {
+	statement := fmt.Sprintf(`
+		AND (SELECT count(*) FROM book_genres bg JOIN genres g ON g.id = bg.genre_id
+			WHERE bg.book_id = b.id AND g.slug = ANY($%[1]d)) = cardinality($%[1]d::TEXT[])
+		AND NOT EXISTS (SELECT 1 FROM book_genres bg JOIN genres g ON g.id = bg.genre_id
+			WHERE bg.book_id = b.id AND g.slug = ANY($%[2]d))
+		AND (SELECT count(*) FROM book_tags bt JOIN tags t ON t.id = bt.tag_id
+			WHERE bt.book_id = b.id AND t.name = ANY($%[3]d)) = cardinality($%[3]d::TEXT[])
+		AND NOT EXISTS (SELECT 1 FROM book_tags bt JOIN tags t ON t.id = bt.tag_id
+			WHERE bt.book_id = b.id AND t.name = ANY($%[4]d))
+	`, first, first+1, first+2, first+3)
+	// utils.Unique never returns nil, a nil array would be sent as NULL
+	args := []interface{}{
+		pq.Array(utils.Unique(f.Genres)),
+		pq.Array(utils.Unique(f.ExcludeGenres)),
+		pq.Array(utils.Unique(f.Tags)),
+		pq.Array(utils.Unique(f.ExcludeTags)),
+	}
+	return statement, args
+}
+
+// genre slugs & tag names of the `b` books alias
+const bookTaxonomyColumns = `
+	ARRAY(SELECT g.slug FROM book_genres bg JOIN genres g ON g.id = bg.genre_id WHERE bg.book_id = b.id ORDER BY g.slug),
+	ARRAY(SELECT t.name FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE bt.book_id = b.id ORDER BY t.name)
+`
+
 type BookQueries interface {
-	Insert(book *Book) error
+	Insert(book *Book, genres []string, tags []string) error
+	Import(book *Book, chapters []*Chapter) error
 	Get(id int64) (*Book, error)
-	Update(book *Book) error
+	Update(book *Book, genres []string, tags []string) error
 	Delete(id int64) error
-	Find(userID int, title string, filter Filter) ([]*Book, Metadata, error)
+	Find(userID int, title string, statuses []string, taxonomy BookTaxonomyFilter, filter Filter) ([]*Book, Metadata, error)
+	FindPublished(authorID int64, title string, taxonomy BookTaxonomyFilter, filter Filter) ([]*Book, Metadata, error)
 }
 
 type BookRepository struct {
 	DB *sqlx.DB
 }
 
-// find all book of 1 user
+// find all book of 1 user, optionally only the ones in some publication statuses
 // might want to make something more usecase-specific instead of this one giant, error prone api
-func (m BookRepository) Find(userId int, title string, filter Filter) ([]*Book, Metadata, error) {
+func (m BookRepository) Find(userId int, title string, statuses []string, taxonomy BookTaxonomyFilter, filter Filter) ([]*Book, Metadata, error) {
 	if userId <= 0 {
 		return nil, Metadata{}, utils.ErrorUnauthorized
 	}
+	conditions, taxonomyArgs := taxonomy.conditions(6)
 	statement := fmt.Sprintf(`
-		SELECT count(*) OVER(), b.id, b.created_at, b.updated_at, b.deleted_at, b.title, b.description
+		SELECT count(*) OVER(), b.id, b.created_at, b.updated_at, b.deleted_at, b.title, b.description, b.status, b.published_at,
+		b.rating_average, b.rating_count, u.id, u.username,
+		%s
 		FROM books b
 		JOIN users u ON b.user_id = u.id
-		WHERE u.id = $1
+		WHERE u.id = $1 AND b.deleted_at IS NULL
 		AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $2) OR $2= '')
-		ORDER BY %s %s, b.id ASC
+		AND (b.status::TEXT = ANY($3) OR COALESCE(cardinality($3::TEXT[]), 0) = 0)
+		%s
+		ORDER BY b.%s %s, b.id ASC
+		LIMIT $4
+		OFFSET $5
+	`, bookTaxonomyColumns, conditions, filter.SortColumn(), filter.SortDirection())
+	args := []interface{}{userId, title, pq.Array(statuses), filter.Limit(), filter.Offset()}
+	return m.find(statement, filter, append(args, taxonomyArgs...)...)
+}
+
+// public catalog: published books of every author, or of 1 author if authorID is set
+func (m BookRepository) FindPublished(authorID int64, title string, taxonomy BookTaxonomyFilter, filter Filter) ([]*Book, Metadata, error) {
+	conditions, taxonomyArgs := taxonomy.conditions(5)
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), b.id, b.created_at, b.updated_at, b.deleted_at, b.title, b.description, b.status, b.published_at,
+		b.rating_average, b.rating_count, u.id, u.username,
+		%s
+		FROM books b
+		JOIN users u ON b.user_id = u.id
+		WHERE b.status = 'published' AND b.deleted_at IS NULL
+		AND (u.id = $1 OR $1 = 0)
+		AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $2) OR $2= '')
+		%s
+		ORDER BY b.%s %s NULLS LAST, b.id ASC
 		LIMIT $3
 		OFFSET $4
-	`, filter.SortColumn(), filter.SortDirection())
+	`, bookTaxonomyColumns, conditions, filter.SortColumn(), filter.SortDirection())
+	args := []interface{}{authorID, title, filter.Limit(), filter.Offset()}
+	return m.find(statement, filter, append(args, taxonomyArgs...)...)
+}
+
+func (m BookRepository) find(statement string, filter Filter, args ...interface{}) ([]*Book, Metadata, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	args := []interface{}{userId, title, filter.Limit(), filter.Offset()}
 	rows, err := m.DB.QueryContext(ctx, statement, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
+	defer rows.Close()
 	books := []*Book{}
 	totalRecords := 0
 	for rows.Next() {
 		var book Book
+		book.Author = new(PublicUser)
 		err := rows.Scan(
 			&totalRecords,
 			&book.ID,
@@ -70,10 +161,19 @@ func (m BookRepository) Find(userId int, title string, filter Filter) ([]*Book,
 			&book.DeletedAt,
 			&book.Title,
 			&book.Description,
+			&book.Status,
+			&book.PublishedAt,
+			&book.RatingAverage,
+			&book.RatingCount,
+			&book.Author.ID,
+			&book.Author.Username,
+			pq.Array(&book.Genres),
+			pq.Array(&book.Tags),
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		book.UserID = book.Author.ID
 		books = append(books, &book)
 	}
 	if err = rows.Err(); err != nil {
@@ -82,18 +182,121 @@ func (m BookRepository) Find(userId int, title string, filter Filter) ([]*Book,
 	return books, CalculateMetadata(totalRecords, filter.PageSize, filter.Page), nil
 }
 
-func (m BookRepository) Insert(book *Book) error {
+// create the book along with its genres & tags, see setBookTaxonomy
+func (m BookRepository) Insert(book *Book, genres []string, tags []string) error {
+	if book.Status == "" {
+		book.Status = PublicationStatusDraft
+	}
+	if !utils.IsItemInCollection(book.Status, PublicationStatuses) {
+		return utils.ErrorInvalidModel
+	}
 	statement := `
-		INSERT INTO books (title, description, user_id)
-		VALUES ($1, $2, $3)
-		RETURNING id, created_at, user_id
+		INSERT INTO books (title, description, user_id, status, published_at)
+		VALUES ($1, $2, $3, $4, CASE WHEN $5 THEN CURRENT_TIMESTAMP END)
+		RETURNING id, created_at, user_id, status, published_at
 	`
-	args := []interface{}{book.Title, book.Description, book.User.ID}
+	args := []interface{}{book.Title, book.Description, book.User.ID, book.Status, book.Status == PublicationStatusPublished}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := m.DB.QueryRowContext(ctx, statement, args...)
-	return row.Scan(&book.ID, &book.CreatedAt, &book.UserID)
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, statement, args...)
+	err = row.Scan(&book.ID, &book.CreatedAt, &book.UserID, &book.Status, &book.PublishedAt)
+	if err != nil {
+		return err
+	}
+	if err := setBookTaxonomy(ctx, tx, book.ID, genres, tags); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	book.Genres, book.Tags = []string{}, []string{}
+	book.Author = &PublicUser{ID: book.User.ID, Username: book.User.Username}
+	return nil
+}
+
+// create the book with all its chapters & their content at once, nothing is created when anything fails.
+// the chapters are numbered in order & share the status of the book, the ones without content get none
+func (m BookRepository) Import(book *Book, chapters []*Chapter) error {
+	if book.Status == "" {
+		book.Status = PublicationStatusDraft
+	}
+	if !utils.IsItemInCollection(book.Status, PublicationStatuses) {
+		return utils.ErrorInvalidModel
+	}
+	// rendered before the transaction starts, it doesn't need to be held meanwhile
+	for _, chapter := range chapters {
+		if chapter.Content == nil || chapter.Content.TextContent == "" {
+			continue
+		}
+		if chapter.Content.Format == "" {
+			chapter.Content.Format = services.ContentFormatPlain
+		}
+		chapter.Content.HTML = services.NewContentService().Render(chapter.Content.TextContent, chapter.Content.Format)
+	}
+	bookStatement := `
+		INSERT INTO books (title, description, user_id, status, published_at)
+		VALUES ($1, $2, $3, $4, CASE WHEN $5 THEN CURRENT_TIMESTAMP END)
+		RETURNING id, created_at, user_id, status, published_at
+	`
+	chapterStatement := `
+		INSERT INTO chapters (book_id, author_id, chapter_no, title, description, status, published_at)
+		VALUES($1, $2, $3, $4, $5, $6, CASE WHEN $7 THEN CURRENT_TIMESTAMP END)
+		RETURNING id, status, published_at, created_at
+	`
+	contentStatement := `
+		INSERT INTO contents (chapter_id, text_content, format, html)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	// a whole manuscript is a lot more than the usual single row
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	published := book.Status == PublicationStatusPublished
+	row := tx.QueryRowContext(ctx, bookStatement, book.Title, book.Description, book.User.ID, book.Status, published)
+	if err := row.Scan(&book.ID, &book.CreatedAt, &book.UserID, &book.Status, &book.PublishedAt); err != nil {
+		return err
+	}
+	for index, chapter := range chapters {
+		chapter.BookID = book.ID
+		chapter.AuthorID = book.UserID
+		chapter.ChapterNO = int64(index + 1)
+		chapter.Status = book.Status
+		args := []interface{}{
+			chapter.BookID, chapter.AuthorID, chapter.ChapterNO, chapter.Title, chapter.Description, chapter.Status, published,
+		}
+		row := tx.QueryRowContext(ctx, chapterStatement, args...)
+		if err := row.Scan(&chapter.ID, &chapter.Status, &chapter.PublishedAt, &chapter.CreatedAt); err != nil {
+			return err
+		}
+		if chapter.Content == nil || chapter.Content.TextContent == "" {
+			chapter.Content = nil
+			continue
+		}
+		chapter.Content.ChapterID = chapter.ID
+		row = tx.QueryRowContext(ctx, contentStatement, chapter.ID, chapter.Content.TextContent, chapter.Content.Format, chapter.Content.HTML)
+		if err := row.Scan(&chapter.Content.ID, &chapter.Content.CreatedAt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	book.Genres, book.Tags = []string{}, []string{}
+	book.Author = &PublicUser{ID: book.User.ID, Username: book.User.Username}
+	return nil
 }
 
 func (m BookRepository) Get(id int64) (*Book, error) {
@@ -101,7 +304,9 @@ func (m BookRepository) Get(id int64) (*Book, error) {
 		return nil, utils.ErrorRecordsNotFound
 	}
 	statement := `
-		SELECT b.id, b.title, b.description, b.created_at, b.updated_at, u.id, u.username, u.email
+		SELECT b.id, b.title, b.description, b.status, b.published_at, b.rating_average, b.rating_count,
+		b.created_at, b.updated_at, u.id, u.username, u.email,
+		` + bookTaxonomyColumns + `
 		FROM books b
 		JOIN users u
 		ON b.user_id = u.id
@@ -119,11 +324,17 @@ func (m BookRepository) Get(id int64) (*Book, error) {
 		&book.ID,
 		&book.Title,
 		&book.Description,
+		&book.Status,
+		&book.PublishedAt,
+		&book.RatingAverage,
+		&book.RatingCount,
 		&book.CreatedAt,
 		&book.UpdatedAt,
 		&book.User.ID,
 		&book.User.Username,
 		&book.User.Email,
+		pq.Array(&book.Genres),
+		pq.Array(&book.Tags),
 	)
 	if err != nil {
 		switch {
@@ -134,21 +345,56 @@ func (m BookRepository) Get(id int64) (*Book, error) {
 		}
 	}
 	book.UserID = book.User.ID // set UserID since scanning does not automatically do this
+	book.Author = &PublicUser{ID: book.User.ID, Username: book.User.Username}
 	return book, nil
 }
 
-func (m BookRepository) Update(b *Book) error {
+// published_at is set the first time the book gets published. The genres & tags are replaced
+// in the same transaction, see setBookTaxonomy
+func (m BookRepository) Update(b *Book, genres []string, tags []string) error {
+	if !utils.IsItemInCollection(b.Status, PublicationStatuses) {
+		return utils.ErrorInvalidModel
+	}
 	statement := `
 		UPDATE books
-		SET title=$1, description=$2, updated_at=$3
-		WHERE id=$4
-		RETURNING title, description, updated_at
+		SET title=$1, description=$2, updated_at=$3, status=$4,
+		published_at = CASE WHEN $6 AND published_at IS NULL THEN $3 ELSE published_at END
+		WHERE id=$5
+		RETURNING title, description, status, published_at, updated_at
 	`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	args := []interface{}{b.Title, b.Description, pq.FormatTimestamp(time.Now().UTC()), b.ID}
-	row := m.DB.QueryRowContext(ctx, statement, args...)
-	return row.Scan(&b.Title, &b.Description, &b.UpdatedAt)
+	args := []interface{}{b.Title, b.Description, pq.FormatTimestamp(time.Now().UTC()), b.Status, b.ID, b.Status == PublicationStatusPublished}
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, statement, args...)
+	if err := row.Scan(&b.Title, &b.Description, &b.Status, &b.PublishedAt, &b.UpdatedAt); err != nil {
+		return err
+	}
+	if err := setBookTaxonomy(ctx, tx, b.ID, genres, tags); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replace the genres & tags of the book, nil lists are left untouched
+func setBookTaxonomy(ctx context.Context, tx *sqlx.Tx, bookID int64, genres []string, tags []string) error {
+	if genres != nil {
+		if err := setBookGenres(ctx, tx, bookID, genres); err != nil {
+			return err
+		}
+	}
+	if tags != nil {
+		if err := setBookTags(ctx, tx, bookID, tags); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (m BookRepository) Delete(id int64) error {
@@ -3,3 +3,53 @@ package repositories
 // CONSTANT
 
 var UserStatuses = []string{"active", "idle", "deleted"}
+
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+)
+
+var TokenPurposes = []string{TokenPurposeEmailVerification, TokenPurposePasswordReset}
+
+// publication state of books & chapters
+const (
+	PublicationStatusDraft     = "draft"
+	PublicationStatusPublished = "published"
+	PublicationStatusUnlisted  = "unlisted"
+	PublicationStatusArchived  = "archived"
+)
+
+var PublicationStatuses = []string{PublicationStatusDraft, PublicationStatusPublished, PublicationStatusUnlisted, PublicationStatusArchived}
+
+// default shelves every reader gets, a book sits on at most 1 of them
+const (
+	ShelfKindReading    = "reading"
+	ShelfKindWantToRead = "want_to_read"
+	ShelfKindFinished   = "finished"
+	ShelfKindDropped    = "dropped"
+)
+
+var ShelfKinds = []string{ShelfKindReading, ShelfKindWantToRead, ShelfKindFinished, ShelfKindDropped}
+
+// what a notification is about
+const (
+	NotificationTypeNewChapter   = "new_chapter"
+	NotificationTypeCommentReply = "comment_reply"
+	NotificationTypeNewFollower  = "new_follower"
+	NotificationTypeInvitation   = "collaborator_invitation"
+)
+
+var NotificationTypes = []string{NotificationTypeNewChapter, NotificationTypeCommentReply, NotificationTypeNewFollower, NotificationTypeInvitation}
+
+// what is pushed to the live stream
+const (
+	EventTypeNotification     = "notification"
+	EventTypeChapterPublished = "chapter_published"
+)
+
+// delivery state of an email of the outbox
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusDead    = "dead"
+)
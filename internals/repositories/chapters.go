@@ -23,16 +23,24 @@ type Chapter struct {
 	Title       string     `db:"title" json:"title"`
 	Content     *Content   `json:"content"`
 	Description string     `db:"description" json:"description"`
+	Status      string     `db:"status" json:"status"`
+	PublishedAt *time.Time `db:"published_at" json:"publishedAt"`
 	CreatedAt   *time.Time `db:"created_at" json:"createdAt"`
 	UpdatedAt   *time.Time `db:"updated_at" json:"updatedAt"`
 	DeletedAt   *time.Time `db:"deleted_at" json:"deletedAt"`
 }
 
+// readers can open a chapter that isn't a draft, as long as its book is visible too
+func (ch Chapter) Visible() bool {
+	return ch.Status != "" && ch.Status != PublicationStatusDraft && ch.Book != nil && ch.Book.Visible()
+}
+
 type ChapterQueries interface {
 	Insert(chapter *Chapter) error
 	Get(chapterNo int64, bookId int64) (*Chapter, error)
+	GetByID(id int64) (*Chapter, error)
 	Update(chapter *Chapter) error
-	Find(bookId int64, title string, filter Filter) ([]*Chapter, Metadata, error)
+	Find(bookId int64, title string, statuses []string, filter Filter) ([]*Chapter, Metadata, error)
 	Delete(id int64) error
 }
 
@@ -40,29 +48,33 @@ type ChapterRepository struct {
 	DB *sqlx.DB
 }
 
-func (m ChapterRepository) Find(bookId int64, title string, filter Filter) ([]*Chapter, Metadata, error) {
+// chapters of a book, optionally only the ones in some publication statuses
+func (m ChapterRepository) Find(bookId int64, title string, statuses []string, filter Filter) ([]*Chapter, Metadata, error) {
 	if bookId < 1 {
 		return nil, Metadata{}, utils.ErrorRecordsNotFound
 	}
 	statement := fmt.Sprintf(`
-		SELECT count(*) OVER(), ch.id, ch.created_at, ch.updated_at, ch.deleted_at, ch.chapter_no, ch.title, ch.description, u.id, u.username, b.id
+		SELECT count(*) OVER(), ch.id, ch.created_at, ch.updated_at, ch.deleted_at, ch.chapter_no, ch.title, ch.description,
+		ch.status, ch.published_at, u.id, u.username, b.id, b.status
 		FROM chapters ch
 		JOIN users u ON u.id = ch.author_id
 		JOIN books b ON b.id = ch.book_id
 		WHERE b.id = $1 AND ch.deleted_at IS NULL
 		AND (to_tsvector('simple', ch.title) @@ plainto_tsquery('simple', $2) OR $2 = '')
-		ORDER BY %s %s, ch.chapter_no ASC
-		LIMIT $3
-		OFFSET $4
+		AND (ch.status::TEXT = ANY($3) OR COALESCE(cardinality($3::TEXT[]), 0) = 0)
+		ORDER BY ch.%s %s, ch.chapter_no ASC
+		LIMIT $4
+		OFFSET $5
 	`, filter.SortColumn(), filter.SortDirection())
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{bookId, title, filter.Limit(), filter.Offset()}
+	args := []interface{}{bookId, title, pq.Array(statuses), filter.Limit(), filter.Offset()}
 	rows, err := m.DB.QueryContext(ctx, statement, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
+	defer rows.Close()
 	chapters := []*Chapter{}
 	totalRecords := 0
 	for rows.Next() {
@@ -78,9 +90,12 @@ func (m ChapterRepository) Find(bookId int64, title string, filter Filter) ([]*C
 			&chapter.ChapterNO,
 			&chapter.Title,
 			&chapter.Description,
+			&chapter.Status,
+			&chapter.PublishedAt,
 			&chapter.Author.ID,
 			&chapter.Author.Username,
 			&chapter.Book.ID,
+			&chapter.Book.Status,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -97,7 +112,7 @@ func (m ChapterRepository) Find(bookId int64, title string, filter Filter) ([]*C
 
 func (m ChapterRepository) Insert(chapter *Chapter) error {
 	if chapter.ChapterNO == 0 {
-		chapters, _, err := m.Find(chapter.BookID, "", Filter{
+		chapters, _, err := m.Find(chapter.BookID, "", nil, Filter{
 			SortSafeList: []string{"-chapter_no"},
 			Sort:         "-chapter_no",
 			PageSize:     math.MaxInt,
@@ -112,19 +127,28 @@ func (m ChapterRepository) Insert(chapter *Chapter) error {
 			chapter.ChapterNO = 1
 		}
 	}
+	if chapter.Status == "" {
+		chapter.Status = PublicationStatusDraft
+	}
+	if !utils.IsItemInCollection(chapter.Status, PublicationStatuses) {
+		return utils.ErrorInvalidModel
+	}
 	// this should create chapter only and the content will be added in later
 	statement := `
-		INSERT INTO chapters (book_id, author_id, chapter_no, title, description)
-		VALUES($1, $2, $3, $4, $5)
-		RETURNING id, created_at
+		INSERT INTO chapters (book_id, author_id, chapter_no, title, description, status, published_at)
+		VALUES($1, $2, $3, $4, $5, $6, CASE WHEN $7 THEN CURRENT_TIMESTAMP END)
+		RETURNING id, status, published_at, created_at
 	`
 
-	args := []interface{}{chapter.BookID, chapter.AuthorID, chapter.ChapterNO, chapter.Title, chapter.Description}
+	args := []interface{}{
+		chapter.BookID, chapter.AuthorID, chapter.ChapterNO, chapter.Title, chapter.Description,
+		chapter.Status, chapter.Status == PublicationStatusPublished,
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	row := m.DB.QueryRowContext(ctx, statement, args...)
-	return row.Scan(&chapter.ID, &chapter.CreatedAt)
+	return row.Scan(&chapter.ID, &chapter.Status, &chapter.PublishedAt, &chapter.CreatedAt)
 }
 
 // this get by the uniqe index, not the id. Personally I dont know what to do with it :(
@@ -135,8 +159,8 @@ func (m ChapterRepository) Get(chapterNo int64, bookId int64) (*Chapter, error)
 
 	statement := `
 		SELECT ch.id, ch.title, ch.chapter_no,
-		ch.description, ch.created_at, ch.updated_at,
-		b.id, b.title, b.description,
+		ch.description, ch.status, ch.published_at, ch.created_at, ch.updated_at,
+		b.id, b.user_id, b.title, b.description, b.status,
 		u.id, u.username, u.status, u.email
 		FROM chapters ch
 		JOIN books b ON b.id = ch.book_id
@@ -152,9 +176,9 @@ func (m ChapterRepository) Get(chapterNo int64, bookId int64) (*Chapter, error)
 	chapter.Book = new(Book)
 	row := m.DB.QueryRowContext(ctx, statement, chapterNo, bookId)
 	err := row.Scan(
-		&chapter.ID, &chapter.Title, &chapter.ChapterNO, &chapter.Description,
-		&chapter.CreatedAt, &chapter.UpdatedAt, &chapter.Book.ID, &chapter.Book.Title,
-		&chapter.Book.Description, &chapter.Author.ID, &chapter.Author.Username, &chapter.Author.Status, &chapter.Author.Email,
+		&chapter.ID, &chapter.Title, &chapter.ChapterNO, &chapter.Description, &chapter.Status, &chapter.PublishedAt,
+		&chapter.CreatedAt, &chapter.UpdatedAt, &chapter.Book.ID, &chapter.Book.UserID, &chapter.Book.Title,
+		&chapter.Book.Description, &chapter.Book.Status, &chapter.Author.ID, &chapter.Author.Username, &chapter.Author.Status, &chapter.Author.Email,
 	)
 	if err != nil {
 		switch {
@@ -170,19 +194,66 @@ func (m ChapterRepository) Get(chapterNo int64, bookId int64) (*Chapter, error)
 	return chapter, nil
 }
 
+// get by the chapter primary key, used by the routes under /chapter/:chapterUID
+func (m ChapterRepository) GetByID(id int64) (*Chapter, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+
+	statement := `
+		SELECT ch.id, ch.title, ch.chapter_no,
+		ch.description, ch.status, ch.published_at, ch.created_at, ch.updated_at,
+		b.id, b.user_id, b.title, b.description, b.status,
+		u.id, u.username, u.status, u.email
+		FROM chapters ch
+		JOIN books b ON b.id = ch.book_id
+		JOIN users u ON u.id = ch.author_id
+		WHERE ch.id = $1 AND ch.deleted_at IS NULL AND b.deleted_at IS NULL
+		LIMIT 1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	chapter := new(Chapter)
+	chapter.Author = new(User)
+	chapter.Book = new(Book)
+	row := m.DB.QueryRowContext(ctx, statement, id)
+	err := row.Scan(
+		&chapter.ID, &chapter.Title, &chapter.ChapterNO, &chapter.Description, &chapter.Status, &chapter.PublishedAt,
+		&chapter.CreatedAt, &chapter.UpdatedAt, &chapter.Book.ID, &chapter.Book.UserID, &chapter.Book.Title,
+		&chapter.Book.Description, &chapter.Book.Status, &chapter.Author.ID, &chapter.Author.Username, &chapter.Author.Status, &chapter.Author.Email,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	chapter.AuthorID = chapter.Author.ID
+	chapter.BookID = chapter.Book.ID
+	return chapter, nil
+}
+
+// published_at is set the first time the chapter gets published
 func (m ChapterRepository) Update(ch *Chapter) error {
+	if !utils.IsItemInCollection(ch.Status, PublicationStatuses) {
+		return utils.ErrorInvalidModel
+	}
 	statement := `
 		UPDATE chapters
-		SET title=$2, description=$3, updated_at=$4
+		SET title=$2, description=$3, updated_at=$4, status=$5,
+		published_at = CASE WHEN $6 AND published_at IS NULL THEN $4 ELSE published_at END
 		WHERE id=$1
-		RETURNING title, description, chapter_no, updated_at
+		RETURNING title, description, chapter_no, status, published_at, updated_at
 	`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{ch.ID, ch.Title, ch.Description, pq.FormatTimestamp(time.Now().UTC())}
+	args := []interface{}{ch.ID, ch.Title, ch.Description, pq.FormatTimestamp(time.Now().UTC()), ch.Status, ch.Status == PublicationStatusPublished}
 	row := m.DB.QueryRowContext(ctx, statement, args...)
-	return row.Scan(&ch.Title, &ch.Description, &ch.ChapterNO, &ch.UpdatedAt)
+	return row.Scan(&ch.Title, &ch.Description, &ch.ChapterNO, &ch.Status, &ch.PublishedAt, &ch.UpdatedAt)
 }
 
 func (m ChapterRepository) Delete(id int64) error {
@@ -190,7 +261,7 @@ func (m ChapterRepository) Delete(id int64) error {
 		return utils.ErrorRecordsNotFound
 	}
 	statement := `
-		UPDATE chapter
+		UPDATE chapters
 		SET deleted_at=$2
 		WHERE id=$1
 	`
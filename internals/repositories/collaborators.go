@@ -0,0 +1,254 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	CollaboratorRoleCoAuthor   = "co-author"
+	CollaboratorRoleEditor     = "editor"
+	CollaboratorRoleBetaReader = "beta-reader"
+
+	CollaboratorStatusPending  = "pending"
+	CollaboratorStatusAccepted = "accepted"
+	CollaboratorStatusDeclined = "declined"
+)
+
+var CollaboratorRoles = []string{CollaboratorRoleCoAuthor, CollaboratorRoleEditor, CollaboratorRoleBetaReader}
+
+// what an accepted collaborator can do on the book, on top of their own roles
+var CollaboratorPermissions = map[string][]string{
+	CollaboratorRoleCoAuthor:   {PermissionBookRead, PermissionChapterWrite, PermissionChapterDelete},
+	CollaboratorRoleEditor:     {PermissionBookRead, PermissionChapterWrite},
+	CollaboratorRoleBetaReader: {PermissionBookRead},
+}
+
+// Email is only shown to the people managing the collaborators of the book
+type Collaborator struct {
+	ID          int64       `db:"id" json:"id"`
+	BookID      int64       `db:"book_id" json:"bookId"`
+	Book        *Book       `json:"book,omitempty"`
+	UserID      int64       `db:"user_id" json:"userId"`
+	User        *PublicUser `json:"user,omitempty"`
+	Email       string      `json:"email,omitempty"`
+	InvitedBy   int64       `db:"invited_by" json:"invitedBy"`
+	Role        string      `db:"role" json:"role"`
+	Status      string      `db:"status" json:"status"`
+	CreatedAt   *time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt   *time.Time  `db:"updated_at" json:"updatedAt"`
+	RespondedAt *time.Time  `db:"responded_at" json:"respondedAt"`
+}
+
+type CollaboratorQueries interface {
+	Insert(collaborator *Collaborator) error
+	Get(id int64) (*Collaborator, error)
+	GetRole(bookID int64, userID int64) (string, error)
+	FindByBook(bookID int64) ([]*Collaborator, error)
+	FindByUser(userID int64, status string) ([]*Collaborator, error)
+	UpdateStatus(collaborator *Collaborator) error
+	Delete(id int64) error
+}
+
+type CollaboratorRepository struct {
+	DB *sqlx.DB
+}
+
+const collaboratorColumns = `
+	bc.id, bc.book_id, bc.user_id, bc.invited_by, bc.role, bc.status, bc.created_at, bc.updated_at, bc.responded_at,
+	b.id, b.user_id, b.title, b.description,
+	u.id, u.username, u.email
+`
+
+func scanCollaborator(row interface{ Scan(...any) error }) (*Collaborator, error) {
+	collaborator := new(Collaborator)
+	collaborator.Book = new(Book)
+	collaborator.User = new(PublicUser)
+	err := row.Scan(
+		&collaborator.ID,
+		&collaborator.BookID,
+		&collaborator.UserID,
+		&collaborator.InvitedBy,
+		&collaborator.Role,
+		&collaborator.Status,
+		&collaborator.CreatedAt,
+		&collaborator.UpdatedAt,
+		&collaborator.RespondedAt,
+		&collaborator.Book.ID,
+		&collaborator.Book.UserID,
+		&collaborator.Book.Title,
+		&collaborator.Book.Description,
+		&collaborator.User.ID,
+		&collaborator.User.Username,
+		&collaborator.Email,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	return collaborator, nil
+}
+
+// invite a user, inviting them again resets the invitation to pending with the new role.
+// utils.ErrorRecordExisted if they already accepted, accepted collaborators are left as they are
+func (m CollaboratorRepository) Insert(collaborator *Collaborator) error {
+	if !utils.IsItemInCollection(collaborator.Role, CollaboratorRoles) {
+		return utils.ErrorInvalidModel
+	}
+	statement := `
+		INSERT INTO book_collaborators (book_id, user_id, invited_by, role)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (book_id, user_id) DO UPDATE
+		SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by, status = 'pending', responded_at = NULL, updated_at = $5
+		WHERE book_collaborators.status <> 'accepted'
+		RETURNING id, status, created_at, updated_at
+	`
+	args := []interface{}{collaborator.BookID, collaborator.UserID, collaborator.InvitedBy, collaborator.Role, pq.FormatTimestamp(time.Now().UTC())}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, statement, args...)
+	err := row.Scan(&collaborator.ID, &collaborator.Status, &collaborator.CreatedAt, &collaborator.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordExisted
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CollaboratorRepository) Get(id int64) (*Collaborator, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `SELECT ` + collaboratorColumns + `
+		FROM book_collaborators bc
+		JOIN books b ON b.id = bc.book_id
+		JOIN users u ON u.id = bc.user_id
+		WHERE bc.id = $1 AND b.deleted_at IS NULL
+		LIMIT 1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanCollaborator(m.DB.QueryRowContext(ctx, statement, id))
+}
+
+// role of an accepted collaborator, utils.ErrorRecordsNotFound if the user isn't one
+func (m CollaboratorRepository) GetRole(bookID int64, userID int64) (string, error) {
+	statement := `
+		SELECT role FROM book_collaborators
+		WHERE book_id = $1 AND user_id = $2 AND status = 'accepted'
+		LIMIT 1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var role string
+	err := m.DB.QueryRowContext(ctx, statement, bookID, userID).Scan(&role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", utils.ErrorRecordsNotFound
+		default:
+			return "", err
+		}
+	}
+	return role, nil
+}
+
+func (m CollaboratorRepository) FindByBook(bookID int64) ([]*Collaborator, error) {
+	statement := `SELECT ` + collaboratorColumns + `
+		FROM book_collaborators bc
+		JOIN books b ON b.id = bc.book_id
+		JOIN users u ON u.id = bc.user_id
+		WHERE bc.book_id = $1
+		ORDER BY bc.created_at ASC
+	`
+	return m.find(statement, bookID)
+}
+
+// invitations / collaborations of 1 user, every status if status is empty
+func (m CollaboratorRepository) FindByUser(userID int64, status string) ([]*Collaborator, error) {
+	statement := `SELECT ` + collaboratorColumns + `
+		FROM book_collaborators bc
+		JOIN books b ON b.id = bc.book_id
+		JOIN users u ON u.id = bc.user_id
+		WHERE bc.user_id = $1 AND b.deleted_at IS NULL
+		AND (bc.status::TEXT = $2 OR $2 = '')
+		ORDER BY bc.created_at DESC
+	`
+	return m.find(statement, userID, status)
+}
+
+func (m CollaboratorRepository) UpdateStatus(collaborator *Collaborator) error {
+	statement := `
+		UPDATE book_collaborators
+		SET status = $2, responded_at = $3, updated_at = $3
+		WHERE id = $1
+		RETURNING status, responded_at, updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, statement, collaborator.ID, collaborator.Status, pq.FormatTimestamp(time.Now().UTC()))
+	return row.Scan(&collaborator.Status, &collaborator.RespondedAt, &collaborator.UpdatedAt)
+}
+
+func (m CollaboratorRepository) Delete(id int64) error {
+	if id < 1 {
+		return utils.ErrorRecordsNotFound
+	}
+	statement := `DELETE FROM book_collaborators WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, statement, id)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+func (m CollaboratorRepository) find(statement string, args ...interface{}) ([]*Collaborator, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	collaborators := []*Collaborator{}
+	for rows.Next() {
+		collaborator, err := scanCollaborator(rows)
+		if err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, collaborator)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return collaborators, nil
+}
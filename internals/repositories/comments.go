@@ -0,0 +1,279 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// comment on a chapter, replies point to their parent comment.
+// deleted comments are kept (without their content) as long as they have replies
+type Comment struct {
+	ID         int64       `db:"id" json:"id"`
+	ChapterID  int64       `db:"chapter_id" json:"chapterId"`
+	ParentID   *int64      `db:"parent_id" json:"parentId"`
+	UserID     int64       `db:"user_id" json:"-"`
+	User       *PublicUser `json:"user"`
+	Content    string      `db:"content" json:"content"`
+	LikeCount  int64       `db:"like_count" json:"likeCount"`
+	ReplyCount int64       `json:"replyCount"`
+	Liked      bool        `json:"liked"`
+	PinnedAt   *time.Time  `db:"pinned_at" json:"pinnedAt"`
+	CreatedAt  *time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt  *time.Time  `db:"updated_at" json:"updatedAt"`
+	DeletedAt  *time.Time  `db:"deleted_at" json:"deletedAt"`
+}
+
+type CommentQueries interface {
+	Insert(comment *Comment) error
+	Get(id int64, viewerID int64) (*Comment, error)
+	Update(comment *Comment) error
+	Delete(id int64) error
+	Find(chapterID int64, parentID int64, viewerID int64, filter Filter) ([]*Comment, Metadata, error)
+	Like(id int64, userID int64) error
+	Unlike(id int64, userID int64) error
+	Pin(id int64, pinned bool) error
+}
+
+type CommentRepository struct {
+	DB *sqlx.DB
+}
+
+// $1 must be the id of the user viewing the comments, 0 when anonymous
+const commentColumns = `
+	cm.id, cm.chapter_id, cm.parent_id, cm.user_id, cm.content, cm.like_count, cm.pinned_at,
+	cm.created_at, cm.updated_at, cm.deleted_at, u.username,
+	(SELECT count(*) FROM comments r WHERE r.parent_id = cm.id AND r.deleted_at IS NULL),
+	EXISTS (SELECT 1 FROM comment_likes cl WHERE cl.comment_id = cm.id AND cl.user_id = $1)
+`
+
+func scanComment(row interface{ Scan(...any) error }, dest ...any) (*Comment, error) {
+	comment := new(Comment)
+	comment.User = new(PublicUser)
+	dest = append(dest,
+		&comment.ID,
+		&comment.ChapterID,
+		&comment.ParentID,
+		&comment.UserID,
+		&comment.Content,
+		&comment.LikeCount,
+		&comment.PinnedAt,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.DeletedAt,
+		&comment.User.Username,
+		&comment.ReplyCount,
+		&comment.Liked,
+	)
+	if err := row.Scan(dest...); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	comment.User.ID = comment.UserID
+	if comment.DeletedAt != nil {
+		comment.Content = ""
+	}
+	return comment, nil
+}
+
+// utils.ErrorRecordsNotFound when replying to a comment that is deleted or on another chapter
+func (m CommentRepository) Insert(comment *Comment) error {
+	statement := `
+		INSERT INTO comments (chapter_id, user_id, parent_id, content)
+		SELECT $1, $2, $3, $4
+		WHERE $3::INT IS NULL OR EXISTS (
+			SELECT 1 FROM comments WHERE id = $3 AND chapter_id = $1 AND deleted_at IS NULL
+		)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{comment.ChapterID, comment.UserID, comment.ParentID, comment.Content}
+	err := m.DB.QueryRowContext(ctx, statement, args...).Scan(&comment.ID, &comment.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordsNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// viewerID tells whether the comment is liked by that user
+func (m CommentRepository) Get(id int64, viewerID int64) (*Comment, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `SELECT ` + commentColumns + `
+		FROM comments cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.id = $2
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanComment(m.DB.QueryRowContext(ctx, statement, viewerID, id))
+}
+
+// edit the content, deleted comments can't be edited
+func (m CommentRepository) Update(comment *Comment) error {
+	statement := `
+		UPDATE comments SET content = $2, updated_at = $3
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, statement, comment.ID, comment.Content, pq.FormatTimestamp(time.Now().UTC()))
+	if err := row.Scan(&comment.UpdatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordsNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// soft delete, a deleted comment is unpinned
+func (m CommentRepository) Delete(id int64) error {
+	statement := `
+		UPDATE comments SET deleted_at = $2, pinned_at = NULL
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, statement, id, pq.FormatTimestamp(time.Now().UTC()))
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+// top level comments of a chapter, or the replies of parentID. Pinned comments come first
+func (m CommentRepository) Find(chapterID int64, parentID int64, viewerID int64, filter Filter) ([]*Comment, Metadata, error) {
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM comments cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.chapter_id = $2
+		AND (cm.parent_id = $3 OR ($3 = 0 AND cm.parent_id IS NULL))
+		AND (cm.deleted_at IS NULL OR EXISTS (
+			SELECT 1 FROM comments r WHERE r.parent_id = cm.id AND r.deleted_at IS NULL
+		))
+		ORDER BY cm.pinned_at IS NULL, cm.%s %s, cm.id ASC
+		LIMIT $4
+		OFFSET $5
+	`, commentColumns, filter.SortColumn(), filter.SortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, viewerID, chapterID, parentID, filter.Limit(), filter.Offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	comments := []*Comment{}
+	totalRecords := 0
+	for rows.Next() {
+		comment, err := scanComment(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comments = append(comments, comment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return comments, CalculateMetadata(totalRecords, filter.PageSize, filter.Page), nil
+}
+
+// liking twice is a no-op
+func (m CommentRepository) Like(id int64, userID int64) error {
+	return m.toggleLike(`
+		INSERT INTO comment_likes (comment_id, user_id)
+		SELECT id, $2 FROM comments WHERE id = $1 AND deleted_at IS NULL
+		ON CONFLICT DO NOTHING
+	`, `UPDATE comments SET like_count = like_count + 1 WHERE id = $1`, id, userID)
+}
+
+func (m CommentRepository) Unlike(id int64, userID int64) error {
+	return m.toggleLike(
+		`DELETE FROM comment_likes WHERE comment_id = $1 AND user_id = $2`,
+		`UPDATE comments SET like_count = like_count - 1 WHERE id = $1`,
+		id, userID,
+	)
+}
+
+// the counter is only updated when the like statement actually changed something
+func (m CommentRepository) toggleLike(likeStatement string, countStatement string, id int64, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, likeStatement, id, userID)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, countStatement, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m CommentRepository) Pin(id int64, pinned bool) error {
+	statement := `
+		UPDATE comments SET pinned_at = CASE WHEN $2 THEN $3::TIMESTAMP END
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, statement, id, pinned, pq.FormatTimestamp(time.Now().UTC()))
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
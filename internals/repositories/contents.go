@@ -4,10 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"gin_stuff/internals/services"
 	"gin_stuff/internals/utils"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type Content struct {
@@ -15,6 +17,8 @@ type Content struct {
 	ChapterID   int64      `db:"chapter_id" json:"-"`
 	Chapter     *Chapter   `json:"chapter"`
 	TextContent string     `db:"text_content" json:"textContent"`
+	Format      string     `db:"format" json:"format"`       // see services.ContentFormats
+	HTML        string     `db:"html" json:"html,omitempty"` // the text rendered to sanitized HTML when it was saved
 	CreatedAt   *time.Time `db:"created_at" json:"createdAt"`
 	UpdatedAt   *time.Time `db:"updated_at" json:"updatedAt"`
 	DeletedAt   *time.Time `db:"deleted_at" json:"deletedAt"`
@@ -23,7 +27,10 @@ type Content struct {
 type ContentQueries interface {
 	Insert(*Content) error
 	Get(int64) (*Content, error)
-	Update(*Content) error
+	Update(content *Content, userID int64) error
+	Restore(content *Content, versionID int64, userID int64) error
+	FindWithoutHTML(limit int) ([]*Content, error)
+	SaveHTML(content *Content) error
 }
 
 type ContentRepository struct {
@@ -31,16 +38,20 @@ type ContentRepository struct {
 }
 
 func (m ContentRepository) Insert(content *Content) error {
-	if len(content.TextContent) <= 0 {
+	if content.Format == "" {
+		content.Format = services.ContentFormatPlain
+	}
+	if len(content.TextContent) <= 0 || !utils.IsItemInCollection(content.Format, services.ContentFormats) {
 		return utils.ErrorInvalidModel
 	}
 	statement := `
-        INSERT INTO contents (chapter_id, text_content)
-        VALUES ($1, $2)
+        INSERT INTO contents (chapter_id, text_content, format, html)
+        VALUES ($1, $2, $3, $4)
         RETURNING id, created_at
     `
 
-	args := []interface{}{content.ChapterID, content.TextContent}
+	content.HTML = services.NewContentService().Render(content.TextContent, content.Format)
+	args := []interface{}{content.ChapterID, content.TextContent, content.Format, content.HTML}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -56,8 +67,8 @@ func (m ContentRepository) Get(chapterID int64) (*Content, error) {
 	}
 	statement := `
         SELECT
-            ct.id, ct.chapter_id, ct.text_content, ct.created_at, ct.updated_at,
-            ch.id, ch.title, ch.chapter_no, ch.description, ch.created_at, ch.updated_at, ch.author_id
+            ct.id, ct.chapter_id, ct.text_content, ct.format, COALESCE(ct.html, ''), ct.created_at, ct.updated_at,
+            ch.id, ch.book_id, ch.title, ch.chapter_no, ch.description, ch.created_at, ch.updated_at, ch.author_id
         FROM contents ct
         JOIN chapters ch ON ch.id = ct.chapter_id
         WHERE ch.id = $1 AND ch.deleted_at IS NULL
@@ -70,8 +81,8 @@ func (m ContentRepository) Get(chapterID int64) (*Content, error) {
 	content.Chapter = new(Chapter)
 	row := m.DB.QueryRowContext(ctx, statement, chapterID)
 	err := row.Scan(
-		&content.ID, &content.ChapterID, &content.TextContent, &content.CreatedAt, &content.UpdatedAt,
-		&content.Chapter.ID, &content.Chapter.Title, &content.Chapter.ChapterNO, &content.Chapter.Description, &content.Chapter.CreatedAt, &content.Chapter.UpdatedAt,
+		&content.ID, &content.ChapterID, &content.TextContent, &content.Format, &content.HTML, &content.CreatedAt, &content.UpdatedAt,
+		&content.Chapter.ID, &content.Chapter.BookID, &content.Chapter.Title, &content.Chapter.ChapterNO, &content.Chapter.Description, &content.Chapter.CreatedAt, &content.Chapter.UpdatedAt, &content.Chapter.AuthorID,
 	)
 	if err != nil {
 		switch {
@@ -84,17 +95,125 @@ func (m ContentRepository) Get(chapterID int64) (*Content, error) {
 	return content, nil
 }
 
-func (m ContentRepository) Update(content *Content) error {
+// overwrite the text content & its format, the previous ones are kept in chapter_versions
+func (m ContentRepository) Update(content *Content, userID int64) error {
+	if !utils.IsItemInCollection(content.Format, services.ContentFormats) {
+		return utils.ErrorInvalidModel
+	}
 	statement := `
         UPDATE contents
-        SET text_content = $1
-        WHERE id = $2
-        RETURNING text_content, updated_at
+        SET text_content = $1, format = $2, html = $3, updated_at = $4
+        WHERE id = $5
+        RETURNING text_content, format, updated_at
     `
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{content.ID, content.TextContent}
-	row := m.DB.QueryRowContext(ctx, statement, args...)
-	return row.Scan(&content.TextContent, &content.UpdatedAt)
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	versionID, err := snapshotContent(ctx, tx, content.ID, userID)
+	if err != nil {
+		return err
+	}
+	content.HTML = services.NewContentService().Render(content.TextContent, content.Format)
+	args := []interface{}{content.TextContent, content.Format, content.HTML, pq.FormatTimestamp(time.Now().UTC()), content.ID}
+	row := tx.QueryRowContext(ctx, statement, args...)
+	if err := row.Scan(&content.TextContent, &content.Format, &content.UpdatedAt); err != nil {
+		return err
+	}
+	if err := reanchorAnnotations(ctx, tx, content.ID, content.TextContent, versionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// set the text & format of a previous version as the current content.
+// the text being replaced is snapshotted as well so a restore can be undone
+func (m ContentRepository) Restore(content *Content, restoredID int64, userID int64) error {
+	statement := `
+        UPDATE contents ct
+        SET text_content = v.text_content, format = v.format, updated_at = $3
+        FROM chapter_versions v
+        WHERE ct.id = $1 AND v.id = $2 AND v.content_id = ct.id
+        RETURNING ct.text_content, ct.format, ct.updated_at
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	versionID, err := snapshotContent(ctx, tx, content.ID, userID)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{content.ID, restoredID, pq.FormatTimestamp(time.Now().UTC())}
+	row := tx.QueryRowContext(ctx, statement, args...)
+	if err := row.Scan(&content.TextContent, &content.Format, &content.UpdatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordsNotFound
+		default:
+			return err
+		}
+	}
+	content.HTML = services.NewContentService().Render(content.TextContent, content.Format)
+	if _, err := tx.ExecContext(ctx, `UPDATE contents SET html = $1 WHERE id = $2`, content.HTML, content.ID); err != nil {
+		return err
+	}
+	if err := reanchorAnnotations(ctx, tx, content.ID, content.TextContent, versionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// the contents saved before their HTML was stored along with the text, oldest first
+func (m ContentRepository) FindWithoutHTML(limit int) ([]*Content, error) {
+	statement := `
+        SELECT id, chapter_id, text_content, format
+        FROM contents
+        WHERE html IS NULL
+        ORDER BY id
+        LIMIT $1
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	contents := []*Content{}
+	for rows.Next() {
+		content := new(Content)
+		if err := rows.Scan(&content.ID, &content.ChapterID, &content.TextContent, &content.Format); err != nil {
+			return nil, err
+		}
+		contents = append(contents, content)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// store the rendered HTML of a content saved before it was rendered on save.
+// a content saved meanwhile already has the HTML of its new text, it is left as is
+func (m ContentRepository) SaveHTML(content *Content) error {
+	statement := `
+        UPDATE contents
+        SET html = $1
+        WHERE id = $2 AND html IS NULL
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, statement, content.HTML, content.ID)
+	return err
 }
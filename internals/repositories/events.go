@@ -0,0 +1,143 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// something pushed to the live stream of a user, or of every subscriber of a book.
+// inserting one wakes up the API replicas through the `events` channel, see migration 000025
+type Event struct {
+	ID        int64           `db:"id" json:"id"`
+	UserID    *int64          `db:"user_id" json:"-"`
+	BookID    *int64          `db:"book_id" json:"-"`
+	Type      string          `db:"type" json:"type"`
+	Payload   json.RawMessage `db:"payload" json:"payload"`
+	CreatedAt *time.Time      `db:"created_at" json:"createdAt"`
+}
+
+// ids are handed out when an event is inserted but it only shows up once committed, so a lower id can
+// show up after a higher one. Events are only read once older than this: Insert runs under a 3s timeout,
+// by then the transaction that inserted them committed or rolled back
+const EventCommitLag = 5 * time.Second
+
+type EventQueries interface {
+	Insert(events ...*Event) error
+	LastID() (int64, error)
+	FindSince(userID int64, afterID int64, limit int) ([]*Event, error)
+	FindSubscribed(bookID int64, userIDs []int64) ([]int64, error)
+	DeleteBefore(before time.Time) (int64, error)
+}
+
+type EventRepository struct {
+	DB *sqlx.DB
+}
+
+func (m EventRepository) Insert(events ...*Event) error {
+	statement := `
+		INSERT INTO events (user_id, book_id, type, payload) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, event := range events {
+		if event.UserID == nil && event.BookID == nil {
+			return utils.ErrorInvalidModel
+		}
+		row := tx.QueryRowContext(ctx, statement, event.UserID, event.BookID, event.Type, []byte(event.Payload))
+		if err := row.Scan(&event.ID, &event.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// id of the latest event older than EventCommitLag, where a stream starts when the client has nothing to resume from
+func (m EventRepository) LastID() (int64, error) {
+	statement := `SELECT COALESCE(max(id), 0) FROM events WHERE created_at <= LOCALTIMESTAMP - make_interval(secs => $1)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, statement, EventCommitLag.Seconds()).Scan(&id)
+	return id, err
+}
+
+// events of the user after afterID older than EventCommitLag, oldest first.
+// Events of a book only count from the moment the user subscribed to it
+func (m EventRepository) FindSince(userID int64, afterID int64, limit int) ([]*Event, error) {
+	statement := `
+		SELECT e.id, e.user_id, e.book_id, e.type, e.payload, e.created_at
+		FROM events e
+		LEFT JOIN book_subscriptions bs ON bs.book_id = e.book_id AND bs.user_id = $1
+		WHERE e.id > $2 AND (e.user_id = $1 OR bs.created_at <= e.created_at)
+			AND e.created_at <= LOCALTIMESTAMP - make_interval(secs => $4)
+		ORDER BY e.id ASC
+		LIMIT $3
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, userID, afterID, limit, EventCommitLag.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*Event{}
+	for rows.Next() {
+		event := new(Event)
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.UserID, &event.BookID, &event.Type, &payload, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// the users of userIDs that are subscribed to the book
+func (m EventRepository) FindSubscribed(bookID int64, userIDs []int64) ([]int64, error) {
+	statement := `SELECT user_id FROM book_subscriptions WHERE book_id = $1 AND user_id = ANY($2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, bookID, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// drop the events too old to be resumed from
+func (m EventRepository) DeleteBefore(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM events WHERE created_at < $1`, pq.FormatTimestamp(before.UTC()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,128 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// a book as downloaded by its readers: the book & its published chapters with their content,
+// ordered by chapter_no
+type BookExport struct {
+	Book     *Book
+	Chapters []*Chapter
+}
+
+// the EPUB of the book, see services.EpubService
+func (e BookExport) Epub() services.EpubBook {
+	book := services.EpubBook{
+		Identifier:  fmt.Sprintf("urn:novelism:book:%d", e.Book.ID),
+		Title:       e.Book.Title,
+		Description: e.Book.Description,
+		Author:      e.Book.Author.Username,
+		Chapters:    make([]services.EpubChapter, 0, len(e.Chapters)),
+	}
+	content := services.NewContentService()
+	for _, chapter := range e.Chapters {
+		epubChapter := services.EpubChapter{Title: chapter.Title, Text: chapter.Content.TextContent}
+		if chapter.Content.Format != services.ContentFormatPlain {
+			epubChapter.HTML = chapter.Content.HTML
+			if epubChapter.HTML == "" {
+				// saved before the HTML was stored
+				epubChapter.HTML = content.Render(chapter.Content.TextContent, chapter.Content.Format)
+			}
+		}
+		book.Chapters = append(book.Chapters, epubChapter)
+	}
+	return book
+}
+
+type ExportQueries interface {
+	Fingerprint(bookID int64) (string, error)
+	Get(bookID int64) (*BookExport, error)
+}
+
+type ExportRepository struct {
+	DB *sqlx.DB
+}
+
+// changes whenever something that ends up in the exports of the book changes,
+// exports are cached under it
+func (m ExportRepository) Fingerprint(bookID int64) (string, error) {
+	if bookID < 1 {
+		return "", utils.ErrorRecordsNotFound
+	}
+	statement := `
+		SELECT md5(concat_ws('|', b.title, b.description, b.updated_at, u.username, (
+			SELECT string_agg(concat_ws(':', ch.id, ch.chapter_no, ch.title, ch.updated_at, ct.format, ct.created_at, ct.updated_at), ',' ORDER BY ch.chapter_no)
+			FROM chapters ch
+			LEFT JOIN contents ct ON ct.chapter_id = ch.id
+			WHERE ch.book_id = b.id AND ch.status = 'published' AND ch.deleted_at IS NULL
+		)))
+		FROM books b
+		JOIN users u ON u.id = b.user_id
+		WHERE b.id = $1 AND b.deleted_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var fingerprint string
+	err := m.DB.QueryRowContext(ctx, statement, bookID).Scan(&fingerprint)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", utils.ErrorRecordsNotFound
+		default:
+			return "", err
+		}
+	}
+	return fingerprint, nil
+}
+
+// chapters without content yet are exported empty
+func (m ExportRepository) Get(bookID int64) (*BookExport, error) {
+	book, err := BookRepository{DB: m.DB}.Get(bookID)
+	if err != nil {
+		return nil, err
+	}
+	statement := `
+		SELECT ch.id, ch.chapter_no, ch.title, ch.description, ch.status, ch.published_at, ch.created_at, ch.updated_at, ch.author_id,
+		COALESCE(ct.id, 0), COALESCE(ct.text_content, ''), COALESCE(ct.format, 'plain'), COALESCE(ct.html, ''), ct.created_at, ct.updated_at
+		FROM chapters ch
+		LEFT JOIN contents ct ON ct.chapter_id = ch.id
+		WHERE ch.book_id = $1 AND ch.status = 'published' AND ch.deleted_at IS NULL
+		ORDER BY ch.chapter_no ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, book.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	export := &BookExport{Book: book, Chapters: []*Chapter{}}
+	for rows.Next() {
+		chapter := &Chapter{BookID: book.ID, Book: book, Content: &Content{}}
+		err := rows.Scan(
+			&chapter.ID, &chapter.ChapterNO, &chapter.Title, &chapter.Description, &chapter.Status, &chapter.PublishedAt,
+			&chapter.CreatedAt, &chapter.UpdatedAt, &chapter.AuthorID,
+			&chapter.Content.ID, &chapter.Content.TextContent, &chapter.Content.Format, &chapter.Content.HTML, &chapter.Content.CreatedAt, &chapter.Content.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		chapter.Content.ChapterID = chapter.ID
+		export.Chapters = append(export.Chapters, chapter)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
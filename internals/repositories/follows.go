@@ -0,0 +1,159 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// a user in a follower or following list
+type FollowUser struct {
+	ID         int64      `json:"id"`
+	Username   string     `json:"username"`
+	FollowedAt *time.Time `json:"followedAt"`
+}
+
+type FollowQueries interface {
+	Follow(followerID int64, followeeID int64) (bool, error)
+	Unfollow(followerID int64, followeeID int64) error
+	IsFollowing(followerID int64, followeeID int64) (bool, error)
+	FindFollowers(userID int64, filter Filter) ([]*FollowUser, Metadata, error)
+	FindFollowing(userID int64, filter Filter) ([]*FollowUser, Metadata, error)
+	FindFollowerIDs(userID int64) ([]int64, error)
+}
+
+type FollowRepository struct {
+	DB *sqlx.DB
+}
+
+// following someone twice is a no-op, true only when the follow is new.
+// utils.ErrorRecordsNotFound when the followee doesn't exist
+func (m FollowRepository) Follow(followerID int64, followeeID int64) (bool, error) {
+	statement := `
+		INSERT INTO follows (follower_id, followee_id)
+		SELECT $1, id FROM users WHERE id = $2
+		ON CONFLICT DO NOTHING
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, statement, followerID, followeeID)
+	if err != nil {
+		return false, err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowAffected > 0 {
+		return true, nil
+	}
+	// already followed, or no such user
+	var exists bool
+	if err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, followeeID).Scan(&exists); err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, utils.ErrorRecordsNotFound
+	}
+	return false, nil
+}
+
+func (m FollowRepository) Unfollow(followerID int64, followeeID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2`, followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+func (m FollowRepository) IsFollowing(followerID int64, followeeID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var following bool
+	statement := `SELECT EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = $2)`
+	err := m.DB.QueryRowContext(ctx, statement, followerID, followeeID).Scan(&following)
+	return following, err
+}
+
+// the users following userID
+func (m FollowRepository) FindFollowers(userID int64, filter Filter) ([]*FollowUser, Metadata, error) {
+	return m.find("f.follower_id", "f.followee_id", userID, filter)
+}
+
+// the users userID follows
+func (m FollowRepository) FindFollowing(userID int64, filter Filter) ([]*FollowUser, Metadata, error) {
+	return m.find("f.followee_id", "f.follower_id", userID, filter)
+}
+
+// listed is the column of the users in the list, owner the one matching userID
+func (m FollowRepository) find(listed string, owner string, userID int64, filter Filter) ([]*FollowUser, Metadata, error) {
+	column := "f.created_at"
+	if filter.SortColumn() == "username" {
+		column = "u.username"
+	}
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), u.id, u.username, f.created_at
+		FROM follows f
+		JOIN users u ON u.id = %s
+		WHERE %s = $1
+		ORDER BY %s %s, u.id ASC
+		LIMIT $2
+		OFFSET $3
+	`, listed, owner, column, filter.SortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, userID, filter.Limit(), filter.Offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	users := []*FollowUser{}
+	totalRecords := 0
+	for rows.Next() {
+		user := new(FollowUser)
+		if err := rows.Scan(&totalRecords, &user.ID, &user.Username, &user.FollowedAt); err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return users, CalculateMetadata(totalRecords, filter.PageSize, filter.Page), nil
+}
+
+func (m FollowRepository) FindFollowerIDs(userID int64) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT follower_id FROM follows WHERE followee_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
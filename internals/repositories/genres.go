@@ -0,0 +1,184 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// curated by admins, books refer to them by slug
+type Genre struct {
+	ID          int64      `db:"id" json:"id"`
+	Name        string     `db:"name" json:"name"`
+	Slug        string     `db:"slug" json:"slug"`
+	Description string     `db:"description" json:"description"`
+	CreatedAt   *time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt   *time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+type GenreQueries interface {
+	Find() ([]*Genre, error)
+	Get(id int64) (*Genre, error)
+	Insert(genre *Genre) error
+	Update(genre *Genre) error
+	Delete(id int64) error
+}
+
+type GenreRepository struct {
+	DB *sqlx.DB
+}
+
+const genreColumns = `id, name, slug, description, created_at, updated_at`
+
+func scanGenre(row interface{ Scan(...any) error }) (*Genre, error) {
+	genre := new(Genre)
+	err := row.Scan(&genre.ID, &genre.Name, &genre.Slug, &genre.Description, &genre.CreatedAt, &genre.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	return genre, nil
+}
+
+func (m GenreRepository) Find() ([]*Genre, error) {
+	statement := `SELECT ` + genreColumns + ` FROM genres ORDER BY name ASC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	genres := []*Genre{}
+	for rows.Next() {
+		genre, err := scanGenre(rows)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+func (m GenreRepository) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `SELECT ` + genreColumns + ` FROM genres WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanGenre(m.DB.QueryRowContext(ctx, statement, id))
+}
+
+// the slug is derived from the name when empty
+func (m GenreRepository) Insert(genre *Genre) error {
+	if genre.Slug == "" {
+		genre.Slug = utils.Slugify(genre.Name)
+	}
+	if genre.Slug == "" {
+		return utils.ErrorInvalidModel
+	}
+	statement := `
+		INSERT INTO genres (name, slug, description)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, statement, genre.Name, genre.Slug, genre.Description)
+	if err := row.Scan(&genre.ID, &genre.CreatedAt); err != nil {
+		if isUniqueViolation(err) {
+			return utils.ErrorRecordExisted
+		}
+		return err
+	}
+	return nil
+}
+
+func (m GenreRepository) Update(genre *Genre) error {
+	if genre.Slug == "" {
+		return utils.ErrorInvalidModel
+	}
+	statement := `
+		UPDATE genres
+		SET name = $2, slug = $3, description = $4, updated_at = $5
+		WHERE id = $1
+		RETURNING updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{genre.ID, genre.Name, genre.Slug, genre.Description, pq.FormatTimestamp(time.Now().UTC())}
+	if err := m.DB.QueryRowContext(ctx, statement, args...).Scan(&genre.UpdatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordsNotFound
+		case isUniqueViolation(err):
+			return utils.ErrorRecordExisted
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// books lose the genre as well
+func (m GenreRepository) Delete(id int64) error {
+	if id < 1 {
+		return utils.ErrorRecordsNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+// replace every genre of the book, utils.ErrorRecordsNotFound if one of the slugs isn't a genre.
+// genres are only ever set along with the book they belong to, see BookRepository.Insert
+func setBookGenres(ctx context.Context, tx *sqlx.Tx, bookID int64, slugs []string) error {
+	slugs = utils.Unique(slugs)
+	if _, err := tx.ExecContext(ctx, `DELETE FROM book_genres WHERE book_id = $1`, bookID); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO book_genres (book_id, genre_id)
+		SELECT $1, id FROM genres WHERE slug = ANY($2)
+	`, bookID, pq.Array(slugs))
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected != int64(len(slugs)) {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
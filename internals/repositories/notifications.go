@@ -0,0 +1,301 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// something that happened to a user, see NotificationTypes.
+// the ids point to what the notification is about, depending on its type
+type Notification struct {
+	ID             int64       `db:"id" json:"id"`
+	UserID         int64       `db:"user_id" json:"-"`
+	Type           string      `db:"type" json:"type"`
+	ActorID        *int64      `db:"actor_id" json:"-"`
+	Actor          *PublicUser `json:"actor"`
+	BookID         *int64      `db:"book_id" json:"bookId"`
+	ChapterID      *int64      `db:"chapter_id" json:"chapterId"`
+	CommentID      *int64      `db:"comment_id" json:"commentId"`
+	CollaboratorID *int64      `db:"collaborator_id" json:"collaboratorId"`
+	Message        string      `db:"message" json:"message"`
+	ReadAt         *time.Time  `db:"read_at" json:"readAt"`
+	CreatedAt      *time.Time  `db:"created_at" json:"createdAt"`
+}
+
+// how a user wants to be told about a type of notification
+type NotificationPreference struct {
+	Type  string `db:"type" json:"type"`
+	InApp bool   `db:"in_app" json:"inApp"`
+	Email bool   `db:"email" json:"email"`
+}
+
+// delivery used until the user sets a preference, only invitations are mailed by default
+var DefaultNotificationPreferences = map[string]NotificationPreference{
+	NotificationTypeNewChapter:   {Type: NotificationTypeNewChapter, InApp: true, Email: false},
+	NotificationTypeCommentReply: {Type: NotificationTypeCommentReply, InApp: true, Email: false},
+	NotificationTypeNewFollower:  {Type: NotificationTypeNewFollower, InApp: true, Email: false},
+	NotificationTypeInvitation:   {Type: NotificationTypeInvitation, InApp: true, Email: true},
+}
+
+// a user to notify along with their preference for the type of notification
+type NotificationRecipient struct {
+	UserID     int64
+	Username   string
+	Email      string
+	Locale     string
+	Preference NotificationPreference
+}
+
+type NotificationQueries interface {
+	Insert(notification *Notification, userIDs []int64) ([]*Notification, error)
+	Get(id int64) (*Notification, error)
+	Find(userID int64, unreadOnly bool, filter Filter) ([]*Notification, Metadata, error)
+	CountUnread(userID int64) (int64, error)
+	MarkRead(userID int64, ids []int64) (int64, error)
+	FindRecipients(notificationType string, userIDs []int64) ([]*NotificationRecipient, error)
+	GetPreferences(userID int64) ([]NotificationPreference, error)
+	SetPreference(userID int64, preference NotificationPreference) error
+}
+
+type NotificationRepository struct {
+	DB *sqlx.DB
+}
+
+const notificationColumns = `
+	n.id, n.user_id, n.type, n.actor_id, n.book_id, n.chapter_id, n.comment_id, n.collaborator_id,
+	n.message, n.read_at, n.created_at, a.username
+`
+
+func scanNotification(row interface{ Scan(...any) error }, dest ...any) (*Notification, error) {
+	notification := new(Notification)
+	var actorUsername sql.NullString
+	dest = append(dest,
+		&notification.ID,
+		&notification.UserID,
+		&notification.Type,
+		&notification.ActorID,
+		&notification.BookID,
+		&notification.ChapterID,
+		&notification.CommentID,
+		&notification.CollaboratorID,
+		&notification.Message,
+		&notification.ReadAt,
+		&notification.CreatedAt,
+		&actorUsername,
+	)
+	if err := row.Scan(dest...); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	if notification.ActorID != nil && actorUsername.Valid {
+		notification.Actor = &PublicUser{ID: *notification.ActorID, Username: actorUsername.String}
+	}
+	return notification, nil
+}
+
+// copy the notification to every user of userIDs, returns the created notifications
+func (m NotificationRepository) Insert(notification *Notification, userIDs []int64) ([]*Notification, error) {
+	statement := `
+		WITH n AS (
+			INSERT INTO notifications (user_id, type, actor_id, book_id, chapter_id, comment_id, collaborator_id, message)
+			SELECT u.id, $2, $3, $4, $5, $6, $7, $8
+			FROM unnest($1::INT[]) AS u (id)
+			RETURNING *
+		)
+		SELECT ` + notificationColumns + `
+		FROM n
+		LEFT JOIN users a ON a.id = n.actor_id
+		ORDER BY n.id ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		pq.Array(utils.Unique(userIDs)), notification.Type, notification.ActorID, notification.BookID,
+		notification.ChapterID, notification.CommentID, notification.CollaboratorID, notification.Message,
+	}
+	rows, err := m.DB.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notifications := []*Notification{}
+	for rows.Next() {
+		notification, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+func (m NotificationRepository) Get(id int64) (*Notification, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `SELECT ` + notificationColumns + `
+		FROM notifications n
+		LEFT JOIN users a ON a.id = n.actor_id
+		WHERE n.id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanNotification(m.DB.QueryRowContext(ctx, statement, id))
+}
+
+func (m NotificationRepository) Find(userID int64, unreadOnly bool, filter Filter) ([]*Notification, Metadata, error) {
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM notifications n
+		LEFT JOIN users a ON a.id = n.actor_id
+		WHERE n.user_id = $1 AND (n.read_at IS NULL OR NOT $2)
+		ORDER BY n.%s %s, n.id DESC
+		LIMIT $3
+		OFFSET $4
+	`, notificationColumns, filter.SortColumn(), filter.SortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, userID, unreadOnly, filter.Limit(), filter.Offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	notifications := []*Notification{}
+	totalRecords := 0
+	for rows.Next() {
+		notification, err := scanNotification(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		notifications = append(notifications, notification)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return notifications, CalculateMetadata(totalRecords, filter.PageSize, filter.Page), nil
+}
+
+func (m NotificationRepository) CountUnread(userID int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int64
+	statement := `SELECT count(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`
+	err := m.DB.QueryRowContext(ctx, statement, userID).Scan(&count)
+	return count, err
+}
+
+// mark the notifications of ids as read, every unread notification of the user when ids is empty.
+// returns how many notifications were marked
+func (m NotificationRepository) MarkRead(userID int64, ids []int64) (int64, error) {
+	statement := `
+		UPDATE notifications SET read_at = $3
+		WHERE user_id = $1 AND read_at IS NULL
+		AND (id = ANY($2) OR COALESCE(cardinality($2::INT[]), 0) = 0)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, statement, userID, pq.Array(ids), pq.FormatTimestamp(time.Now().UTC()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// users of userIDs with their preference for notificationType, users without one get the default
+func (m NotificationRepository) FindRecipients(notificationType string, userIDs []int64) ([]*NotificationRecipient, error) {
+	statement := `
+		SELECT u.id, u.username, u.email, u.locale, p.in_app, p.email
+		FROM users u
+		LEFT JOIN notification_preferences p ON p.user_id = u.id AND p.type = $2
+		WHERE u.id = ANY($1) AND u.status <> 'deleted'
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, pq.Array(userIDs), notificationType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	recipients := []*NotificationRecipient{}
+	for rows.Next() {
+		recipient := &NotificationRecipient{Preference: DefaultNotificationPreferences[notificationType]}
+		var inApp, email sql.NullBool
+		if err := rows.Scan(&recipient.UserID, &recipient.Username, &recipient.Email, &recipient.Locale, &inApp, &email); err != nil {
+			return nil, err
+		}
+		if inApp.Valid {
+			recipient.Preference.InApp = inApp.Bool
+			recipient.Preference.Email = email.Bool
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, rows.Err()
+}
+
+// the preference of every type, in the order of NotificationTypes
+func (m NotificationRepository) GetPreferences(userID int64) ([]NotificationPreference, error) {
+	statement := `SELECT type, in_app, email FROM notification_preferences WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	set := map[string]NotificationPreference{}
+	for rows.Next() {
+		var preference NotificationPreference
+		if err := rows.Scan(&preference.Type, &preference.InApp, &preference.Email); err != nil {
+			return nil, err
+		}
+		set[preference.Type] = preference
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	preferences := make([]NotificationPreference, 0, len(NotificationTypes))
+	for _, notificationType := range NotificationTypes {
+		preference, ok := set[notificationType]
+		if !ok {
+			preference = DefaultNotificationPreferences[notificationType]
+		}
+		preferences = append(preferences, preference)
+	}
+	return preferences, nil
+}
+
+func (m NotificationRepository) SetPreference(userID int64, preference NotificationPreference) error {
+	if !utils.IsItemInCollection(preference.Type, NotificationTypes) {
+		return utils.ErrorInvalidModel
+	}
+	statement := `
+		INSERT INTO notification_preferences (user_id, type, in_app, email, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, type) DO UPDATE
+		SET in_app = EXCLUDED.in_app, email = EXCLUDED.email, updated_at = EXCLUDED.updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{userID, preference.Type, preference.InApp, preference.Email, pq.FormatTimestamp(time.Now().UTC())}
+	_, err := m.DB.ExecContext(ctx, statement, args...)
+	return err
+}
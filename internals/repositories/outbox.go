@@ -0,0 +1,255 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// an email of the outbox, see OutboxStatus*
+type OutboxMail struct {
+	ID            int64      `db:"id" json:"id"`
+	From          string     `db:"sender" json:"from"`
+	To            string     `db:"recipient" json:"to"`
+	Subject       string     `db:"subject" json:"subject"`
+	Content       string     `db:"content" json:"content"`
+	HTML          *string    `db:"html" json:"html"`
+	Status        string     `db:"status" json:"status"`
+	Attempts      int        `db:"attempts" json:"attempts"`
+	LastError     *string    `db:"last_error" json:"lastError"`
+	NextAttemptAt *time.Time `db:"next_attempt_at" json:"nextAttemptAt"`
+	SentAt        *time.Time `db:"sent_at" json:"sentAt"`
+	CreatedAt     *time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt     *time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+func (mail OutboxMail) Mail() *services.Mail {
+	return &services.Mail{
+		From:    mail.From,
+		To:      mail.To,
+		Subject: mail.Subject,
+		Content: mail.Content,
+		HTML:    utils.ValueOf(mail.HTML),
+	}
+}
+
+type OutboxQueries interface {
+	Enqueue(mails ...*services.Mail) error
+	Claim(limit int, lease time.Duration) ([]*OutboxMail, error)
+	MarkSent(id int64) error
+	MarkFailed(id int64, reason string, retryAt *time.Time) error
+	Get(id int64) (*OutboxMail, error)
+	FindDead(filter Filter) ([]*OutboxMail, Metadata, error)
+	Retry(id int64) error
+}
+
+type OutboxRepository struct {
+	DB *sqlx.DB
+}
+
+const outboxColumns = `
+	id, sender, recipient, subject, content, html, status, attempts, last_error, next_attempt_at, sent_at, created_at, updated_at
+`
+
+func scanOutboxMail(row interface{ Scan(...any) error }, dest ...any) (*OutboxMail, error) {
+	mail := new(OutboxMail)
+	dest = append(dest,
+		&mail.ID,
+		&mail.From,
+		&mail.To,
+		&mail.Subject,
+		&mail.Content,
+		&mail.HTML,
+		&mail.Status,
+		&mail.Attempts,
+		&mail.LastError,
+		&mail.NextAttemptAt,
+		&mail.SentAt,
+		&mail.CreatedAt,
+		&mail.UpdatedAt,
+	)
+	if err := row.Scan(dest...); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	return mail, nil
+}
+
+// queue the mails outside of any other change, the worker sends them shortly after
+func (m OutboxRepository) Enqueue(mails ...*services.Mail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := enqueueMails(ctx, tx, mails...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// queue the mails as part of tx, they are only sent if tx commits
+func enqueueMails(ctx context.Context, tx *sqlx.Tx, mails ...*services.Mail) error {
+	statement := `
+		INSERT INTO email_outbox (sender, recipient, subject, content, html, next_attempt_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+	`
+	// due right away, in UTC like every time the worker compares it to
+	now := pq.FormatTimestamp(time.Now().UTC())
+	for _, mail := range mails {
+		if mail == nil {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, statement, mail.From, mail.To, mail.Subject, mail.Content, mail.HTML, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// take up to limit pending mails that are due, oldest first. They are not due again before the lease
+// is over, so other workers leave them alone while they are being sent
+func (m OutboxRepository) Claim(limit int, lease time.Duration) ([]*OutboxMail, error) {
+	statement := `
+		UPDATE email_outbox
+		SET attempts = attempts + 1, next_attempt_at = $2, updated_at = $3
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = 'pending' AND next_attempt_at <= $3
+			ORDER BY next_attempt_at ASC
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxColumns
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	rows, err := m.DB.QueryContext(ctx, statement, limit, pq.FormatTimestamp(now.Add(lease)), pq.FormatTimestamp(now))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	mails := []*OutboxMail{}
+	for rows.Next() {
+		mail, err := scanOutboxMail(rows)
+		if err != nil {
+			return nil, err
+		}
+		mails = append(mails, mail)
+	}
+	return mails, rows.Err()
+}
+
+func (m OutboxRepository) MarkSent(id int64) error {
+	statement := `
+		UPDATE email_outbox SET status = 'sent', last_error = NULL, sent_at = $2, updated_at = $2
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, statement, id, pq.FormatTimestamp(time.Now().UTC()))
+	return err
+}
+
+// record why the mail could not be sent. It is tried again at retryAt, or never again when retryAt is nil
+func (m OutboxRepository) MarkFailed(id int64, reason string, retryAt *time.Time) error {
+	statement := `
+		UPDATE email_outbox
+		SET status = CASE WHEN $3::TIMESTAMP IS NULL THEN 'dead' ELSE 'pending' END,
+		next_attempt_at = COALESCE($3, next_attempt_at), last_error = $2, updated_at = $4
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var next interface{}
+	if retryAt != nil {
+		next = pq.FormatTimestamp(retryAt.UTC())
+	}
+	_, err := m.DB.ExecContext(ctx, statement, id, reason, next, pq.FormatTimestamp(time.Now().UTC()))
+	return err
+}
+
+func (m OutboxRepository) Get(id int64) (*OutboxMail, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	statement := `SELECT ` + outboxColumns + ` FROM email_outbox WHERE id = $1`
+	return scanOutboxMail(m.DB.QueryRowContext(ctx, statement, id))
+}
+
+// the dead letters, mails that ran out of attempts
+func (m OutboxRepository) FindDead(filter Filter) ([]*OutboxMail, Metadata, error) {
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM email_outbox
+		WHERE status = 'dead'
+		ORDER BY %s %s, id ASC
+		LIMIT $1
+		OFFSET $2
+	`, outboxColumns, filter.SortColumn(), filter.SortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, filter.Limit(), filter.Offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	mails := []*OutboxMail{}
+	totalRecords := 0
+	for rows.Next() {
+		mail, err := scanOutboxMail(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		mails = append(mails, mail)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return mails, CalculateMetadata(totalRecords, filter.PageSize, filter.Page), nil
+}
+
+// give a dead mail a fresh set of attempts
+func (m OutboxRepository) Retry(id int64) error {
+	statement := `
+		UPDATE email_outbox SET status = 'pending', attempts = 0, next_attempt_at = $2, updated_at = $2
+		WHERE id = $1 AND status = 'dead'
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, statement, id, pq.FormatTimestamp(time.Now().UTC()))
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
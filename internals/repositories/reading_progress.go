@@ -0,0 +1,193 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// where a user stopped reading a book.
+// UpdatedAt is when the position was recorded on the reader's device, the most recent one wins
+type ReadingProgress struct {
+	UserID     int64            `db:"user_id" json:"-"`
+	BookID     int64            `db:"book_id" json:"bookId"`
+	Book       *Book            `json:"book,omitempty"`
+	ChapterID  *int64           `db:"chapter_id" json:"chapterId"`
+	Chapter    *ProgressChapter `json:"chapter"`
+	Offset     int              `db:"scroll_offset" json:"offset"`
+	Percentage float64          `db:"percentage" json:"percentage"`
+	UpdatedAt  time.Time        `db:"updated_at" json:"updatedAt"`
+}
+
+type ProgressChapter struct {
+	ID        int64  `json:"id"`
+	ChapterNO int    `json:"chapterNo"`
+	Title     string `json:"title"`
+}
+
+type ReadingProgressQueries interface {
+	Get(userID int64, bookID int64) (*ReadingProgress, error)
+	Save(progress *ReadingProgress) (bool, error)
+	Delete(userID int64, bookID int64) error
+	FindInProgress(userID int64, filter Filter) ([]*ReadingProgress, Metadata, error)
+}
+
+type ReadingProgressRepository struct {
+	DB *sqlx.DB
+}
+
+const readingProgressColumns = `
+	rp.user_id, rp.book_id, rp.chapter_id, rp.scroll_offset, rp.percentage, rp.updated_at, ch.chapter_no, ch.title
+`
+
+func scanReadingProgress(row interface{ Scan(...any) error }, dest ...any) (*ReadingProgress, error) {
+	progress := new(ReadingProgress)
+	var chapterNo sql.NullInt64
+	var chapterTitle sql.NullString
+	dest = append(dest,
+		&progress.UserID,
+		&progress.BookID,
+		&progress.ChapterID,
+		&progress.Offset,
+		&progress.Percentage,
+		&progress.UpdatedAt,
+		&chapterNo,
+		&chapterTitle,
+	)
+	if err := row.Scan(dest...); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	// the chapter might have been deleted since
+	if progress.ChapterID != nil && chapterNo.Valid {
+		progress.Chapter = &ProgressChapter{
+			ID:        *progress.ChapterID,
+			ChapterNO: int(chapterNo.Int64),
+			Title:     chapterTitle.String,
+		}
+	}
+	return progress, nil
+}
+
+func (m ReadingProgressRepository) Get(userID int64, bookID int64) (*ReadingProgress, error) {
+	statement := `SELECT ` + readingProgressColumns + `
+		FROM reading_progress rp
+		LEFT JOIN chapters ch ON ch.id = rp.chapter_id AND ch.deleted_at IS NULL
+		WHERE rp.user_id = $1 AND rp.book_id = $2
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanReadingProgress(m.DB.QueryRowContext(ctx, statement, userID, bookID))
+}
+
+// last write wins: the progress is only stored when it is more recent than the stored one.
+// progress is then set to whatever is stored, the returned bool tells whether it was this write
+func (m ReadingProgressRepository) Save(progress *ReadingProgress) (bool, error) {
+	statement := `
+		INSERT INTO reading_progress (user_id, book_id, chapter_id, scroll_offset, percentage, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, book_id) DO UPDATE
+		SET chapter_id = EXCLUDED.chapter_id, scroll_offset = EXCLUDED.scroll_offset,
+		percentage = EXCLUDED.percentage, updated_at = EXCLUDED.updated_at
+		WHERE reading_progress.updated_at < EXCLUDED.updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		progress.UserID, progress.BookID, progress.ChapterID, progress.Offset, progress.Percentage,
+		pq.FormatTimestamp(progress.UpdatedAt.UTC()),
+	}
+	result, err := m.DB.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	stored, err := m.Get(progress.UserID, progress.BookID)
+	if err != nil {
+		return false, err
+	}
+	*progress = *stored
+	return rowAffected > 0, nil
+}
+
+func (m ReadingProgressRepository) Delete(userID int64, bookID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM reading_progress WHERE user_id = $1 AND book_id = $2`, userID, bookID)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+// "continue reading": books the user started & hasn't finished yet.
+// a book is finished once the last chapter that can be read is read to the end
+func (m ReadingProgressRepository) FindInProgress(userID int64, filter Filter) ([]*ReadingProgress, Metadata, error) {
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), b.title, b.description, b.status, b.published_at, u.id, u.username, %s
+		FROM reading_progress rp
+		JOIN books b ON b.id = rp.book_id AND b.deleted_at IS NULL
+		JOIN users u ON u.id = b.user_id
+		LEFT JOIN chapters ch ON ch.id = rp.chapter_id AND ch.deleted_at IS NULL
+		WHERE rp.user_id = $1 AND b.status <> 'draft'
+		AND NOT (rp.percentage >= 100 AND NOT EXISTS (
+			SELECT 1 FROM chapters nx
+			WHERE nx.book_id = rp.book_id AND nx.deleted_at IS NULL AND nx.status <> 'draft'
+			AND nx.chapter_no > COALESCE(ch.chapter_no, 0)
+		))
+		ORDER BY rp.%s %s, rp.book_id ASC
+		LIMIT $2
+		OFFSET $3
+	`, readingProgressColumns, filter.SortColumn(), filter.SortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, userID, filter.Limit(), filter.Offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	progresses := []*ReadingProgress{}
+	totalRecords := 0
+	for rows.Next() {
+		book := new(Book)
+		book.Author = new(PublicUser)
+		progress, err := scanReadingProgress(rows,
+			&totalRecords, &book.Title, &book.Description, &book.Status, &book.PublishedAt, &book.Author.ID, &book.Author.Username,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		book.ID = progress.BookID
+		book.UserID = book.Author.ID
+		progress.Book = book
+		progresses = append(progresses, progress)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return progresses, CalculateMetadata(totalRecords, filter.PageSize, filter.Page), nil
+}
@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type RefreshToken struct {
+	ID        int64      `db:"id" json:"id"`
+	UserID    int64      `db:"user_id" json:"userId"`
+	TokenHash string     `db:"token_hash" json:"-"`
+	FamilyID  string     `db:"family_id" json:"-"`
+	ParentID  *int64     `db:"parent_id" json:"-"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expiresAt"`
+	UsedAt    *time.Time `db:"used_at" json:"usedAt"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revokedAt"`
+	CreatedAt *time.Time `db:"created_at" json:"createdAt"`
+}
+
+type RefreshTokenQueries interface {
+	GetByHash(tokenHash string) (*RefreshToken, error)
+	RevokeFamily(familyID string) error
+}
+
+type RefreshTokenRepository struct {
+	DB *sqlx.DB
+}
+
+func (m RefreshTokenRepository) GetByHash(tokenHash string) (*RefreshToken, error) {
+	statement := `
+		SELECT id, user_id, token_hash, family_id, parent_id, expires_at, used_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		LIMIT 1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token := new(RefreshToken)
+	row := m.DB.QueryRowContext(ctx, statement, tokenHash)
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.FamilyID,
+		&token.ParentID,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// mark the current token as used & insert its successor in the same family, along with the session, see SessionRepository.Rotate.
+// returns utils.ErrorTokenReused if the current token was already used (or revoked) by someone else
+func rotateRefreshToken(ctx context.Context, tx *sqlx.Tx, current *RefreshToken, next *RefreshToken) error {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens
+		SET used_at = $2
+		WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL
+	`, current.ID, pq.FormatTimestamp(time.Now().UTC()))
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorTokenReused
+	}
+
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	next.ParentID = &current.ID
+	return insertRefreshToken(ctx, tx, next)
+}
+
+// tokens are only ever inserted along with what they belong to, a new session or the token they replace
+func insertRefreshToken(ctx context.Context, tx *sqlx.Tx, token *RefreshToken) error {
+	if token.ExpiresAt == nil {
+		return utils.ErrorInvalidModel
+	}
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, parent_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, token.UserID, token.TokenHash, token.FamilyID, token.ParentID, pq.FormatTimestamp(token.ExpiresAt.UTC()))
+	return row.Scan(&token.ID, &token.CreatedAt)
+}
+
+// revoke every token descended from the same sign-in, along with its session
+func (m RefreshTokenRepository) RevokeFamily(familyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := pq.FormatTimestamp(time.Now().UTC())
+	_, err = tx.ExecContext(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = $2
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID, now)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE sessions
+		SET revoked_at = $2
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID, now)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
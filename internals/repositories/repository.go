@@ -1,16 +1,39 @@
 package repositories
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type Repository struct {
-	User    UserQueries
-	Book    BookQueries
-	Chapter ChapterQueries
-	Content ContentQueries
+	User         UserQueries
+	Book         BookQueries
+	Chapter      ChapterQueries
+	Content      ContentQueries
+	Version      ChapterVersionQueries
+	Refresh      RefreshTokenQueries
+	Session      SessionQueries
+	Token        UserTokenQueries
+	Role         RoleQueries
+	Collaborator CollaboratorQueries
+	Genre        GenreQueries
+	Tag          TagQueries
+	Review       ReviewQueries
+	Comment      CommentQueries
+	Annotation   AnnotationQueries
+	Progress     ReadingProgressQueries
+	Bookmark     BookmarkQueries
+	Shelf        ShelfQueries
+	Follow       FollowQueries
+	Subscription SubscriptionQueries
+	Notification NotificationQueries
+	Event        EventQueries
+	StreamTicket StreamTicketQueries
+	Outbox       OutboxQueries
+	Export       ExportQueries
 }
 
 func New(db *sqlx.DB) Repository {
@@ -27,6 +50,69 @@ func New(db *sqlx.DB) Repository {
 		Content: ContentRepository{
 			DB: db,
 		},
+		Version: ChapterVersionRepository{
+			DB: db,
+		},
+		Refresh: RefreshTokenRepository{
+			DB: db,
+		},
+		Session: SessionRepository{
+			DB: db,
+		},
+		Token: UserTokenRepository{
+			DB: db,
+		},
+		Role: RoleRepository{
+			DB: db,
+		},
+		Collaborator: CollaboratorRepository{
+			DB: db,
+		},
+		Genre: GenreRepository{
+			DB: db,
+		},
+		Tag: TagRepository{
+			DB: db,
+		},
+		Review: ReviewRepository{
+			DB: db,
+		},
+		Comment: CommentRepository{
+			DB: db,
+		},
+		Annotation: AnnotationRepository{
+			DB: db,
+		},
+		Progress: ReadingProgressRepository{
+			DB: db,
+		},
+		Bookmark: BookmarkRepository{
+			DB: db,
+		},
+		Shelf: ShelfRepository{
+			DB: db,
+		},
+		Follow: FollowRepository{
+			DB: db,
+		},
+		Subscription: SubscriptionRepository{
+			DB: db,
+		},
+		Notification: NotificationRepository{
+			DB: db,
+		},
+		Event: EventRepository{
+			DB: db,
+		},
+		StreamTicket: StreamTicketRepository{
+			DB: db,
+		},
+		Outbox: OutboxRepository{
+			DB: db,
+		},
+		Export: ExportRepository{
+			DB: db,
+		},
 	}
 }
 
@@ -78,3 +164,9 @@ func (f Filter) Limit() int {
 func (f Filter) Offset() int {
 	return f.PageSize * (f.Page - 1)
 }
+
+// unique_violation, https://www.postgresql.org/docs/current/errcodes-appendix.html
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
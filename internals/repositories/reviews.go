@@ -0,0 +1,235 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// rating & review of a book, a user can only review a book once.
+// the book rating_average & rating_count are refreshed every time a review changes
+type Review struct {
+	ID        int64       `db:"id" json:"id"`
+	BookID    int64       `db:"book_id" json:"bookId"`
+	UserID    int64       `db:"user_id" json:"-"`
+	User      *PublicUser `json:"user"`
+	Rating    int         `db:"rating" json:"rating"`
+	Content   string      `db:"content" json:"content"`
+	Reply     *string     `db:"reply" json:"reply"`
+	RepliedBy *int64      `db:"replied_by" json:"repliedBy"`
+	RepliedAt *time.Time  `db:"replied_at" json:"repliedAt"`
+	CreatedAt *time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt *time.Time  `db:"updated_at" json:"updatedAt"`
+}
+
+type ReviewQueries interface {
+	Insert(review *Review) error
+	Get(id int64) (*Review, error)
+	Update(review *Review) error
+	Reply(review *Review) error
+	Delete(review *Review) error
+	Find(bookID int64, rating int, filter Filter) ([]*Review, Metadata, error)
+}
+
+type ReviewRepository struct {
+	DB *sqlx.DB
+}
+
+const reviewColumns = `
+	rv.id, rv.book_id, rv.user_id, rv.rating, rv.content, rv.reply, rv.replied_by, rv.replied_at, rv.created_at, rv.updated_at,
+	u.username
+`
+
+func scanReview(row interface{ Scan(...any) error }, dest ...any) (*Review, error) {
+	review := new(Review)
+	review.User = new(PublicUser)
+	dest = append(dest,
+		&review.ID,
+		&review.BookID,
+		&review.UserID,
+		&review.Rating,
+		&review.Content,
+		&review.Reply,
+		&review.RepliedBy,
+		&review.RepliedAt,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.User.Username,
+	)
+	if err := row.Scan(dest...); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	review.User.ID = review.UserID
+	return review, nil
+}
+
+// utils.ErrorRecordExisted if the user already reviewed the book
+func (m ReviewRepository) Insert(review *Review) error {
+	return m.withBookRating(review.BookID, func(ctx context.Context, tx *sqlx.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+			INSERT INTO reviews (book_id, user_id, rating, content)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at
+		`, review.BookID, review.UserID, review.Rating, review.Content)
+		if err := row.Scan(&review.ID, &review.CreatedAt); err != nil {
+			if isUniqueViolation(err) {
+				return utils.ErrorRecordExisted
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (m ReviewRepository) Get(id int64) (*Review, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `SELECT ` + reviewColumns + `
+		FROM reviews rv
+		JOIN users u ON u.id = rv.user_id
+		WHERE rv.id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanReview(m.DB.QueryRowContext(ctx, statement, id))
+}
+
+// edit the rating & content of the review
+func (m ReviewRepository) Update(review *Review) error {
+	return m.withBookRating(review.BookID, func(ctx context.Context, tx *sqlx.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+			UPDATE reviews SET rating = $2, content = $3, updated_at = $4
+			WHERE id = $1
+			RETURNING updated_at
+		`, review.ID, review.Rating, review.Content, pq.FormatTimestamp(time.Now().UTC()))
+		if err := row.Scan(&review.UpdatedAt); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.ErrorRecordsNotFound
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// set or, with a nil Reply, remove the reply of the book author
+func (m ReviewRepository) Reply(review *Review) error {
+	statement := `
+		UPDATE reviews
+		SET reply = $2, replied_by = $3, replied_at = CASE WHEN $2::TEXT IS NULL THEN NULL ELSE $4::TIMESTAMP END
+		WHERE id = $1
+		RETURNING reply, replied_by, replied_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{review.ID, review.Reply, review.RepliedBy, pq.FormatTimestamp(time.Now().UTC())}
+	err := m.DB.QueryRowContext(ctx, statement, args...).Scan(&review.Reply, &review.RepliedBy, &review.RepliedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordsNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ReviewRepository) Delete(review *Review) error {
+	return m.withBookRating(review.BookID, func(ctx context.Context, tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE id = $1`, review.ID)
+		if err != nil {
+			return err
+		}
+		rowAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowAffected == 0 {
+			return utils.ErrorRecordsNotFound
+		}
+		return nil
+	})
+}
+
+// reviews of a book, only the ones with the given rating unless it is 0
+func (m ReviewRepository) Find(bookID int64, rating int, filter Filter) ([]*Review, Metadata, error) {
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM reviews rv
+		JOIN users u ON u.id = rv.user_id
+		WHERE rv.book_id = $1 AND (rv.rating = $2 OR $2 = 0)
+		ORDER BY rv.%s %s, rv.id ASC
+		LIMIT $3
+		OFFSET $4
+	`, reviewColumns, filter.SortColumn(), filter.SortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, bookID, rating, filter.Limit(), filter.Offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	reviews := []*Review{}
+	totalRecords := 0
+	for rows.Next() {
+		review, err := scanReview(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return reviews, CalculateMetadata(totalRecords, filter.PageSize, filter.Page), nil
+}
+
+// run fn then refresh the rating aggregates of the book, in one transaction.
+// the book row is locked first so concurrent reviews can't miss each other
+func (m ReviewRepository) withBookRating(bookID int64, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM books WHERE id = $1 FOR UPDATE`, bookID).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.ErrorRecordsNotFound
+		}
+		return err
+	}
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE books SET
+		rating_average = (SELECT COALESCE(avg(rating), 0) FROM reviews WHERE book_id = $1),
+		rating_count = (SELECT count(*) FROM reviews WHERE book_id = $1)
+		WHERE id = $1
+	`, bookID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,167 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	RoleReader    = "reader"
+	RoleAuthor    = "author"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+
+	// role given to every new user
+	DefaultRegistrationRole = RoleAuthor
+)
+
+var Roles = []string{RoleReader, RoleAuthor, RoleModerator, RoleAdmin}
+
+// permissions without the `:any` suffix only apply to resources owned by the user
+const (
+	PermissionBookCreate         = "book:create"
+	PermissionBookRead           = "book:read"
+	PermissionBookUpdate         = "book:update"
+	PermissionBookDelete         = "book:delete"
+	PermissionChapterWrite       = "chapter:write"
+	PermissionChapterDelete      = "chapter:delete"
+	PermissionRoleManage         = "role:manage"
+	PermissionTaxonomyManage     = "taxonomy:manage"
+	PermissionReviewWrite        = "review:write"
+	PermissionReviewDelete       = "review:delete"
+	PermissionCommentWrite       = "comment:write"
+	PermissionCommentDelete      = "comment:delete"
+	PermissionEmailManage        = "email:manage"
+	PermissionCollaboratorManage = "collaborator:manage"
+	PermissionScopeAny           = ":any"
+)
+
+type Role struct {
+	ID          int64      `db:"id" json:"id"`
+	Name        string     `db:"name" json:"name"`
+	Description string     `db:"description" json:"description"`
+	Permissions []string   `json:"permissions"`
+	CreatedAt   *time.Time `db:"created_at" json:"createdAt"`
+}
+
+type RoleQueries interface {
+	Find() ([]*Role, error)
+	GetUserRoles(userID int64) ([]string, error)
+	GetUserPermissions(userID int64) ([]string, error)
+	SetUserRoles(userID int64, roles []string) error
+}
+
+type RoleRepository struct {
+	DB *sqlx.DB
+}
+
+// all roles with their permissions
+func (m RoleRepository) Find() ([]*Role, error) {
+	statement := `
+		SELECT r.id, r.name, COALESCE(r.description, ''), r.created_at,
+		COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		GROUP BY r.id
+		ORDER BY r.id ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.CreatedAt, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (m RoleRepository) GetUserRoles(userID int64) ([]string, error) {
+	statement := `
+		SELECT r.name
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = $1
+		ORDER BY r.id ASC
+	`
+	return m.selectNames(statement, userID)
+}
+
+// distinct permissions granted by every role of the user
+func (m RoleRepository) GetUserPermissions(userID int64) ([]string, error) {
+	statement := `
+		SELECT DISTINCT p.name
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = $1
+	`
+	return m.selectNames(statement, userID)
+}
+
+// replace every role of the user, unknown role names are ignored
+func (m RoleRepository) SetUserRoles(userID int64, roles []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setUserRoles(ctx, tx, userID, roles); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setUserRoles(ctx context.Context, tx *sqlx.Tx, userID int64, roles []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = ANY($2)
+	`, userID, pq.Array(roles))
+	return err
+}
+
+func (m RoleRepository) selectNames(statement string, args ...interface{}) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
@@ -0,0 +1,266 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// 1 session per sign-in. It lives as long as its refresh token family
+// and is what the access token `jti` claim gets checked against
+type Session struct {
+	ID        int64      `db:"id" json:"id"`
+	UserID    int64      `db:"user_id" json:"-"`
+	JTI       string     `db:"jti" json:"-"`
+	FamilyID  string     `db:"family_id" json:"-"`
+	UserAgent string     `db:"user_agent" json:"userAgent"`
+	IPAddress string     `db:"ip_address" json:"ipAddress"`
+	Current   bool       `json:"current"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expiresAt"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revokedAt"`
+	CreatedAt *time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+func (s Session) Active() bool {
+	return s.RevokedAt == nil && s.ExpiresAt != nil && s.ExpiresAt.After(time.Now())
+}
+
+type SessionQueries interface {
+	Insert(session *Session, token *RefreshToken) error
+	GetByJTI(jti string) (*Session, error)
+	GetByFamily(familyID string) (*Session, error)
+	Find(userID int64) ([]*Session, error)
+	Rotate(session *Session, jti string, current *RefreshToken, next *RefreshToken) error
+	Revoke(userID int64, id int64) (*Session, error)
+	RevokeAll(userID int64) ([]*Session, error)
+}
+
+type SessionRepository struct {
+	DB *sqlx.DB
+}
+
+const sessionColumns = `id, user_id, jti, family_id, COALESCE(user_agent, ''), COALESCE(ip_address, ''), expires_at, revoked_at, created_at, updated_at`
+
+func scanSession(row interface{ Scan(...any) error }) (*Session, error) {
+	session := new(Session)
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.JTI,
+		&session.FamilyID,
+		&session.UserAgent,
+		&session.IPAddress,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// create the session of a sign-in along with the first refresh token of its family,
+// nothing is created when either fails
+func (m SessionRepository) Insert(session *Session, token *RefreshToken) error {
+	if session.ExpiresAt == nil {
+		return utils.ErrorInvalidModel
+	}
+	statement := `
+		INSERT INTO sessions (user_id, jti, family_id, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+	args := []interface{}{session.UserID, session.JTI, session.FamilyID, session.UserAgent, session.IPAddress, pq.FormatTimestamp(session.ExpiresAt.UTC())}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	row := tx.QueryRowContext(ctx, statement, args...)
+	if err := row.Scan(&session.ID, &session.CreatedAt); err != nil {
+		return err
+	}
+	if err := insertRefreshToken(ctx, tx, token); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m SessionRepository) GetByJTI(jti string) (*Session, error) {
+	statement := `SELECT ` + sessionColumns + ` FROM sessions WHERE jti = $1 LIMIT 1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanSession(m.DB.QueryRowContext(ctx, statement, jti))
+}
+
+func (m SessionRepository) GetByFamily(familyID string) (*Session, error) {
+	statement := `SELECT ` + sessionColumns + ` FROM sessions WHERE family_id = $1 LIMIT 1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanSession(m.DB.QueryRowContext(ctx, statement, familyID))
+}
+
+// all active sessions of 1 user, most recently used first
+func (m SessionRepository) Find(userID int64) ([]*Session, error) {
+	statement := `SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY COALESCE(updated_at, created_at) DESC, id DESC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, userID, pq.FormatTimestamp(time.Now().UTC()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []*Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// point the session to a newly issued access token, invalidating the previous one, & replace the current refresh
+// token by the next one at once. The session lives as long as the next token.
+// utils.ErrorTokenReused if the current token was already used, utils.ErrorRecordsNotFound if the session was revoked
+func (m SessionRepository) Rotate(session *Session, jti string, current *RefreshToken, next *RefreshToken) error {
+	if next.ExpiresAt == nil {
+		return utils.ErrorInvalidModel
+	}
+	statement := `
+		UPDATE sessions
+		SET jti = $2, expires_at = $3, updated_at = $4
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING jti, expires_at, updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := rotateRefreshToken(ctx, tx, current, next); err != nil {
+		return err
+	}
+	args := []interface{}{session.ID, jti, pq.FormatTimestamp(next.ExpiresAt.UTC()), pq.FormatTimestamp(time.Now().UTC())}
+	row := tx.QueryRowContext(ctx, statement, args...)
+	err = row.Scan(&session.JTI, &session.ExpiresAt, &session.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordsNotFound
+		default:
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// revoke 1 session of the user along with its refresh tokens
+func (m SessionRepository) Revoke(userID int64, id int64) (*Session, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	sessions, err := m.revoke(`id = $2 AND user_id = $1`, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	return sessions[0], nil
+}
+
+// revoke every active session of the user along with their refresh tokens
+func (m SessionRepository) RevokeAll(userID int64) ([]*Session, error) {
+	return m.revoke(`user_id = $1`, userID)
+}
+
+func (m SessionRepository) revoke(condition string, args ...interface{}) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	sessions, err := revokeSessions(ctx, tx, condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, tx.Commit()
+}
+
+// revoke the active sessions matching the condition along with their refresh token families
+func revokeSessions(ctx context.Context, tx *sqlx.Tx, condition string, args ...interface{}) ([]*Session, error) {
+	now := pq.FormatTimestamp(time.Now().UTC())
+	statement := fmt.Sprintf(`
+		UPDATE sessions
+		SET revoked_at = $%d
+		WHERE %s AND revoked_at IS NULL
+		RETURNING %s
+	`, len(args)+1, condition, sessionColumns)
+	rows, err := tx.QueryContext(ctx, statement, append(args, now)...)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []*Session{}
+	families := []string{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sessions = append(sessions, session)
+		families = append(families, session.FamilyID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(families) > 0 {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE refresh_tokens
+			SET revoked_at = $2
+			WHERE family_id = ANY($1) AND revoked_at IS NULL
+		`, pq.Array(families), now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sessions, nil
+}
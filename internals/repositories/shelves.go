@@ -0,0 +1,401 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// a reading list of a user. Default shelves have a Kind (see ShelfKinds),
+// they can be made public & reordered but not renamed or deleted
+type Shelf struct {
+	ID        int64      `db:"id" json:"id"`
+	UserID    int64      `db:"user_id" json:"userId"`
+	Kind      *string    `db:"kind" json:"kind"`
+	Name      string     `db:"name" json:"name"`
+	Public    bool       `db:"public" json:"public"`
+	Position  int        `db:"position" json:"position"`
+	BookCount int64      `json:"bookCount"`
+	CreatedAt *time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+func (s Shelf) Default() bool {
+	return s.Kind != nil
+}
+
+var defaultShelfNames = map[string]string{
+	ShelfKindReading:    "Reading",
+	ShelfKindWantToRead: "Want to read",
+	ShelfKindFinished:   "Finished",
+	ShelfKindDropped:    "Dropped",
+}
+
+type ShelfQueries interface {
+	EnsureDefaults(userID int64) error
+	Find(userID int64, publicOnly bool) ([]*Shelf, error)
+	Get(id int64) (*Shelf, error)
+	Insert(shelf *Shelf) error
+	Update(shelf *Shelf) error
+	Reorder(shelf *Shelf, position int) error
+	Delete(id int64) error
+	FindBooks(shelfID int64, filter Filter) ([]*Book, Metadata, error)
+	AddBook(shelf *Shelf, bookID int64, position *int) error
+	RemoveBook(shelfID int64, bookID int64) error
+	MoveBook(from *Shelf, to *Shelf, bookID int64, position *int) error
+}
+
+type ShelfRepository struct {
+	DB *sqlx.DB
+}
+
+// only books anyone can open are counted & listed
+const shelfColumns = `
+	s.id, s.user_id, s.kind, s.name, s.public, s.position, s.created_at, s.updated_at,
+	(SELECT count(*) FROM shelf_books sb JOIN books b ON b.id = sb.book_id
+		WHERE sb.shelf_id = s.id AND b.deleted_at IS NULL AND b.status <> 'draft')
+`
+
+func scanShelf(row interface{ Scan(...any) error }) (*Shelf, error) {
+	shelf := new(Shelf)
+	err := row.Scan(
+		&shelf.ID,
+		&shelf.UserID,
+		&shelf.Kind,
+		&shelf.Name,
+		&shelf.Public,
+		&shelf.Position,
+		&shelf.CreatedAt,
+		&shelf.UpdatedAt,
+		&shelf.BookCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	return shelf, nil
+}
+
+// create the default shelves the user doesn't have yet, after their custom shelves
+func (m ShelfRepository) EnsureDefaults(userID int64) error {
+	statement := `
+		INSERT INTO shelves (user_id, kind, name, position)
+		SELECT $1, d.kind, d.name, d.ord - 1 + COALESCE((SELECT max(position) + 1 FROM shelves WHERE user_id = $1), 0)
+		FROM unnest($2::TEXT[], $3::TEXT[]) WITH ORDINALITY AS d (kind, name, ord)
+		WHERE NOT EXISTS (SELECT 1 FROM shelves WHERE user_id = $1 AND kind = d.kind)
+		ON CONFLICT DO NOTHING
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	names := make([]string, 0, len(ShelfKinds))
+	for _, kind := range ShelfKinds {
+		names = append(names, defaultShelfNames[kind])
+	}
+	_, err := m.DB.ExecContext(ctx, statement, userID, pq.Array(ShelfKinds), pq.Array(names))
+	return err
+}
+
+// shelves of a user in their order, publicOnly hides the private ones
+func (m ShelfRepository) Find(userID int64, publicOnly bool) ([]*Shelf, error) {
+	statement := `SELECT ` + shelfColumns + `
+		FROM shelves s
+		WHERE s.user_id = $1 AND (s.public OR NOT $2)
+		ORDER BY s.position ASC, s.id ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, userID, publicOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	shelves := []*Shelf{}
+	for rows.Next() {
+		shelf, err := scanShelf(rows)
+		if err != nil {
+			return nil, err
+		}
+		shelves = append(shelves, shelf)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return shelves, nil
+}
+
+func (m ShelfRepository) Get(id int64) (*Shelf, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `SELECT ` + shelfColumns + ` FROM shelves s WHERE s.id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanShelf(m.DB.QueryRowContext(ctx, statement, id))
+}
+
+// custom shelf, added after the other shelves of the user.
+// utils.ErrorRecordExisted when the user already has a shelf with that name
+func (m ShelfRepository) Insert(shelf *Shelf) error {
+	statement := `
+		INSERT INTO shelves (user_id, name, public, position)
+		VALUES ($1, $2, $3, COALESCE((SELECT max(position) + 1 FROM shelves WHERE user_id = $1), 0))
+		RETURNING id, position, created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, statement, shelf.UserID, shelf.Name, shelf.Public)
+	if err := row.Scan(&shelf.ID, &shelf.Position, &shelf.CreatedAt); err != nil {
+		if isUniqueViolation(err) {
+			return utils.ErrorRecordExisted
+		}
+		return err
+	}
+	return nil
+}
+
+// rename & change the visibility, default shelves keep their name
+func (m ShelfRepository) Update(shelf *Shelf) error {
+	statement := `
+		UPDATE shelves SET name = CASE WHEN kind IS NULL THEN $2 ELSE name END, public = $3, updated_at = $4
+		WHERE id = $1
+		RETURNING name, updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, statement, shelf.ID, shelf.Name, shelf.Public, pq.FormatTimestamp(time.Now().UTC()))
+	if err := row.Scan(&shelf.Name, &shelf.UpdatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordsNotFound
+		case isUniqueViolation(err):
+			return utils.ErrorRecordExisted
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// move the shelf to index position among the shelves of its user
+func (m ShelfRepository) Reorder(shelf *Shelf, position int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids, err := queryIDs(ctx, tx, `SELECT id FROM shelves WHERE user_id = $1 ORDER BY position ASC, id ASC FOR UPDATE`, shelf.UserID)
+	if err != nil {
+		return err
+	}
+	ids = placeID(ids, shelf.ID, &position)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE shelves s SET position = o.ord - 1
+		FROM unnest($2::INT[]) WITH ORDINALITY AS o (id, ord)
+		WHERE s.user_id = $1 AND s.id = o.id
+	`, shelf.UserID, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	shelf.Position = slices.Index(ids, shelf.ID)
+	return tx.Commit()
+}
+
+// only custom shelves can be deleted
+func (m ShelfRepository) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM shelves WHERE id = $1 AND kind IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+// books of a shelf that anyone can open. Sorts on `position` & `added_at` are the ones of the shelf,
+// the other ones are columns of the books
+func (m ShelfRepository) FindBooks(shelfID int64, filter Filter) ([]*Book, Metadata, error) {
+	alias := "b"
+	if utils.IsItemInCollection(filter.SortColumn(), []string{"position", "added_at"}) {
+		alias = "sb"
+	}
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), b.id, b.created_at, b.updated_at, b.deleted_at, b.title, b.description, b.status, b.published_at,
+		b.rating_average, b.rating_count, u.id, u.username,
+		%s
+		FROM shelf_books sb
+		JOIN books b ON b.id = sb.book_id
+		JOIN users u ON b.user_id = u.id
+		WHERE sb.shelf_id = $1 AND b.deleted_at IS NULL AND b.status <> 'draft'
+		ORDER BY %s.%s %s NULLS LAST, sb.added_at ASC, b.id ASC
+		LIMIT $2
+		OFFSET $3
+	`, bookTaxonomyColumns, alias, filter.SortColumn(), filter.SortDirection())
+	return BookRepository{DB: m.DB}.find(statement, filter, shelfID, filter.Limit(), filter.Offset())
+}
+
+// put the book on the shelf at index position, at the end when position is nil.
+// a book already on the shelf is only moved. Putting a book on a default shelf
+// takes it off the other default shelves of the user
+func (m ShelfRepository) AddBook(shelf *Shelf, bookID int64, position *int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := addToShelf(ctx, tx, shelf, bookID, position); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m ShelfRepository) RemoveBook(shelfID int64, bookID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM shelf_books WHERE shelf_id = $1 AND book_id = $2`, shelfID, bookID)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+// take the book off a shelf & put it on another one in the same transaction.
+// utils.ErrorRecordsNotFound when the book isn't on the first shelf
+func (m ShelfRepository) MoveBook(from *Shelf, to *Shelf, bookID int64, position *int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if from.ID != to.ID {
+		result, err := tx.ExecContext(ctx, `DELETE FROM shelf_books WHERE shelf_id = $1 AND book_id = $2`, from.ID, bookID)
+		if err != nil {
+			return err
+		}
+		rowAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowAffected == 0 {
+			return utils.ErrorRecordsNotFound
+		}
+	}
+	if err := addToShelf(ctx, tx, to, bookID, position); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// positions of the shelf are renumbered from 0 every time, shelves are small enough
+func addToShelf(ctx context.Context, tx *sqlx.Tx, shelf *Shelf, bookID int64, position *int) error {
+	// concurrent additions to the shelf wait for each other
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM shelves WHERE id = $1 FOR UPDATE`, shelf.ID); err != nil {
+		return err
+	}
+	ids, err := queryIDs(ctx, tx, `
+		SELECT book_id FROM shelf_books
+		WHERE shelf_id = $1
+		ORDER BY position ASC, added_at ASC
+	`, shelf.ID)
+	if err != nil {
+		return err
+	}
+	if shelf.Default() {
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM shelf_books sb USING shelves s
+			WHERE sb.shelf_id = s.id AND s.user_id = $1 AND s.kind IS NOT NULL AND s.id <> $2 AND sb.book_id = $3
+		`, shelf.UserID, shelf.ID, bookID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO shelf_books (shelf_id, book_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, shelf.ID, bookID)
+	if err != nil {
+		return err
+	}
+	ids = placeID(ids, bookID, position)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE shelf_books sb SET position = o.ord - 1
+		FROM unnest($2::INT[]) WITH ORDINALITY AS o (book_id, ord)
+		WHERE sb.shelf_id = $1 AND sb.book_id = o.book_id
+	`, shelf.ID, pq.Array(ids))
+	return err
+}
+
+func queryIDs(ctx context.Context, tx *sqlx.Tx, statement string, args ...any) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ids with id moved (or added) at index position, at the end when position is nil or past the end
+func placeID(ids []int64, id int64, position *int) []int64 {
+	placed := make([]int64, 0, len(ids)+1)
+	for _, other := range ids {
+		if other != id {
+			placed = append(placed, other)
+		}
+	}
+	at := len(placed)
+	if position != nil && *position < at {
+		at = max(*position, 0)
+	}
+	placed = append(placed[:at], append([]int64{id}, placed[at:]...)...)
+	return placed
+}
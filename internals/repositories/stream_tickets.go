@@ -0,0 +1,91 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// single use pass to the live stream, issued to a session. Only the hash of the ticket is stored
+type StreamTicket struct {
+	ID        int64      `db:"id" json:"id"`
+	UserID    int64      `db:"user_id" json:"userId"`
+	FamilyID  string     `db:"family_id" json:"-"`
+	TokenHash string     `db:"token_hash" json:"-"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expiresAt"`
+	CreatedAt *time.Time `db:"created_at" json:"createdAt"`
+}
+
+type StreamTicketQueries interface {
+	Insert(ticket *StreamTicket) error
+	Consume(tokenHash string) (*StreamTicket, error)
+	DeleteExpired() (int64, error)
+}
+
+type StreamTicketRepository struct {
+	DB *sqlx.DB
+}
+
+func (m StreamTicketRepository) Insert(ticket *StreamTicket) error {
+	if ticket.ExpiresAt == nil {
+		return utils.ErrorInvalidModel
+	}
+	statement := `
+		INSERT INTO stream_tickets (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, statement, ticket.UserID, ticket.FamilyID, ticket.TokenHash, pq.FormatTimestamp(ticket.ExpiresAt.UTC()))
+	return row.Scan(&ticket.ID, &ticket.CreatedAt)
+}
+
+// remove the ticket & return it. Unknown, expired & already used tickets give utils.ErrorInvalidToken
+func (m StreamTicketRepository) Consume(tokenHash string) (*StreamTicket, error) {
+	statement := `
+		DELETE FROM stream_tickets
+		WHERE token_hash = $1 AND expires_at > $2
+		RETURNING id, user_id, family_id, token_hash, expires_at, created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ticket := new(StreamTicket)
+	row := m.DB.QueryRowContext(ctx, statement, tokenHash, pq.FormatTimestamp(time.Now().UTC()))
+	err := row.Scan(
+		&ticket.ID,
+		&ticket.UserID,
+		&ticket.FamilyID,
+		&ticket.TokenHash,
+		&ticket.ExpiresAt,
+		&ticket.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorInvalidToken
+		default:
+			return nil, err
+		}
+	}
+	return ticket, nil
+}
+
+// remove the tickets that expired before being used
+func (m StreamTicketRepository) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM stream_tickets WHERE expires_at <= $1`, pq.FormatTimestamp(time.Now().UTC()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,115 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type SubscriptionQueries interface {
+	Subscribe(userID int64, bookID int64) error
+	Unsubscribe(userID int64, bookID int64) error
+	IsSubscribed(userID int64, bookID int64) (bool, error)
+	CountSubscribers(bookID int64) (int64, error)
+	FindSubscriberIDs(bookID int64) ([]int64, error)
+	FindBooks(userID int64, filter Filter) ([]*Book, Metadata, error)
+}
+
+type SubscriptionRepository struct {
+	DB *sqlx.DB
+}
+
+// subscribing twice is a no-op
+func (m SubscriptionRepository) Subscribe(userID int64, bookID int64) error {
+	statement := `
+		INSERT INTO book_subscriptions (user_id, book_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, statement, userID, bookID)
+	return err
+}
+
+func (m SubscriptionRepository) Unsubscribe(userID int64, bookID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM book_subscriptions WHERE user_id = $1 AND book_id = $2`, userID, bookID)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+func (m SubscriptionRepository) IsSubscribed(userID int64, bookID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var subscribed bool
+	statement := `SELECT EXISTS (SELECT 1 FROM book_subscriptions WHERE user_id = $1 AND book_id = $2)`
+	err := m.DB.QueryRowContext(ctx, statement, userID, bookID).Scan(&subscribed)
+	return subscribed, err
+}
+
+func (m SubscriptionRepository) CountSubscribers(bookID int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int64
+	err := m.DB.QueryRowContext(ctx, `SELECT count(*) FROM book_subscriptions WHERE book_id = $1`, bookID).Scan(&count)
+	return count, err
+}
+
+func (m SubscriptionRepository) FindSubscriberIDs(bookID int64) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT user_id FROM book_subscriptions WHERE book_id = $1`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// books the user is subscribed to & can still open. The `subscribed_at` sort is the subscription date,
+// the other ones are columns of the books
+func (m SubscriptionRepository) FindBooks(userID int64, filter Filter) ([]*Book, Metadata, error) {
+	column := "b." + filter.SortColumn()
+	if filter.SortColumn() == "subscribed_at" {
+		column = "bs.created_at"
+	}
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), b.id, b.created_at, b.updated_at, b.deleted_at, b.title, b.description, b.status, b.published_at,
+		b.rating_average, b.rating_count, u.id, u.username,
+		%s
+		FROM book_subscriptions bs
+		JOIN books b ON b.id = bs.book_id
+		JOIN users u ON b.user_id = u.id
+		WHERE bs.user_id = $1 AND b.deleted_at IS NULL AND b.status <> 'draft'
+		ORDER BY %s %s NULLS LAST, b.id ASC
+		LIMIT $2
+		OFFSET $3
+	`, bookTaxonomyColumns, column, filter.SortDirection())
+	return BookRepository{DB: m.DB}.find(statement, filter, userID, filter.Limit(), filter.Offset())
+}
@@ -0,0 +1,349 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// free-form label given to books by their authors.
+// names are normalized & synonyms resolve to their canonical tag
+type Tag struct {
+	ID        int64      `db:"id" json:"id"`
+	Name      string     `db:"name" json:"name"`
+	Synonyms  []string   `json:"synonyms"`
+	BookCount int64      `db:"book_count" json:"bookCount"`
+	CreatedAt *time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// "  Slow Burn!" & "slow_burn" are both stored as "slow-burn"
+func NormalizeTagName(name string) string {
+	return utils.Slugify(name)
+}
+
+type TagQueries interface {
+	Find(name string, filter Filter) ([]*Tag, Metadata, error)
+	Get(id int64) (*Tag, error)
+	Insert(tag *Tag) error
+	Update(tag *Tag) error
+	Delete(id int64) error
+	AddSynonym(tagID int64, name string) error
+	RemoveSynonym(tagID int64, name string) error
+	Resolve(names []string) ([]string, error)
+}
+
+type TagRepository struct {
+	DB *sqlx.DB
+}
+
+const tagColumns = `
+	t.id, t.name, t.created_at, t.updated_at,
+	(SELECT count(*) FROM book_tags bt WHERE bt.tag_id = t.id) AS book_count,
+	ARRAY(SELECT ts.name FROM tag_synonyms ts WHERE ts.tag_id = t.id ORDER BY ts.name)
+`
+
+func scanTag(row interface{ Scan(...any) error }, dest ...any) (*Tag, error) {
+	tag := new(Tag)
+	dest = append(dest, &tag.ID, &tag.Name, &tag.CreatedAt, &tag.UpdatedAt, &tag.BookCount, pq.Array(&tag.Synonyms))
+	if err := row.Scan(dest...); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	return tag, nil
+}
+
+// tags whose name or one of its synonyms starts with name
+func (m TagRepository) Find(name string, filter Filter) ([]*Tag, Metadata, error) {
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM tags t
+		WHERE $1 = ''
+		OR t.name LIKE $1 || '%%'
+		OR EXISTS (SELECT 1 FROM tag_synonyms ts WHERE ts.tag_id = t.id AND ts.name LIKE $1 || '%%')
+		ORDER BY %s %s, t.id ASC
+		LIMIT $2
+		OFFSET $3
+	`, tagColumns, filter.SortColumn(), filter.SortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// normalizing also gets rid of the LIKE wildcards
+	rows, err := m.DB.QueryContext(ctx, statement, NormalizeTagName(name), filter.Limit(), filter.Offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	tags := []*Tag{}
+	totalRecords := 0
+	for rows.Next() {
+		tag, err := scanTag(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		tags = append(tags, tag)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return tags, CalculateMetadata(totalRecords, filter.PageSize, filter.Page), nil
+}
+
+func (m TagRepository) Get(id int64) (*Tag, error) {
+	if id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `SELECT ` + tagColumns + ` FROM tags t WHERE t.id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanTag(m.DB.QueryRowContext(ctx, statement, id))
+}
+
+func (m TagRepository) Insert(tag *Tag) error {
+	tag.Name = NormalizeTagName(tag.Name)
+	if tag.Name == "" {
+		return utils.ErrorInvalidModel
+	}
+	statement := `
+		INSERT INTO tags (name)
+		SELECT $1
+		WHERE NOT EXISTS (SELECT 1 FROM tag_synonyms WHERE name = $1)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, statement, tag.Name).Scan(&tag.ID, &tag.CreatedAt)
+	if err != nil {
+		switch {
+		// the name is already a synonym of another tag
+		case errors.Is(err, sql.ErrNoRows), isUniqueViolation(err):
+			return utils.ErrorRecordExisted
+		default:
+			return err
+		}
+	}
+	tag.Synonyms = []string{}
+	return nil
+}
+
+// rename the tag, books keep it
+func (m TagRepository) Update(tag *Tag) error {
+	tag.Name = NormalizeTagName(tag.Name)
+	if tag.Name == "" {
+		return utils.ErrorInvalidModel
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// renaming a tag to one of its own synonyms turns the synonym into the canonical name
+	var synonymOf int64
+	err = tx.QueryRowContext(ctx, `SELECT tag_id FROM tag_synonyms WHERE name = $1`, tag.Name).Scan(&synonymOf)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case synonymOf != tag.ID:
+		return utils.ErrorRecordExisted
+	default:
+		if _, err := tx.ExecContext(ctx, `DELETE FROM tag_synonyms WHERE name = $1`, tag.Name); err != nil {
+			return err
+		}
+	}
+	row := tx.QueryRowContext(ctx, `
+		UPDATE tags SET name = $2, updated_at = $3
+		WHERE id = $1
+		RETURNING updated_at
+	`, tag.ID, tag.Name, pq.FormatTimestamp(time.Now().UTC()))
+	if err := row.Scan(&tag.UpdatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordsNotFound
+		case isUniqueViolation(err):
+			return utils.ErrorRecordExisted
+		default:
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// books lose the tag as well
+func (m TagRepository) Delete(id int64) error {
+	if id < 1 {
+		return utils.ErrorRecordsNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM tags WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+// make name resolve to the tag. When a tag with that name already exists it is merged into this one:
+// its books & synonyms are moved over before it gets deleted
+func (m TagRepository) AddSynonym(tagID int64, name string) error {
+	name = NormalizeTagName(name)
+	if name == "" {
+		return utils.ErrorInvalidModel
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var canonical string
+	err = tx.QueryRowContext(ctx, `SELECT name FROM tags WHERE id = $1 FOR UPDATE`, tagID).Scan(&canonical)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return utils.ErrorRecordsNotFound
+		default:
+			return err
+		}
+	}
+	if canonical == name {
+		return utils.ErrorInvalidModel
+	}
+	var mergedID int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM tags WHERE name = $1 FOR UPDATE`, name).Scan(&mergedID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	default:
+		statements := []string{
+			`INSERT INTO book_tags (book_id, tag_id) SELECT book_id, $1 FROM book_tags WHERE tag_id = $2 ON CONFLICT DO NOTHING`,
+			`UPDATE tag_synonyms SET tag_id = $1 WHERE tag_id = $2`,
+			`DELETE FROM tags WHERE id = $2`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement, tagID, mergedID); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO tag_synonyms (name, tag_id) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET tag_id = EXCLUDED.tag_id
+	`, name, tagID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tags SET updated_at = $2 WHERE id = $1`, tagID, pq.FormatTimestamp(time.Now().UTC())); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m TagRepository) RemoveSynonym(tagID int64, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM tag_synonyms WHERE tag_id = $1 AND name = $2`, tagID, NormalizeTagName(name))
+	if err != nil {
+		return err
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return utils.ErrorRecordsNotFound
+	}
+	return nil
+}
+
+// normalize names & replace synonyms by their canonical tag, without creating anything
+func (m TagRepository) Resolve(names []string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return resolveTags(ctx, m.DB, names)
+}
+
+// replace every tag of the book, tags that don't exist yet are created.
+// tags are only ever set along with the book they belong to, see BookRepository.Insert
+func setBookTags(ctx context.Context, tx *sqlx.Tx, bookID int64, names []string) error {
+	names, err := resolveTags(ctx, tx, names)
+	if err != nil {
+		return err
+	}
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO tags (name) SELECT unnest($1::TEXT[]) ON CONFLICT (name) DO NOTHING`, []interface{}{pq.Array(names)}},
+		{`DELETE FROM book_tags WHERE book_id = $1`, []interface{}{bookID}},
+		{`INSERT INTO book_tags (book_id, tag_id) SELECT $1, id FROM tags WHERE name = ANY($2)`, []interface{}{bookID, pq.Array(names)}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resolveTags(ctx context.Context, q sqlx.QueryerContext, names []string) ([]string, error) {
+	normalized := []string{}
+	for _, name := range names {
+		if name = NormalizeTagName(name); name != "" {
+			normalized = append(normalized, name)
+		}
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT COALESCE(t.name, n.name)
+		FROM unnest($1::TEXT[]) WITH ORDINALITY AS n(name, position)
+		LEFT JOIN tag_synonyms ts ON ts.name = n.name
+		LEFT JOIN tags t ON t.id = ts.tag_id
+		ORDER BY n.position
+	`, pq.Array(utils.Unique(normalized)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	resolved := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return utils.Unique(resolved), nil
+}
@@ -0,0 +1,122 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// single use token sent to the user by email (verification, password reset).
+// only the hash of the token is stored
+type UserToken struct {
+	ID         int64      `db:"id" json:"id"`
+	UserID     int64      `db:"user_id" json:"userId"`
+	Purpose    string     `db:"purpose" json:"purpose"`
+	TokenHash  string     `db:"token_hash" json:"-"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expiresAt"`
+	ConsumedAt *time.Time `db:"consumed_at" json:"consumedAt"`
+	CreatedAt  *time.Time `db:"created_at" json:"createdAt"`
+}
+
+type UserTokenQueries interface {
+	Insert(token *UserToken, mail *services.Mail) error
+	DeleteExpired() (int64, error)
+}
+
+type UserTokenRepository struct {
+	DB *sqlx.DB
+}
+
+// insert a new token, any unconsumed token of the same purpose is invalidated.
+// the mail carrying the token (if any) is queued in the same transaction
+func (m UserTokenRepository) Insert(token *UserToken, mail *services.Mail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertUserToken(ctx, tx, token); err != nil {
+		return err
+	}
+	if err := enqueueMails(ctx, tx, mail); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertUserToken(ctx context.Context, tx *sqlx.Tx, token *UserToken) error {
+	if !utils.IsItemInCollection(token.Purpose, TokenPurposes) || token.ExpiresAt == nil {
+		return utils.ErrorInvalidModel
+	}
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM user_tokens
+		WHERE user_id = $1 AND purpose = $2 AND consumed_at IS NULL
+	`, token.UserID, token.Purpose)
+	if err != nil {
+		return err
+	}
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, token.UserID, token.Purpose, token.TokenHash, pq.FormatTimestamp(token.ExpiresAt.UTC()))
+	return row.Scan(&token.ID, &token.CreatedAt)
+}
+
+// mark the token as consumed. Unknown, expired & already consumed tokens give utils.ErrorInvalidToken.
+// tokens are only ever consumed along with what they are for, see UserRepository.Verify
+func consumeUserToken(ctx context.Context, tx *sqlx.Tx, userID int64, purpose string, tokenHash string) (*UserToken, error) {
+	statement := `
+		UPDATE user_tokens
+		SET consumed_at = $4
+		WHERE user_id = $1 AND purpose = $2 AND token_hash = $3
+		AND consumed_at IS NULL AND expires_at > $4
+		RETURNING id, user_id, purpose, token_hash, expires_at, consumed_at, created_at
+	`
+	token := new(UserToken)
+	row := tx.QueryRowContext(ctx, statement, userID, purpose, tokenHash, pq.FormatTimestamp(time.Now().UTC()))
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.ConsumedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorInvalidToken
+		default:
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// remove tokens that can never be used again
+func (m UserTokenRepository) DeleteExpired() (int64, error) {
+	statement := `
+		DELETE FROM user_tokens
+		WHERE expires_at <= $1 OR consumed_at IS NOT NULL
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, statement, pq.FormatTimestamp(time.Now().UTC()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -16,21 +16,34 @@ import (
 // USER models
 // should be in a different model package but im too lazy sorry :(
 type User struct {
-	ID                 int64      `db:"id" json:"id"`
-	Username           string     `db:"username" json:"username"`
-	PasswordHash       string     `db:"password_hash" json:"-"`
-	Email              string     `db:"email" json:"email"`
-	FirstName          *string    `db:"first_name" json:"firstName"`
-	LastName           *string    `db:"last_name" json:"lastName"`
-	DateOfBirth        *time.Time `dh:"date_of_birth" json:"dateOfBirth"`
-	Gender             *string    `db:"gender" json:"gender"`
-	ProfilePicture     *string    `db:"profile_picture" json:"profilePicture"`
-	Status             string     `db:"status" json:"status"`
-	Verified           bool       `db:"verified" json:"verified"`
-	VerificationToken  string     `db:"verification_token" json:"-"`
-	PasswordResetToken string     `db:"password_reset_token" json:"-"`
-	CreatedAt          *time.Time `db:"created_at" json:"createdAt"`
-	UpdatedAt          *time.Time `db:"updated_at" json:"updatedAt"`
+	ID             int64       `db:"id" json:"id"`
+	Username       string      `db:"username" json:"username"`
+	PasswordHash   string      `db:"password_hash" json:"-"`
+	Email          string      `db:"email" json:"email"`
+	FirstName      *string     `db:"first_name" json:"firstName"`
+	LastName       *string     `db:"last_name" json:"lastName"`
+	DateOfBirth    *time.Time  `db:"date_of_birth" json:"dateOfBirth"`
+	Gender         *string     `db:"gender" json:"gender"`
+	ProfilePicture *string     `db:"profile_picture" json:"profilePicture"`
+	Status         string      `db:"status" json:"status"`
+	Verified       bool        `db:"verified" json:"verified"`
+	Locale         string      `db:"locale" json:"locale"`
+	Roles          []string    `json:"roles,omitempty"`
+	Counts         *UserCounts `json:"counts,omitempty"`
+	CreatedAt      *time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt      *time.Time  `db:"updated_at" json:"updatedAt"`
+}
+
+// public information about a user, safe to show to anyone (book authors, reviewers...)
+type PublicUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type UserCounts struct {
+	Followers     int64 `json:"followers"`
+	Following     int64 `json:"following"`
+	Subscriptions int64 `json:"subscriptions"`
 }
 
 func (u *User) SetPassword(plaintextPassword string) error {
@@ -60,11 +73,15 @@ func (u *User) MatchPassword(plaintextPassword string) (bool, error) {
 // USER repository
 type UserQueries interface {
 	Insert(user *User) error
+	Register(user *User, roles []string, token *UserToken, mail func(user *User) (*services.Mail, error)) error
 	Get(id int64) (*User, error)
 	Update(user *User) error
+	Verify(user *User, tokenHash string) error
+	ResetPassword(user *User, tokenHash string) ([]*Session, error)
 	Delete(id int64) error
 	Login(username string, plaintextPassword string) (*User, error)
 	GetByEmail(string, string) (*User, error)
+	Counts(id int64) (*UserCounts, error)
 }
 
 type UserRepository struct {
@@ -76,36 +93,78 @@ func (m UserRepository) Insert(user *User) error {
 		INSERT INTO users (
             username,
             password_hash,
-            email, verified,
-            verification_token,
+            email,
+            verified,
             status,
             first_name,
             last_name,
             date_of_birth,
             gender,
-            profile_picture
+            profile_picture,
+            locale
         )
-		VALUES ($1, $2, $3, $4, $5, $6, $7, &8, $9, $10, $11)
-		RETURNING id, created_at;
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, COALESCE(NULLIF($11, ''), 'en'))
+		RETURNING id, locale, created_at;
 	`
 	args := []interface{}{
 		user.Username,
 		user.PasswordHash,
 		user.Email,
 		user.Verified,
-		user.VerificationToken,
 		user.Status,
 		user.FirstName,
 		user.LastName,
 		user.DateOfBirth,
 		user.Gender,
 		user.ProfilePicture,
+		user.Locale,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	row := m.DB.QueryRowContext(ctx, statement, args...)
 
-	return row.Scan(&user.ID, &user.CreatedAt)
+	return row.Scan(&user.ID, &user.Locale, &user.CreatedAt)
+}
+
+// create the user along with their roles & their verification token, the mail carrying the token
+// is queued in the same transaction so that a new account never misses it.
+// mail is given the inserted user, their id is only known at this point
+func (m UserRepository) Register(user *User, roles []string, token *UserToken, mail func(user *User) (*services.Mail, error)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO users (
+			username, password_hash, email, verified, status, first_name, last_name, date_of_birth, gender, profile_picture, locale
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, COALESCE(NULLIF($11, ''), 'en'))
+		RETURNING id, locale, created_at
+	`, user.Username, user.PasswordHash, user.Email, user.Verified, user.Status, user.FirstName, user.LastName,
+		user.DateOfBirth, user.Gender, user.ProfilePicture, user.Locale)
+	if err := row.Scan(&user.ID, &user.Locale, &user.CreatedAt); err != nil {
+		return err
+	}
+	if err := setUserRoles(ctx, tx, user.ID, roles); err != nil {
+		return err
+	}
+	token.UserID = user.ID
+	if err := insertUserToken(ctx, tx, token); err != nil {
+		return err
+	}
+	verificationMail, err := mail(user)
+	if err != nil {
+		return err
+	}
+	if err := enqueueMails(ctx, tx, verificationMail); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (m UserRepository) Get(id int64) (*User, error) {
@@ -120,13 +179,13 @@ func (m UserRepository) Get(id int64) (*User, error) {
             password_hash,
 			email,
             verified,
-            COALESCE(verification_token, ''),
             status,
             first_name,
             last_name,
             date_of_birth,
             gender,
             profile_picture,
+            locale,
             created_at, updated_at
 		FROM users
 		WHERE id=$1
@@ -142,13 +201,13 @@ func (m UserRepository) Get(id int64) (*User, error) {
 		&user.PasswordHash,
 		&user.Email,
 		&user.Verified,
-		&user.VerificationToken,
 		&user.Status,
 		&user.FirstName,
 		&user.LastName,
 		&user.DateOfBirth,
 		&user.Gender,
 		&user.ProfilePicture,
+		&user.Locale,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -190,30 +249,83 @@ func (m UserRepository) Login(username string, plaintextPassword string) (*User,
 }
 
 func (m UserRepository) Update(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return updateUser(ctx, m.DB, user)
+}
+
+// consume the email verification token of the user & mark them as verified at once,
+// the token is only used up if the user gets verified. utils.ErrorInvalidToken if the token can't be used
+func (m UserRepository) Verify(user *User, tokenHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := consumeUserToken(ctx, tx, user.ID, TokenPurposeEmailVerification, tokenHash); err != nil {
+		return err
+	}
+	user.Verified = true
+	if err := updateUser(ctx, tx, user); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// consume the password reset token of the user & save the user, whose password was set beforehand, at once.
+// every session of the user is revoked along with its refresh tokens, whoever signed in with the old password
+// is signed out. The token is only used up if the password changes. utils.ErrorInvalidToken if the token can't be used
+func (m UserRepository) ResetPassword(user *User, tokenHash string) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := consumeUserToken(ctx, tx, user.ID, TokenPurposePasswordReset, tokenHash); err != nil {
+		return nil, err
+	}
+	if err := updateUser(ctx, tx, user); err != nil {
+		return nil, err
+	}
+	sessions, err := revokeSessions(ctx, tx, `user_id = $1`, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, tx.Commit()
+}
+
+func updateUser(ctx context.Context, q sqlx.QueryerContext, user *User) error {
 	statement := `
 		UPDATE users SET
 			username=$1,
             password_hash=$2,
 			email=$3,
             verified=$4,
-			verification_token=$5,
-			status=$6,
-            first_name=$7,
-            last_name=$8,
-            date_of_birth=9$
-            gender=$10,
-            profile_picture=$11,
-            updated_at=12$
-		WHERE id=$13
-		RETURNING username, password_hash, email, verified, verification_token, status, updated_at
+			status=$5,
+            first_name=$6,
+            last_name=$7,
+            date_of_birth=$8,
+            gender=$9,
+            profile_picture=$10,
+            locale=COALESCE(NULLIF($13, ''), locale),
+            updated_at=$11
+		WHERE id=$12
+		RETURNING username, password_hash, email, verified, status, locale, updated_at
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	args := []interface{}{
 		user.Username,
 		user.PasswordHash,
 		user.Email,
 		user.Verified,
-		user.VerificationToken,
 		user.Status,
 		user.FirstName,
 		user.LastName,
@@ -222,10 +334,10 @@ func (m UserRepository) Update(user *User) error {
 		user.ProfilePicture,
 		pq.FormatTimestamp(time.Now().UTC()),
 		user.ID,
+		user.Locale,
 	}
-	defer cancel()
-	row := m.DB.QueryRowContext(ctx, statement, args...)
-	return row.Scan(&user.Username, &user.PasswordHash, &user.Email, &user.Verified, &user.VerificationToken, &user.Status, &user.UpdatedAt)
+	row := q.QueryRowxContext(ctx, statement, args...)
+	return row.Scan(&user.Username, &user.PasswordHash, &user.Email, &user.Verified, &user.Status, &user.Locale, &user.UpdatedAt)
 }
 
 func (m UserRepository) Delete(id int64) error {
@@ -260,13 +372,13 @@ func (m UserRepository) GetByEmail(email string, status string) (*User, error) {
         password_hash,
         email,
         verified,
-        COALESCE(verification_token, ''),
         status,
         first_name,
         last_name,
         date_of_birth,
         gender,
         profile_picture,
+        locale,
         created_at,
         updated_at
 	FROM users
@@ -281,13 +393,13 @@ func (m UserRepository) GetByEmail(email string, status string) (*User, error) {
 		&user.PasswordHash,
 		&user.Email,
 		&user.Verified,
-		&user.VerificationToken,
 		&user.Status,
 		&user.FirstName,
 		&user.LastName,
 		&user.DateOfBirth,
 		&user.Gender,
 		&user.ProfilePicture,
+		&user.Locale,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -301,3 +413,22 @@ func (m UserRepository) GetByEmail(email string, status string) (*User, error) {
 	}
 	return user, nil
 }
+
+// followers, followed users & book subscriptions of a user
+func (m UserRepository) Counts(id int64) (*UserCounts, error) {
+	statement := `
+		SELECT
+			(SELECT count(*) FROM follows WHERE followee_id = $1),
+			(SELECT count(*) FROM follows WHERE follower_id = $1),
+			(SELECT count(*) FROM book_subscriptions WHERE user_id = $1)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	counts := new(UserCounts)
+	err := m.DB.QueryRowContext(ctx, statement, id).Scan(&counts.Followers, &counts.Following, &counts.Subscriptions)
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
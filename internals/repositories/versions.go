@@ -0,0 +1,178 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// a snapshot of a chapter content, taken right before the content is overwritten
+type ChapterVersion struct {
+	ID          int64      `db:"id" json:"id"`
+	ContentID   int64      `db:"content_id" json:"contentId"`
+	TextContent string     `db:"text_content" json:"textContent,omitempty"`
+	Format      string     `db:"format" json:"format"`
+	Length      int        `json:"length"`
+	UserID      int64      `db:"user_id" json:"userId"` // the user whose save created the snapshot
+	CreatedAt   *time.Time `db:"created_at" json:"createdAt"`
+}
+
+type ChapterVersionQueries interface {
+	Get(contentID int64, id int64) (*ChapterVersion, error)
+	Find(contentID int64, filter Filter) ([]*ChapterVersion, Metadata, error)
+	History(contentID int64) ([]*ChapterVersion, error)
+}
+
+type ChapterVersionRepository struct {
+	DB *sqlx.DB
+}
+
+// list versions of 1 content, the text itself is left out to keep the response small
+func (m ChapterVersionRepository) Find(contentID int64, filter Filter) ([]*ChapterVersion, Metadata, error) {
+	if contentID < 1 {
+		return nil, Metadata{}, utils.ErrorRecordsNotFound
+	}
+	statement := fmt.Sprintf(`
+		SELECT count(*) OVER(), v.id, v.content_id, v.format, char_length(v.text_content), v.user_id, v.created_at
+		FROM chapter_versions v
+		WHERE v.content_id = $1
+		ORDER BY %s %s, v.id DESC
+		LIMIT $2
+		OFFSET $3
+	`, filter.SortColumn(), filter.SortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, contentID, filter.Limit(), filter.Offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	versions := []*ChapterVersion{}
+	totalRecords := 0
+	for rows.Next() {
+		var version ChapterVersion
+		err := rows.Scan(
+			&totalRecords,
+			&version.ID,
+			&version.ContentID,
+			&version.Format,
+			&version.Length,
+			&version.UserID,
+			&version.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		versions = append(versions, &version)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return versions, CalculateMetadata(totalRecords, filter.PageSize, filter.Page), nil
+}
+
+func (m ChapterVersionRepository) Get(contentID int64, id int64) (*ChapterVersion, error) {
+	if contentID < 1 || id < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `
+		SELECT id, content_id, text_content, format, char_length(text_content), user_id, created_at
+		FROM chapter_versions
+		WHERE id = $1 AND content_id = $2
+		LIMIT 1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	version := new(ChapterVersion)
+	row := m.DB.QueryRowContext(ctx, statement, id, contentID)
+	err := row.Scan(
+		&version.ID,
+		&version.ContentID,
+		&version.TextContent,
+		&version.Format,
+		&version.Length,
+		&version.UserID,
+		&version.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, utils.ErrorRecordsNotFound
+		default:
+			return nil, err
+		}
+	}
+	return version, nil
+}
+
+// every version of 1 content with its text, oldest first
+func (m ChapterVersionRepository) History(contentID int64) ([]*ChapterVersion, error) {
+	if contentID < 1 {
+		return nil, utils.ErrorRecordsNotFound
+	}
+	statement := `
+		SELECT id, content_id, text_content, format, char_length(text_content), user_id, created_at
+		FROM chapter_versions
+		WHERE content_id = $1
+		ORDER BY created_at ASC, id ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, statement, contentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := []*ChapterVersion{}
+	for rows.Next() {
+		var version ChapterVersion
+		err := rows.Scan(
+			&version.ID,
+			&version.ContentID,
+			&version.TextContent,
+			&version.Format,
+			&version.Length,
+			&version.UserID,
+			&version.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, &version)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// copy the current text & format of a content into chapter_versions, returns the id of the new version.
+// must be called inside the transaction that is about to overwrite the content
+func snapshotContent(ctx context.Context, tx *sqlx.Tx, contentID int64, userID int64) (int64, error) {
+	statement := `
+		INSERT INTO chapter_versions (content_id, text_content, format, user_id)
+		SELECT id, COALESCE(text_content, ''), format, $2
+		FROM contents
+		WHERE id = $1
+		FOR UPDATE
+		RETURNING id
+	`
+	var versionID int64
+	if err := tx.QueryRowContext(ctx, statement, contentID, userID).Scan(&versionID); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, utils.ErrorRecordsNotFound
+		default:
+			return 0, err
+		}
+	}
+	return versionID, nil
+}
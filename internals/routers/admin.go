@@ -0,0 +1,160 @@
+package router
+
+import (
+	"errors"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type SetUserRolesPayload struct {
+	Roles []string `json:"roles" validate:"required,min=1,dive,oneof=reader author moderator admin"`
+}
+
+// all roles along with their permissions
+func (r Router) FindRoles(c echo.Context) error {
+	roles, err := r.Repository.Role.Find()
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Role]{
+		OK:   true,
+		Data: roles,
+	})
+}
+
+func (r Router) GetUserRoles(c echo.Context) error {
+	userId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	user, err := r.Repository.User.Get(int64(userId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	roles, err := r.Repository.Role.GetUserRoles(user.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]string]{
+		OK:   true,
+		Data: roles,
+	})
+}
+
+// replace every role of a user
+func (r Router) SetUserRoles(c echo.Context) error {
+	userId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	validate := utils.NewValidator()
+	payload := new(SetUserRolesPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	user, err := r.Repository.User.Get(int64(userId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if err := r.Repository.Role.SetUserRoles(user.ID, payload.Roles); err != nil {
+		return r.serverError(err)
+	}
+	roles, err := r.Repository.Role.GetUserRoles(user.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]string]{
+		OK:   true,
+		Data: roles,
+	})
+}
+
+// emails that ran out of attempts, most recently failed first
+func (r Router) FindDeadEmails(c echo.Context) error {
+	filter := repositories.Filter{
+		Page:     1,
+		PageSize: 20,
+		SortSafeList: []string{
+			"updated_at",
+			"-updated_at",
+			"created_at",
+			"-created_at",
+		},
+		Sort: "-updated_at",
+	}
+	queryParams := c.QueryParams()
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	mails, metadata, err := r.Repository.Outbox.FindDead(filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.OutboxMail]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     mails,
+	})
+}
+
+// queue a dead email again with a fresh set of attempts
+func (r Router) RetryEmail(c echo.Context) error {
+	emailId, err := strconv.Atoi(c.Param("emailId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	if err := r.Repository.Outbox.Retry(int64(emailId)); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	mail, err := r.Repository.Outbox.Get(int64(emailId))
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.OutboxMail]{
+		OK:   true,
+		Data: *mail,
+	})
+}
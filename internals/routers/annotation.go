@@ -0,0 +1,266 @@
+package router
+
+import (
+	"errors"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// anchor the annotation to the characters [start, end) of the paragraph at index paragraph
+type CreateAnnotationPayload struct {
+	Paragraph *int   `json:"paragraph" validate:"required,gte=0"`
+	Start     *int   `json:"start" validate:"required,gte=0"`
+	End       int    `json:"end" validate:"required,gte=1"`
+	Body      string `json:"body" validate:"required,max=5000"`
+}
+
+type UpdateAnnotationPayload struct {
+	Body     *string `json:"body" validate:"omitempty,min=1,max=5000"`
+	Resolved *bool   `json:"resolved"`
+}
+
+type AnnotatedContent struct {
+	Content     repositories.Content       `json:"content"`
+	Paragraphs  []services.Paragraph       `json:"paragraphs"`
+	Annotations []*repositories.Annotation `json:"annotations"`
+}
+
+// the content with its paragraphs & annotations, resolved annotations are included with ?resolved=true
+func (r Router) GetAnnotatedContent(c echo.Context) error {
+	chapter, err := r.getAnnotatableChapter(c)
+	if err != nil {
+		return err
+	}
+	includeResolved := false
+	queryParams := c.QueryParams()
+	if queryParams.Has("resolved") {
+		includeResolved, err = strconv.ParseBool(queryParams.Get("resolved"))
+		if err != nil {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	content, err := r.Repository.Content.Get(chapter.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	annotations, err := r.Repository.Annotation.FindByContent(content.ID, includeResolved)
+	if err != nil {
+		return r.serverError(err)
+	}
+	anchorService := services.NewAnchorService()
+	return c.JSON(http.StatusOK, Response[AnnotatedContent]{
+		OK: true,
+		Data: AnnotatedContent{
+			Content:     *content,
+			Paragraphs:  anchorService.Paragraphs(content.TextContent),
+			Annotations: annotations,
+		},
+	})
+}
+
+func (r Router) CreateAnnotation(c echo.Context) error {
+	chapter, err := r.getAnnotatableChapter(c)
+	if err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	validate := utils.NewValidator()
+	payload := new(CreateAnnotationPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	content, err := r.Repository.Content.Get(chapter.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	anchorService := services.NewAnchorService()
+	anchor, err := anchorService.Locate(content.TextContent, *payload.Paragraph, *payload.Start, payload.End)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrorInvalidAnchor):
+			return r.badRequestError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	annotation := repositories.Annotation{
+		ContentID: content.ID,
+		UserID:    int64(userId),
+		Anchor:    anchor,
+		Body:      payload.Body,
+	}
+	if err := r.Repository.Annotation.Insert(&annotation); err != nil {
+		return r.serverError(err)
+	}
+	return r.respondWithAnnotation(c, http.StatusCreated, annotation.ID)
+}
+
+// only the annotator can edit the body, anyone able to write the chapter can resolve it
+func (r Router) UpdateAnnotation(c echo.Context) error {
+	chapter, annotation, err := r.getAnnotationFromParams(c)
+	if err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	validate := utils.NewValidator()
+	payload := new(UpdateAnnotationPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	if payload.Body != nil {
+		if annotation.UserID != int64(userId) {
+			return r.forbiddenError(utils.ErrorForbiddenResource)
+		}
+		annotation.Body = *payload.Body
+	}
+	if payload.Resolved != nil {
+		if annotation.UserID != int64(userId) {
+			if err := r.authorizeChapter(c, repositories.PermissionChapterWrite, chapter); err != nil {
+				return err
+			}
+		}
+		switch {
+		case !*payload.Resolved:
+			annotation.ResolvedAt = nil
+		case annotation.ResolvedAt == nil:
+			now := time.Now().UTC()
+			annotation.ResolvedAt = &now
+		}
+	}
+	if err := r.Repository.Annotation.Update(annotation); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithAnnotation(c, http.StatusOK, annotation.ID)
+}
+
+// the annotator or anyone able to write the chapter
+func (r Router) DeleteAnnotation(c echo.Context) error {
+	chapter, annotation, err := r.getAnnotationFromParams(c)
+	if err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	if annotation.UserID != int64(userId) {
+		if err := r.authorizeChapter(c, repositories.PermissionChapterWrite, chapter); err != nil {
+			return err
+		}
+	}
+	if err := r.Repository.Annotation.Delete(annotation.ID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// the chapter from the `chapterUID` param, annotations are limited to the author & the collaborators of the book
+func (r Router) getAnnotatableChapter(c echo.Context) (*repositories.Chapter, error) {
+	chapterId, err := strconv.Atoi(c.Param("chapterUID"))
+	if err != nil {
+		return nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	chapter, err := r.Repository.Chapter.GetByID(int64(chapterId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, r.notFoundError(err)
+		default:
+			return nil, r.serverError(err)
+		}
+	}
+	if err := r.authorizeChapter(c, repositories.PermissionBookRead, chapter); err != nil {
+		return nil, err
+	}
+	return chapter, nil
+}
+
+// the chapter & its annotation from the `annotationId` param
+func (r Router) getAnnotationFromParams(c echo.Context) (*repositories.Chapter, *repositories.Annotation, error) {
+	chapter, err := r.getAnnotatableChapter(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	annotationId, err := strconv.Atoi(c.Param("annotationId"))
+	if err != nil {
+		return nil, nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	content, err := r.Repository.Content.Get(chapter.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, nil, r.notFoundError(err)
+		default:
+			return nil, nil, r.serverError(err)
+		}
+	}
+	annotation, err := r.Repository.Annotation.Get(int64(annotationId))
+	if err != nil || annotation.ContentID != content.ID {
+		switch {
+		case err == nil, errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, nil, r.notFoundError(utils.ErrorRecordsNotFound)
+		default:
+			return nil, nil, r.serverError(err)
+		}
+	}
+	return chapter, annotation, nil
+}
+
+func (r Router) respondWithAnnotation(c echo.Context, status int, annotationId int64) error {
+	annotation, err := r.Repository.Annotation.Get(annotationId)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(status, Response[repositories.Annotation]{
+		OK:   true,
+		Data: *annotation,
+	})
+}
@@ -7,9 +7,12 @@ import (
 	"gin_stuff/internals/services"
 	"gin_stuff/internals/utils"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
 )
 
 type LoginPayload struct {
@@ -26,6 +29,11 @@ type RegisterPayload struct {
 	DateOfBirth       *time.Time `json:"dateOfBirth" validate:"birthday"`
 	Gender            *string    `json:"string" validate:"required"`
 	ProfilePicture    *string    `json:"profilePicture" validate:"required,url"`
+	Locale            string     `json:"locale" validate:"omitempty,max=16"`
+}
+
+type UpdateLocalePayload struct {
+	Locale string `json:"locale" validate:"required,max=16"`
 }
 
 type ForgetPasswordPayload struct {
@@ -38,9 +46,14 @@ type ResetPasswordPayload struct {
 	NewPassword string `json:"password" validate:"required,min=6,max=20,strongPassword"`
 }
 
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
 type LoginResponseData struct {
-	AccessToken services.SignedJwtResult `json:"accessToken"`
-	UserId      int                      `json:"userId"`
+	AccessToken  services.SignedJwtResult `json:"accessToken"`
+	RefreshToken services.SignedJwtResult `json:"refreshToken"`
+	UserId       int                      `json:"userId"`
 }
 
 // Handler
@@ -65,19 +78,151 @@ func (r Router) Login(c echo.Context) error {
 			return r.serverError(err)
 		}
 	}
-	accessToken, err := r.JwtService.SignAccessToken(user.ID)
+	cryptoService := services.NewCryptoService()
+	jti := cryptoService.GenerateSecureToken(16)
+	accessToken, err := r.JwtService.SignAccessToken(user.ID, jti)
+	if err != nil {
+		return r.serverError(err)
+	}
+	refreshToken, refreshTokenRecord, err := r.signRefreshToken(user.ID)
 	if err != nil {
 		return r.serverError(err)
 	}
+	refreshTokenRecord.FamilyID = cryptoService.GenerateSecureToken(16)
+	session := repositories.Session{
+		UserID:    user.ID,
+		JTI:       jti,
+		FamilyID:  refreshTokenRecord.FamilyID,
+		UserAgent: c.Request().UserAgent(),
+		IPAddress: c.RealIP(),
+		ExpiresAt: refreshTokenRecord.ExpiresAt,
+	}
+	if err := r.Repository.Session.Insert(&session, refreshTokenRecord); err != nil {
+		return r.serverError(err)
+	}
 	return c.JSON(http.StatusOK, Response[LoginResponseData]{
 		OK: true,
 		Data: LoginResponseData{
-			AccessToken: accessToken,
-			UserId:      int(user.ID),
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+			UserId:       int(user.ID),
 		},
 	})
 }
 
+// exchange a refresh token for a new access & refresh token pair.
+// a refresh token can only be used once, replaying it revokes every token of its family
+func (r Router) RefreshToken(c echo.Context) error {
+	validate := utils.NewValidator()
+	payload := new(RefreshTokenPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	claims, err := r.JwtService.VerifyRefreshToken(payload.RefreshToken)
+	if err != nil {
+		return r.unauthorizedError(utils.ErrorInvalidToken)
+	}
+	tokenId, ok := claims["jti"].(string)
+	if !ok || tokenId == "" {
+		return r.unauthorizedError(utils.ErrorInvalidToken)
+	}
+	cryptoService := services.NewCryptoService()
+	current, err := r.Repository.Refresh.GetByHash(cryptoService.HashToken(tokenId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.unauthorizedError(utils.ErrorInvalidToken)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if userId, ok := claims["claims"].(float64); !ok || int64(userId) != current.UserID {
+		return r.unauthorizedError(utils.ErrorInvalidToken)
+	}
+	if current.RevokedAt != nil {
+		return r.unauthorizedError(utils.ErrorInvalidToken)
+	}
+	session, err := r.Repository.Session.GetByFamily(current.FamilyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.unauthorizedError(utils.ErrorInvalidToken)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if current.UsedAt != nil {
+		return r.revokeReusedTokenFamily(session)
+	}
+	if !session.Active() {
+		return r.unauthorizedError(utils.ErrorSessionRevoked)
+	}
+
+	refreshToken, next, err := r.signRefreshToken(current.UserID)
+	if err != nil {
+		return r.serverError(err)
+	}
+	previousJti := session.JTI
+	jti := cryptoService.GenerateSecureToken(16)
+	if err := r.Repository.Session.Rotate(session, jti, current, next); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorTokenReused):
+			// lost the race against another request using the same token
+			return r.revokeReusedTokenFamily(session)
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.unauthorizedError(utils.ErrorSessionRevoked)
+		default:
+			return r.serverError(err)
+		}
+	}
+	r.SessionCache.Delete(previousJti)
+	accessToken, err := r.JwtService.SignAccessToken(current.UserID, jti)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[LoginResponseData]{
+		OK: true,
+		Data: LoginResponseData{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+			UserId:       int(current.UserID),
+		},
+	})
+}
+
+func (r Router) revokeReusedTokenFamily(session *repositories.Session) error {
+	if err := r.Repository.Refresh.RevokeFamily(session.FamilyID); err != nil {
+		return r.serverError(err)
+	}
+	r.SessionCache.Delete(session.JTI)
+	return r.unauthorizedError(utils.ErrorTokenReused)
+}
+
+// sign a new refresh token, the returned record still needs a family before being saved
+func (r Router) signRefreshToken(userId int64) (services.SignedJwtResult, *repositories.RefreshToken, error) {
+	cryptoService := services.NewCryptoService()
+	tokenId := cryptoService.GenerateSecureToken(32)
+	if tokenId == "" {
+		return services.SignedJwtResult{}, nil, errors.New("fail to generate refresh token id")
+	}
+	signed, err := r.JwtService.SignRefreshToken(userId, tokenId)
+	if err != nil {
+		return services.SignedJwtResult{}, nil, err
+	}
+	return signed, &repositories.RefreshToken{
+		UserID:    userId,
+		TokenHash: cryptoService.HashToken(tokenId),
+		ExpiresAt: &signed.ExpiresAt,
+	}, nil
+}
+
 func (r Router) VerifyEmail(c echo.Context) error {
 	validate := utils.NewValidator()
 	payload := new(struct {
@@ -97,18 +242,18 @@ func (r Router) VerifyEmail(c echo.Context) error {
 	if user.Verified || user.Status != "active" {
 		return r.badRequestError(fmt.Errorf("invalid user status"))
 	}
-	if user.VerificationToken == payload.Token {
-		user.VerificationToken = ""
-		user.Verified = true
-		err := r.Repository.User.Update(user)
-		if err != nil {
+	cryptoService := services.NewCryptoService()
+	if err := r.Repository.User.Verify(user, cryptoService.HashToken(payload.Token)); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorInvalidToken):
+			return r.unauthorizedError(err)
+		default:
 			return r.serverError(err)
 		}
-		return c.JSON(http.StatusOK, Response[any]{
-			OK: true,
-		})
 	}
-	return r.unauthorizedError(utils.ErrorInvalidToken)
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
 }
 
 func (r Router) ResendVerificationEmail(c echo.Context) error {
@@ -123,18 +268,15 @@ func (r Router) ResendVerificationEmail(c echo.Context) error {
 	if user.Verified {
 		return r.badRequestError(fmt.Errorf("user is already verified"))
 	}
-	cryptoService := services.NewCryptoService()
-	verificationToken := cryptoService.GenerateSecureToken(32)
-	user.VerificationToken = verificationToken
-	if err := r.Repository.User.Update(user); err != nil {
+	verificationToken, token, err := r.newUserToken(user.ID, repositories.TokenPurposeEmailVerification)
+	if err != nil {
 		return r.serverError(err)
 	}
-	if err := r.MailerService.Perform(&services.Mail{
-		From:    "no-reply@novelism.com",
-		To:      user.Email,
-		Subject: "Please verify your email!",
-		Content: fmt.Sprintf("https://frontent-link/verify-email?token=%s&user_id=%d", user.VerificationToken, user.ID),
-	}); err != nil {
+	mail, err := r.userTokenMail(services.MailTemplateVerification, "/verify-email", user, verificationToken)
+	if err != nil {
+		return r.serverError(err)
+	}
+	if err := r.Repository.Token.Insert(token, mail); err != nil {
 		return r.serverError(err)
 	}
 	return c.JSON(http.StatusOK, Response[any]{
@@ -165,24 +307,27 @@ func (r Router) Register(c echo.Context) error {
 		ProfilePicture: registerPayload.ProfilePicture,
 		Status:         "active",
 		Verified:       false,
+		Locale:         registerPayload.Locale,
+	}
+	if user.Locale == "" {
+		user.Locale = r.MailTemplateService.Negotiate(c.Request().Header.Get("Accept-Language"))
+	} else if !r.MailTemplateService.Supports(user.Locale) {
+		return r.badRequestError(utils.ErrorUnsupportedLocale)
 	}
 	if err := user.SetPassword(registerPayload.PlaintextPassword); err != nil {
 		return r.serverError(err)
 	}
-	cryptoService := services.NewCryptoService()
-	verificationToken := cryptoService.GenerateSecureToken(32)
-	user.VerificationToken = verificationToken
-	if err := r.Repository.User.Insert(user); err != nil {
-		return r.badRequestError(err)
-	}
-	if err := r.MailerService.Perform(&services.Mail{
-		From:    "no-reply@novelism.com",
-		To:      user.Email,
-		Subject: "Welcome to novelism! Please verify your email",
-		Content: fmt.Sprintf("https://frontend-link/verify-email?token=%s&user_id=%d", user.VerificationToken, user.ID),
-	}); err != nil {
+	verificationToken, token, err := r.newUserToken(0, repositories.TokenPurposeEmailVerification)
+	if err != nil {
 		return r.serverError(err)
 	}
+	roles := []string{repositories.DefaultRegistrationRole}
+	err = r.Repository.User.Register(user, roles, token, func(user *repositories.User) (*services.Mail, error) {
+		return r.userTokenMail(services.MailTemplateVerification, "/verify-email", user, verificationToken)
+	})
+	if err != nil {
+		return r.badRequestError(err)
+	}
 	return c.JSON(http.StatusCreated, Response[any]{
 		OK: true,
 	})
@@ -201,19 +346,15 @@ func (r Router) ForgetPassword(c echo.Context) error {
 	if err != nil {
 		return r.badRequestError(err)
 	}
-	cryptoService := services.NewCryptoService()
-	passwordResetToken := cryptoService.GenerateSecureToken(32)
-	user.PasswordResetToken = passwordResetToken
-	if err := r.Repository.User.Update(user); err != nil {
+	passwordResetToken, token, err := r.newUserToken(user.ID, repositories.TokenPurposePasswordReset)
+	if err != nil {
 		return r.serverError(err)
 	}
-
-	if err := r.MailerService.Perform(&services.Mail{
-		From:    "no-reply@novelism.com",
-		To:      user.Email,
-		Subject: "Please reset your password",
-		Content: fmt.Sprintf("https://frontend-link/reset-password?token=%s&user_id=%d", user.PasswordResetToken, user.ID),
-	}); err != nil {
+	mail, err := r.userTokenMail(services.MailTemplatePasswordReset, "/reset-password", user, passwordResetToken)
+	if err != nil {
+		return r.serverError(err)
+	}
+	if err := r.Repository.Token.Insert(token, mail); err != nil {
 		return r.serverError(err)
 	}
 	return c.JSON(http.StatusOK, Response[any]{
@@ -234,21 +375,99 @@ func (r Router) ResetPassword(c echo.Context) error {
 	if err != nil {
 		return r.badRequestError(err)
 	}
-	if payload.Token != user.PasswordResetToken {
-		return r.unauthorizedError(utils.NewError("invalid error", 403))
-	}
 	if err := user.SetPassword(payload.NewPassword); err != nil {
-
 		return r.serverError(err)
 	}
-	if err := r.Repository.User.Update(user); err != nil {
-		return r.serverError(err)
+	cryptoService := services.NewCryptoService()
+	sessions, err := r.Repository.User.ResetPassword(user, cryptoService.HashToken(payload.Token))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorInvalidToken):
+			return r.unauthorizedError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	for _, session := range sessions {
+		r.SessionCache.Delete(session.JTI)
 	}
 	return c.JSON(200, Response[any]{
 		OK: true,
 	})
 }
 
+// generate a single use token for the user, only its hash is persisted.
+// the plaintext token is returned so it can be sent by email, the token still has to be inserted
+func (r Router) newUserToken(userId int64, purpose string) (string, *repositories.UserToken, error) {
+	ttl := viper.GetDuration("token.verification_ttl")
+	if purpose == repositories.TokenPurposePasswordReset {
+		ttl = viper.GetDuration("token.password_reset_ttl")
+	}
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	cryptoService := services.NewCryptoService()
+	plaintext := cryptoService.GenerateSecureToken(32)
+	if plaintext == "" {
+		return "", nil, errors.New("fail to generate token")
+	}
+	expiresAt := time.Now().UTC().Add(ttl)
+	token := &repositories.UserToken{
+		UserID:    userId,
+		Purpose:   purpose,
+		TokenHash: cryptoService.HashToken(plaintext),
+		ExpiresAt: &expiresAt,
+	}
+	return plaintext, token, nil
+}
+
+// the mail carrying a single use token, its link leads to pagePath of the frontend
+func (r Router) userTokenMail(template string, pagePath string, user *repositories.User, token string) (*services.Mail, error) {
+	link := r.MailTemplateService.Link(pagePath, url.Values{
+		"token":   []string{token},
+		"user_id": []string{strconv.FormatInt(user.ID, 10)},
+	})
+	return r.MailTemplateService.Render(template, user.Locale, user.Email, services.MailData{
+		Username: user.Username,
+		Link:     link,
+	})
+}
+
+// language of the mails sent to the current user
+func (r Router) UpdateLocale(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	validate := utils.NewValidator()
+	payload := new(UpdateLocalePayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	if !r.MailTemplateService.Supports(payload.Locale) {
+		return r.badRequestError(utils.ErrorUnsupportedLocale)
+	}
+	user, err := r.Repository.User.Get(int64(userId))
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	user.Locale = payload.Locale
+	if err := r.Repository.User.Update(user); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.User]{
+		OK:   true,
+		Data: *user,
+	})
+}
+
 func (r Router) Me(c echo.Context) error {
 	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
 	if err != nil {
@@ -258,6 +477,14 @@ func (r Router) Me(c echo.Context) error {
 	if err != nil {
 		return r.badRequestError(err)
 	}
+	user.Roles, err = r.Repository.Role.GetUserRoles(user.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
+	user.Counts, err = r.Repository.User.Counts(user.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
 	return c.JSON(200, Response[repositories.User]{
 		OK:   true,
 		Data: *user,
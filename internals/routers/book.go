@@ -11,13 +11,20 @@ import (
 )
 
 type CreateBookPayload struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Status      string   `json:"status" validate:"omitempty,oneof=draft published unlisted archived"`
+	Genres      []string `json:"genres" validate:"omitempty,max=5,dive,max=64"`
+	Tags        []string `json:"tags" validate:"omitempty,max=20,dive,max=64"`
 }
 
+// omit genres/tags to keep them as is, an empty list removes all of them
 type UpdateBookPayload struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Status      string   `json:"status" validate:"omitempty,oneof=draft published unlisted archived"`
+	Genres      []string `json:"genres" validate:"omitempty,max=5,dive,max=64"`
+	Tags        []string `json:"tags" validate:"omitempty,max=20,dive,max=64"`
 }
 
 func (r Router) CreateBook(c echo.Context) error {
@@ -33,6 +40,9 @@ func (r Router) CreateBook(c echo.Context) error {
 			return r.serverError(err)
 		}
 	}
+	if err := r.validateGenres(createBookPayload.Genres); err != nil {
+		return err
+	}
 	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
 	if err != nil {
 		return r.forbiddenError(err)
@@ -46,13 +56,18 @@ func (r Router) CreateBook(c echo.Context) error {
 		User:        user,
 		Title:       createBookPayload.Title,
 		Description: createBookPayload.Description,
+		Status:      createBookPayload.Status,
 	}
-	if err := r.Repository.Book.Insert(&book); err != nil {
+	if err := r.Repository.Book.Insert(&book, createBookPayload.Genres, createBookPayload.Tags); err != nil {
 		return r.badRequestError(err)
 	}
+	created, err := r.Repository.Book.Get(book.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
 	return c.JSON(http.StatusCreated, Response[repositories.Book]{
 		OK:   true,
-		Data: book,
+		Data: *created,
 	})
 }
 
@@ -65,10 +80,6 @@ func (r Router) GetBook(c echo.Context) error {
 	if err != nil {
 		return r.badRequestError(err)
 	}
-	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
-	if err != nil {
-		return r.forbiddenError(err)
-	}
 	book, err := r.Repository.Book.Get(int64(id))
 	if err != nil {
 		switch {
@@ -78,8 +89,20 @@ func (r Router) GetBook(c echo.Context) error {
 			return r.serverError(err)
 		}
 	}
-	if book.UserID != int64(userId) {
-		return r.forbiddenError(utils.ErrorForbiddenResource)
+	if err := r.authorizeBookView(c, book); err != nil {
+		return err
+	}
+	subscriberCount, err := r.Repository.Subscription.CountSubscribers(book.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
+	book.SubscriberCount = &subscriberCount
+	if viewerId := r.viewerId(c); viewerId != 0 {
+		subscribed, err := r.Repository.Subscription.IsSubscribed(viewerId, book.ID)
+		if err != nil {
+			return r.serverError(err)
+		}
+		book.Subscribed = &subscribed
 	}
 	return c.JSON(http.StatusOK, echo.Map{
 		"ok":   true,
@@ -109,9 +132,8 @@ func (r Router) UpdateBook(c echo.Context) error {
 			return r.serverError(err)
 		}
 	}
-	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
-	if err != nil {
-		return r.forbiddenError(err)
+	if err := r.validateGenres(updateBookPayload.Genres); err != nil {
+		return err
 	}
 	book, err := r.Repository.Book.Get(int64(id))
 	if err != nil {
@@ -122,8 +144,8 @@ func (r Router) UpdateBook(c echo.Context) error {
 			return r.serverError(err)
 		}
 	}
-	if book.UserID != int64(userId) {
-		return r.forbiddenError(utils.ErrorForbiddenResource)
+	if err := r.authorize(c, repositories.PermissionBookUpdate, book.UserID); err != nil {
+		return err
 	}
 	if updateBookPayload.Title != "" {
 		book.Title = updateBookPayload.Title
@@ -131,9 +153,16 @@ func (r Router) UpdateBook(c echo.Context) error {
 	if updateBookPayload.Description != "" {
 		book.Description = updateBookPayload.Description
 	}
-	err = r.Repository.Book.Update(book)
+	if updateBookPayload.Status != "" {
+		book.Status = updateBookPayload.Status
+	}
+	err = r.Repository.Book.Update(book, updateBookPayload.Genres, updateBookPayload.Tags)
 	if err != nil {
-		r.serverError(err)
+		return r.serverError(err)
+	}
+	book, err = r.Repository.Book.Get(book.ID)
+	if err != nil {
+		return r.serverError(err)
 	}
 	return c.JSON(http.StatusOK, Response[repositories.Book]{
 		OK:   true,
@@ -150,10 +179,6 @@ func (r Router) DeleteBook(c echo.Context) error {
 	if err != nil {
 		return r.badRequestError(err)
 	}
-	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
-	if err != nil {
-		return r.forbiddenError(err)
-	}
 	book, err := r.Repository.Book.Get(int64(id))
 	if err != nil {
 		switch {
@@ -163,8 +188,8 @@ func (r Router) DeleteBook(c echo.Context) error {
 			return r.serverError(err)
 		}
 	}
-	if book.UserID != int64(userId) {
-		return r.forbiddenError(utils.ErrorForbiddenResource)
+	if err := r.authorize(c, repositories.PermissionBookDelete, book.UserID); err != nil {
+		return err
 	}
 	err = r.Repository.Book.Delete(int64(id))
 	if err != nil {
@@ -181,14 +206,28 @@ func (r Router) FindBooks(c echo.Context) error {
 		return r.unauthorizedError(err)
 	}
 	filter := repositories.Filter{
-		Page:         1,
-		PageSize:     10,
-		SortSafeList: []string{"id", "title", "-id", "-title", "created_at", "-created_at"},
-		Sort:         "created_at", // default sort
+		Page:     1,
+		PageSize: 10,
+		SortSafeList: []string{
+			"id",
+			"title",
+			"-id",
+			"-title",
+			"created_at",
+			"-created_at",
+			"published_at",
+			"-published_at",
+			"rating_average",
+			"-rating_average",
+			"rating_count",
+			"-rating_count",
+		},
+		Sort: "created_at", // default sort
 	}
 
 	userId := currentUserId // default to current session
 	var title string
+	var statuses []string
 
 	queryParams := c.QueryParams()
 	if queryParams.Has("userId") {
@@ -218,7 +257,26 @@ func (r Router) FindBooks(c echo.Context) error {
 	if queryParams.Has("sort") {
 		filter.Sort = queryParams.Get("sort")
 	}
-	books, metadata, err := r.Repository.Book.Find(userId, title, filter)
+	if queryParams.Has("status") {
+		status := queryParams.Get("status")
+		if !utils.IsItemInCollection(status, repositories.PublicationStatuses) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		statuses = []string{status}
+	}
+	taxonomy, err := r.parseBookTaxonomyFilter(c)
+	if err != nil {
+		return err
+	}
+	// other people's drafts & unlisted books stay hidden
+	allowed, err := r.can(int64(currentUserId), repositories.PermissionBookRead, int64(userId))
+	if err != nil {
+		return r.serverError(err)
+	}
+	if !allowed {
+		statuses = []string{repositories.PublicationStatusPublished}
+	}
+	books, metadata, err := r.Repository.Book.Find(userId, title, statuses, taxonomy, filter)
 	if err != nil {
 		return r.serverError(err)
 	}
@@ -0,0 +1,172 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// the routes are also limited to a bit more, see app.RegisterRoute
+	maxImportFileSize = 20 << 20
+	maxImportChapters = 1000
+	// same as the validation of CreateChapterPayload
+	maxChapterTitleLength = 128
+	// how much of each chapter the preview shows
+	importExcerptLength = 200
+)
+
+// the file is sent as the `file` field of a multipart form, along with these fields.
+// the title & description of the file are used when they are left empty
+type ImportBookPayload struct {
+	Title       string `form:"title"`
+	Description string `form:"description"`
+	Status      string `form:"status" validate:"omitempty,oneof=draft published unlisted archived"`
+}
+
+type ImportPreview struct {
+	Title       string                 `json:"title"`
+	Description string                 `json:"description"`
+	Chapters    []ImportPreviewChapter `json:"chapters"`
+}
+
+type ImportPreviewChapter struct {
+	ChapterNO int64  `json:"chapterNo"`
+	Title     string `json:"title"`
+	Format    string `json:"format"`
+	Length    int    `json:"length"`
+	Excerpt   string `json:"excerpt"`
+}
+
+// what ImportBook would create from the file, nothing is saved
+func (r Router) PreviewBookImport(c echo.Context) error {
+	manuscript, err := r.readManuscript(c)
+	if err != nil {
+		return err
+	}
+	preview := ImportPreview{
+		Title:       manuscript.Title,
+		Description: manuscript.Description,
+		Chapters:    make([]ImportPreviewChapter, 0, len(manuscript.Chapters)),
+	}
+	for _, chapter := range manuscript.Chapters {
+		excerpt := []rune(chapter.Text)
+		preview.Chapters = append(preview.Chapters, ImportPreviewChapter{
+			ChapterNO: chapter.ChapterNO,
+			Title:     chapter.Title,
+			Format:    chapter.Format,
+			Length:    len(excerpt),
+			Excerpt:   string(excerpt[:min(len(excerpt), importExcerptLength)]),
+		})
+	}
+	return c.JSON(http.StatusOK, Response[ImportPreview]{
+		OK:   true,
+		Data: preview,
+	})
+}
+
+// create a book from a .md, .docx or .epub manuscript, with 1 chapter per heading or spine item.
+// clients confirm a preview by sending the same file again. The chapters get the status of the book, draft by default
+func (r Router) ImportBook(c echo.Context) error {
+	validate := utils.NewValidator()
+	payload := new(ImportBookPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	manuscript, err := r.readManuscript(c)
+	if err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	user, err := r.Repository.User.Get(int64(userId))
+	if err != nil {
+		return r.serverError(err)
+	}
+	book := repositories.Book{
+		User:        user,
+		Title:       manuscript.Title,
+		Description: manuscript.Description,
+		Status:      payload.Status,
+	}
+	if payload.Title != "" {
+		book.Title = payload.Title
+	}
+	if payload.Description != "" {
+		book.Description = payload.Description
+	}
+	chapters := make([]*repositories.Chapter, 0, len(manuscript.Chapters))
+	for _, chapter := range manuscript.Chapters {
+		chapters = append(chapters, &repositories.Chapter{
+			Title:   chapter.Title,
+			Content: &repositories.Content{TextContent: chapter.Text, Format: chapter.Format},
+		})
+	}
+	if err := r.Repository.Book.Import(&book, chapters); err != nil {
+		return r.serverError(err)
+	}
+	created, err := r.Repository.Book.Get(book.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusCreated, Response[repositories.Book]{
+		OK:   true,
+		Data: *created,
+	})
+}
+
+// parse the uploaded manuscript, the chapters are fitted to what a chapter can hold:
+// titles are cut & untitled chapters numbered. Too many chapters or content too long are refused while parsing
+func (r Router) readManuscript(c echo.Context) (*services.Manuscript, error) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		return nil, r.badRequestError(err)
+	}
+	if header.Size > maxImportFileSize {
+		return nil, utils.ErrorContentTooLarge
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, r.serverError(err)
+	}
+	defer file.Close()
+	limits := services.ManuscriptLimits{Chapters: maxImportChapters, ChapterLength: maxContentLength}
+	manuscript, err := services.NewManuscriptService().Parse(header.Filename, file, header.Size, limits)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorUnsupportedFile):
+			return nil, err
+		case errors.Is(err, services.ErrorManuscriptTooLarge):
+			return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		default:
+			return nil, r.badRequestError(fmt.Errorf("invalid manuscript: %w", err))
+		}
+	}
+	if len(manuscript.Chapters) == 0 {
+		return nil, r.badRequestError(errors.New("invalid manuscript: no chapter found"))
+	}
+	for index := range manuscript.Chapters {
+		chapter := &manuscript.Chapters[index]
+		if chapter.Title == "" {
+			chapter.Title = fmt.Sprintf("Chapter %d", chapter.ChapterNO)
+		}
+		if title := []rune(chapter.Title); len(title) > maxChapterTitleLength {
+			chapter.Title = string(title[:maxChapterTitleLength])
+		}
+	}
+	return manuscript, nil
+}
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// public listing of the published books, no authentication required.
+// filter with title, authorId, genres, excludeGenres, tags & excludeTags
+func (r Router) FindCatalog(c echo.Context) error {
+	filter := repositories.Filter{
+		Page:     1,
+		PageSize: 20,
+		SortSafeList: []string{
+			"id",
+			"-id",
+			"title",
+			"-title",
+			"created_at",
+			"-created_at",
+			"published_at",
+			"-published_at",
+			"rating_average",
+			"-rating_average",
+			"rating_count",
+			"-rating_count",
+		},
+		Sort: "-published_at", // newest first
+	}
+	var title string
+	var authorId int
+
+	queryParams := c.QueryParams()
+	if queryParams.Has("title") {
+		title = queryParams.Get("title")
+	}
+	if queryParams.Has("authorId") {
+		var err error
+		authorId, err = strconv.Atoi(queryParams.Get("authorId"))
+		if err != nil || authorId < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	taxonomy, err := r.parseBookTaxonomyFilter(c)
+	if err != nil {
+		return err
+	}
+	books, metadata, err := r.Repository.Book.FindPublished(int64(authorId), title, taxonomy, filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Book]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     books,
+	})
+}
@@ -14,11 +14,13 @@ type CreateChapterPayload struct {
 	BookID      int    `validate:"required,gte=1"`
 	Title       string `json:"title" validate:"required,max=128"`
 	Description string `json:"description"`
+	Status      string `json:"status" validate:"omitempty,oneof=draft published unlisted archived"`
 }
 
 type UpdateChapterPayload struct {
 	Title       string `json:"title" validate:"max=128"`
 	Description string `json:"description"`
+	Status      string `json:"status" validate:"omitempty,oneof=draft published unlisted archived"`
 }
 
 func (r Router) CreateChapter(c echo.Context) error {
@@ -29,10 +31,6 @@ func (r Router) CreateChapter(c echo.Context) error {
 	if err != nil {
 		return r.badRequestError(err)
 	}
-	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
-	if err != nil {
-		return r.forbiddenError(err)
-	}
 	book, err := r.Repository.Book.Get(int64(id))
 	if err != nil {
 		switch {
@@ -42,8 +40,12 @@ func (r Router) CreateChapter(c echo.Context) error {
 			return r.serverError(err)
 		}
 	}
-	if book.UserID != int64(userId) {
-		return r.forbiddenError(utils.ErrorForbiddenResource)
+	if err := r.authorizeBook(c, repositories.PermissionChapterWrite, book.ID, book.UserID); err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
 	}
 	createChapterPayload.BookID = id
 	if err := c.Bind(createChapterPayload); err != nil {
@@ -57,29 +59,34 @@ func (r Router) CreateChapter(c echo.Context) error {
 		}
 	}
 	chapter := repositories.Chapter{
-		AuthorID:    book.UserID,
+		AuthorID:    int64(userId),
 		BookID:      book.ID,
 		Title:       createChapterPayload.Title,
 		Description: createChapterPayload.Description,
+		Status:      createChapterPayload.Status,
 	}
 	err = r.Repository.Chapter.Insert(&chapter)
 	if err != nil {
 		return r.serverError(err)
 	}
+	if chapter.PublishedAt != nil {
+		r.notifyNewChapter(book, &chapter)
+	}
 	return c.JSON(http.StatusCreated, Response[repositories.Chapter]{
 		OK:   true,
 		Data: chapter,
 	})
 }
 
-// get all chapters from 1 book (with optional filter)
+// get all chapters from 1 book (with optional filter).
+// readers only get the published chapters, the people working on the book get all of them
 func (r Router) FindChapters(c echo.Context) error {
 	bookIdStr := c.Param("bookId")
 	bookId, err := strconv.Atoi(bookIdStr)
 	if err != nil {
 		return r.badRequestError(err)
 	}
-	_, err = r.Repository.Book.Get(int64(bookId))
+	book, err := r.Repository.Book.Get(int64(bookId))
 	if err != nil {
 		switch {
 		case errors.Is(err, utils.ErrorRecordsNotFound):
@@ -88,6 +95,17 @@ func (r Router) FindChapters(c echo.Context) error {
 			return r.serverError(err)
 		}
 	}
+	if err := r.authorizeBookView(c, book); err != nil {
+		return err
+	}
+	canReadUnpublished, err := r.canReadUnpublished(c, book)
+	if err != nil {
+		return r.serverError(err)
+	}
+	var statuses []string
+	if !canReadUnpublished {
+		statuses = []string{repositories.PublicationStatusPublished}
+	}
 	var title string
 	filter := repositories.Filter{
 		Page:     1,
@@ -103,6 +121,8 @@ func (r Router) FindChapters(c echo.Context) error {
 			"-created_at",
 			"updated_at",
 			"-updated_at",
+			"published_at",
+			"-published_at",
 		},
 		Sort: "chapter_no",
 	}
@@ -128,7 +148,7 @@ func (r Router) FindChapters(c echo.Context) error {
 		filter.Page = page
 	}
 
-	chapters, metadata, err := r.Repository.Chapter.Find(int64(bookId), title, filter)
+	chapters, metadata, err := r.Repository.Chapter.Find(book.ID, title, statuses, filter)
 	if err != nil {
 		return r.serverError(err)
 	}
@@ -148,10 +168,6 @@ func (r Router) UpdateChapter(c echo.Context) error {
 	if err != nil {
 		return r.badRequestError(err)
 	}
-	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
-	if err != nil {
-		return r.unauthorizedError(err)
-	}
 	validate := utils.NewValidator()
 	updateChapterPayload := new(UpdateChapterPayload)
 	if err := c.Bind(updateChapterPayload); err != nil {
@@ -173,18 +189,25 @@ func (r Router) UpdateChapter(c echo.Context) error {
 			return r.serverError(err)
 		}
 	}
-	if chapter.AuthorID != int64(userId) {
-		return r.forbiddenError(utils.ErrorForbiddenResource)
+	if err := r.authorizeChapter(c, repositories.PermissionChapterWrite, chapter); err != nil {
+		return err
 	}
+	wasPublished := chapter.PublishedAt != nil
 	if updateChapterPayload.Title != "" {
 		chapter.Title = updateChapterPayload.Title
 	}
 	if updateChapterPayload.Description != "" {
 		chapter.Description = updateChapterPayload.Description
 	}
+	if updateChapterPayload.Status != "" {
+		chapter.Status = updateChapterPayload.Status
+	}
 	err = r.Repository.Chapter.Update(chapter)
 	if err != nil {
-		r.serverError(err)
+		return r.serverError(err)
+	}
+	if !wasPublished && chapter.PublishedAt != nil {
+		r.notifyNewChapter(chapter.Book, chapter)
 	}
 	return c.JSON(http.StatusOK, Response[repositories.Chapter]{
 		OK:   true,
@@ -201,10 +224,6 @@ func (r Router) DeleteChapter(c echo.Context) error {
 	if err != nil {
 		return r.badRequestError(err)
 	}
-	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
-	if err != nil {
-		return r.forbiddenError(err)
-	}
 	chapter, err := r.Repository.Chapter.Get(int64(chapterNo), int64(bookId))
 	if err != nil {
 		switch {
@@ -214,8 +233,11 @@ func (r Router) DeleteChapter(c echo.Context) error {
 			return r.serverError(err)
 		}
 	}
-	if chapter.AuthorID != int64(userId) {
-		return r.forbiddenError(utils.ErrorForbiddenResource)
+	if err := r.authorizeChapter(c, repositories.PermissionChapterDelete, chapter); err != nil {
+		return err
+	}
+	if err := r.Repository.Chapter.Delete(chapter.ID); err != nil {
+		return r.serverError(err)
 	}
 	return c.JSON(http.StatusOK, Response[any]{
 		OK: true,
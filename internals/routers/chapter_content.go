@@ -3,21 +3,71 @@ package router
 import (
 	"errors"
 	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
 	"gin_stuff/internals/utils"
 	"net/http"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/labstack/echo/v4"
 )
 
+// maximum number of characters a single chapter content can hold
+const maxContentLength = 200000
+
+// echo has no constants for Markdown
+const (
+	mimeTextMarkdown            = "text/markdown"
+	mimeTextMarkdownCharsetUTF8 = "text/markdown; charset=UTF-8"
+)
+
+// html is sanitized before being saved
+type CreateContentPayload struct {
+	TextContent string `json:"textContent" validate:"required,max=200000"`
+	Format      string `json:"format" validate:"omitempty,oneof=plain markdown html"` // plain by default
+}
+
+type ReplaceContentPayload struct {
+	TextContent string `json:"textContent" validate:"required,max=200000"`
+	Format      string `json:"format" validate:"omitempty,oneof=plain markdown html"` // the current format by default
+}
+
+// replace the characters in range [start, end) with text.
+// omit both start & end to append text to the end of the current content, start alone to insert text there
+// & end alone to replace everything before it
+type PatchContentPayload struct {
+	Start *int   `json:"start" validate:"omitempty,gte=0"`
+	End   *int   `json:"end" validate:"omitempty,gte=0"`
+	Text  string `json:"text" validate:"max=200000"`
+}
+
+// the content as JSON, with its text rendered to sanitized HTML as well with ?render=html.
+// clients accepting text/html get the rendered HTML alone, text/plain or text/markdown the source alone
 func (r Router) GetContent(e echo.Context) error {
-	chapterIdStr := e.Param("chapterId")
+	render := e.QueryParam("render")
+	if render != "" && render != "raw" && render != "html" {
+		return r.badRequestError(utils.ErrorInvalidQueryParams)
+	}
+	chapterIdStr := e.Param("chapterUID")
 	chapterId, err := strconv.Atoi(chapterIdStr)
 	if err != nil {
 		return r.badRequestError(utils.ErrorInvalidRouteParam)
 	}
+	chapter, err := r.Repository.Chapter.GetByID(int64(chapterId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if err := r.authorizeChapterView(e, chapter); err != nil {
+		return err
+	}
 
-	content, err := r.Repository.Content.Get(int64(chapterId))
+	content, err := r.Repository.Content.Get(chapter.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, utils.ErrorRecordsNotFound):
@@ -26,9 +76,234 @@ func (r Router) GetContent(e echo.Context) error {
 			return r.serverError(err)
 		}
 	}
+	if content.HTML == "" {
+		// saved before the HTML was stored along with the text, until cmd/render_content_html stores it
+		content.HTML = services.NewContentService().Render(content.TextContent, content.Format)
+	}
 
+	e.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	switch negotiateContentType(e.Request().Header.Get(echo.HeaderAccept)) {
+	case echo.MIMETextHTML:
+		return e.HTML(http.StatusOK, content.HTML)
+	case echo.MIMETextPlain:
+		return e.Blob(http.StatusOK, echo.MIMETextPlainCharsetUTF8, []byte(content.TextContent))
+	case mimeTextMarkdown:
+		return e.Blob(http.StatusOK, mimeTextMarkdownCharsetUTF8, []byte(content.TextContent))
+	}
+	if render != "html" {
+		content.HTML = ""
+	}
 	return e.JSON(http.StatusOK, Response[repositories.Content]{
 		OK:   true,
 		Data: *content,
 	})
 }
+
+func (r Router) CreateContent(c echo.Context) error {
+	chapter, _, err := r.getAuthoredChapter(c)
+	if err != nil {
+		return err
+	}
+	validate := utils.NewValidator()
+	payload := new(CreateContentPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	_, err = r.Repository.Content.Get(chapter.ID)
+	if err == nil {
+		return r.conflictError(utils.ErrorRecordExisted)
+	}
+	if !errors.Is(err, utils.ErrorRecordsNotFound) {
+		return r.serverError(err)
+	}
+	text, err := r.storedContentText(payload.TextContent, payload.Format)
+	if err != nil {
+		return err
+	}
+	content := repositories.Content{
+		ChapterID:   chapter.ID,
+		Chapter:     chapter,
+		TextContent: text,
+		Format:      payload.Format,
+	}
+	if err := r.Repository.Content.Insert(&content); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusCreated, Response[repositories.Content]{
+		OK:   true,
+		Data: content,
+	})
+}
+
+// create the content if the chapter has none, otherwise replace the whole text
+func (r Router) ReplaceContent(c echo.Context) error {
+	chapter, userId, err := r.getAuthoredChapter(c)
+	if err != nil {
+		return err
+	}
+	validate := utils.NewValidator()
+	payload := new(ReplaceContentPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	content, err := r.Repository.Content.Get(chapter.ID)
+	if err != nil {
+		if !errors.Is(err, utils.ErrorRecordsNotFound) {
+			return r.serverError(err)
+		}
+		text, err := r.storedContentText(payload.TextContent, payload.Format)
+		if err != nil {
+			return err
+		}
+		content = &repositories.Content{
+			ChapterID:   chapter.ID,
+			Chapter:     chapter,
+			TextContent: text,
+			Format:      payload.Format,
+		}
+		if err := r.Repository.Content.Insert(content); err != nil {
+			return r.serverError(err)
+		}
+		return c.JSON(http.StatusCreated, Response[repositories.Content]{
+			OK:   true,
+			Data: *content,
+		})
+	}
+	if payload.Format != "" {
+		content.Format = payload.Format
+	}
+	content.TextContent, err = r.storedContentText(payload.TextContent, content.Format)
+	if err != nil {
+		return err
+	}
+	if err := r.Repository.Content.Update(content, userId); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Content]{
+		OK:   true,
+		Data: *content,
+	})
+}
+
+func (r Router) PatchContent(c echo.Context) error {
+	chapter, userId, err := r.getAuthoredChapter(c)
+	if err != nil {
+		return err
+	}
+	validate := utils.NewValidator()
+	payload := new(PatchContentPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	content, err := r.Repository.Content.Get(chapter.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	current := []rune(content.TextContent)
+	start, end := len(current), len(current)
+	switch {
+	case payload.Start != nil && payload.End != nil:
+		start, end = *payload.Start, *payload.End
+	case payload.Start != nil:
+		start, end = *payload.Start, *payload.Start
+	case payload.End != nil:
+		start, end = 0, *payload.End
+	}
+	if start > end || end > len(current) {
+		return r.badRequestError(utils.ErrorInvalidModel)
+	}
+	// the offsets are in the source, the patched text keeps the format of the content
+	patched := string(current[:start]) + payload.Text + string(current[end:])
+	content.TextContent, err = r.storedContentText(patched, content.Format)
+	if err != nil {
+		return err
+	}
+	if err := r.Repository.Content.Update(content, userId); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Content]{
+		OK:   true,
+		Data: *content,
+	})
+}
+
+// the text as saved in the format: HTML is sanitized on the way in, so what is stored is safe to show.
+// refuses text left empty or too long
+func (r Router) storedContentText(text string, format string) (string, error) {
+	if format == services.ContentFormatHTML {
+		text = services.NewContentService().Sanitize(text)
+	}
+	if utf8.RuneCountInString(text) > maxContentLength {
+		return "", utils.ErrorContentTooLarge
+	}
+	if len(text) == 0 {
+		return "", r.badRequestError(utils.ErrorInvalidModel)
+	}
+	return text, nil
+}
+
+// media type of the Accept header GetContent answers with, empty for JSON.
+// like the locale of the mails, the first supported type wins
+func negotiateContentType(accept string) string {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(mediaRange), ";")
+		switch mediaType = strings.ToLower(strings.TrimSpace(mediaType)); mediaType {
+		case echo.MIMEApplicationJSON, "application/*", "*/*":
+			return ""
+		case echo.MIMETextHTML, echo.MIMETextPlain, mimeTextMarkdown:
+			return mediaType
+		}
+	}
+	return ""
+}
+
+// find the chapter from route param & make sure the current user can write it
+func (r Router) getAuthoredChapter(c echo.Context) (*repositories.Chapter, int64, error) {
+	chapterId, err := strconv.Atoi(c.Param("chapterUID"))
+	if err != nil {
+		return nil, 0, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return nil, 0, r.unauthorizedError(err)
+	}
+	chapter, err := r.Repository.Chapter.GetByID(int64(chapterId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, 0, r.notFoundError(err)
+		default:
+			return nil, 0, r.serverError(err)
+		}
+	}
+	if err := r.authorizeChapter(c, repositories.PermissionChapterWrite, chapter); err != nil {
+		return nil, 0, err
+	}
+	return chapter, int64(userId), nil
+}
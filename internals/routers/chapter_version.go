@@ -0,0 +1,193 @@
+package router
+
+import (
+	"errors"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// get all versions of 1 chapter content (without the text)
+func (r Router) FindVersions(c echo.Context) error {
+	chapter, _, err := r.getAuthoredChapter(c)
+	if err != nil {
+		return err
+	}
+	content, err := r.Repository.Content.Get(chapter.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	filter := repositories.Filter{
+		Page:         1,
+		PageSize:     10,
+		SortSafeList: []string{"id", "-id", "created_at", "-created_at"},
+		Sort:         "-created_at",
+	}
+	queryParams := c.QueryParams()
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil {
+			return r.badRequestError(err)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil {
+			return r.badRequestError(err)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	versions, metadata, err := r.Repository.Version.Find(content.ID, filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.ChapterVersion]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     versions,
+	})
+}
+
+func (r Router) GetVersion(c echo.Context) error {
+	chapter, _, err := r.getAuthoredChapter(c)
+	if err != nil {
+		return err
+	}
+	versionId, err := strconv.Atoi(c.Param("versionId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	content, err := r.Repository.Content.Get(chapter.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	version, err := r.Repository.Version.Get(content.ID, int64(versionId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[repositories.ChapterVersion]{
+		OK:   true,
+		Data: *version,
+	})
+}
+
+// make a previous version the current content of the chapter
+func (r Router) RestoreVersion(c echo.Context) error {
+	chapter, userId, err := r.getAuthoredChapter(c)
+	if err != nil {
+		return err
+	}
+	versionId, err := strconv.Atoi(c.Param("versionId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	content, err := r.Repository.Content.Get(chapter.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	err = r.Repository.Content.Restore(content, int64(versionId), userId)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Content]{
+		OK:   true,
+		Data: *content,
+	})
+}
+
+// diff 2 versions of a chapter content.
+// `from` is a version id, `to` is a version id or "current" for the live content (default)
+func (r Router) DiffVersions(c echo.Context) error {
+	chapter, _, err := r.getAuthoredChapter(c)
+	if err != nil {
+		return err
+	}
+	queryParams := c.QueryParams()
+	granularity := services.DiffGranularityWord
+	if queryParams.Has("granularity") {
+		granularity = queryParams.Get("granularity")
+		if granularity != services.DiffGranularityWord && granularity != services.DiffGranularityLine {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	fromId, err := strconv.Atoi(queryParams.Get("from"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidQueryParams)
+	}
+	content, err := r.Repository.Content.Get(chapter.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	from, err := r.Repository.Version.Get(content.ID, int64(fromId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	toText := content.TextContent
+	if to := queryParams.Get("to"); to != "" && to != "current" {
+		toId, err := strconv.Atoi(to)
+		if err != nil {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		toVersion, err := r.Repository.Version.Get(content.ID, int64(toId))
+		if err != nil {
+			switch {
+			case errors.Is(err, utils.ErrorRecordsNotFound):
+				return r.notFoundError(err)
+			default:
+				return r.serverError(err)
+			}
+		}
+		toText = toVersion.TextContent
+	}
+	diffService := services.NewDiffService()
+	return c.JSON(http.StatusOK, Response[services.DiffResult]{
+		OK:   true,
+		Data: diffService.Diff(from.TextContent, toText, granularity),
+	})
+}
@@ -0,0 +1,232 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type InviteCollaboratorPayload struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required,oneof=co-author editor beta-reader"`
+}
+
+func (r Router) FindCollaborators(c echo.Context) error {
+	book, err := r.getBookFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorizeBook(c, repositories.PermissionBookRead, book.ID, book.UserID); err != nil {
+		return err
+	}
+	collaborators, err := r.Repository.Collaborator.FindByBook(book.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
+	// the invitees' emails are kept from the collaborators themselves
+	canManage, err := r.canManageCollaborators(r.viewerId(c), book)
+	if err != nil {
+		return r.serverError(err)
+	}
+	if !canManage {
+		for _, collaborator := range collaborators {
+			collaborator.Email = ""
+		}
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Collaborator]{
+		OK:   true,
+		Data: collaborators,
+	})
+}
+
+// invite a user to collaborate on the book, the invitee is notified (by email unless they opted out)
+func (r Router) InviteCollaborator(c echo.Context) error {
+	book, err := r.getBookFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorize(c, repositories.PermissionBookUpdate, book.UserID); err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	validate := utils.NewValidator()
+	payload := new(InviteCollaboratorPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	invitee, err := r.Repository.User.GetByEmail(payload.Email, "active")
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if invitee.ID == book.UserID {
+		return r.badRequestError(fmt.Errorf("the owner can't be a collaborator of their own book"))
+	}
+	collaborator := repositories.Collaborator{
+		BookID:    book.ID,
+		Book:      book,
+		UserID:    invitee.ID,
+		User:      &repositories.PublicUser{ID: invitee.ID, Username: invitee.Username},
+		Email:     invitee.Email,
+		InvitedBy: int64(userId),
+		Role:      payload.Role,
+	}
+	if err := r.Repository.Collaborator.Insert(&collaborator); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordExisted):
+			return r.conflictError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	inviterId := int64(userId)
+	r.notify(repositories.Notification{
+		Type:           repositories.NotificationTypeInvitation,
+		ActorID:        &inviterId,
+		BookID:         &book.ID,
+		CollaboratorID: &collaborator.ID,
+		Message:        fmt.Sprintf("You are invited to collaborate on \"%s\"", book.Title),
+	}, []int64{invitee.ID})
+	return c.JSON(http.StatusCreated, Response[repositories.Collaborator]{
+		OK:   true,
+		Data: collaborator,
+	})
+}
+
+// remove a collaborator, either by someone managing the book or by the collaborator leaving
+func (r Router) RemoveCollaborator(c echo.Context) error {
+	book, err := r.getBookFromParam(c)
+	if err != nil {
+		return err
+	}
+	collaboratorId, err := strconv.Atoi(c.Param("collaboratorId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	collaborator, err := r.Repository.Collaborator.Get(int64(collaboratorId))
+	if err != nil || collaborator.BookID != book.ID {
+		switch {
+		case err == nil, errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(utils.ErrorRecordsNotFound)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if collaborator.UserID != int64(userId) {
+		if err := r.authorize(c, repositories.PermissionBookUpdate, book.UserID); err != nil {
+			return err
+		}
+	}
+	if err := r.Repository.Collaborator.Delete(collaborator.ID); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// invitations & collaborations of the current user, filter with ?status=
+func (r Router) FindInvitations(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	status := c.QueryParam("status")
+	if status != "" && !utils.IsItemInCollection(status, []string{
+		repositories.CollaboratorStatusPending,
+		repositories.CollaboratorStatusAccepted,
+		repositories.CollaboratorStatusDeclined,
+	}) {
+		return r.badRequestError(utils.ErrorInvalidQueryParams)
+	}
+	collaborations, err := r.Repository.Collaborator.FindByUser(int64(userId), status)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Collaborator]{
+		OK:   true,
+		Data: collaborations,
+	})
+}
+
+func (r Router) AcceptInvitation(c echo.Context) error {
+	return r.respondToInvitation(c, repositories.CollaboratorStatusAccepted)
+}
+
+func (r Router) DeclineInvitation(c echo.Context) error {
+	return r.respondToInvitation(c, repositories.CollaboratorStatusDeclined)
+}
+
+func (r Router) respondToInvitation(c echo.Context, status string) error {
+	invitationId, err := strconv.Atoi(c.Param("invitationId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	collaborator, err := r.Repository.Collaborator.Get(int64(invitationId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if collaborator.UserID != int64(userId) {
+		return r.forbiddenError(utils.ErrorForbiddenResource)
+	}
+	if collaborator.Status != repositories.CollaboratorStatusPending {
+		return r.badRequestError(fmt.Errorf("invitation is already %s", collaborator.Status))
+	}
+	collaborator.Status = status
+	if err := r.Repository.Collaborator.UpdateStatus(collaborator); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Collaborator]{
+		OK:   true,
+		Data: *collaborator,
+	})
+}
+
+func (r Router) getBookFromParam(c echo.Context) (*repositories.Book, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	book, err := r.Repository.Book.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, r.notFoundError(err)
+		default:
+			return nil, r.serverError(err)
+		}
+	}
+	return book, nil
+}
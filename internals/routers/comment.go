@@ -0,0 +1,296 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type CreateCommentPayload struct {
+	Content  string `json:"content" validate:"required,max=5000"`
+	ParentID *int64 `json:"parentId" validate:"omitempty,gte=1"`
+}
+
+type UpdateCommentPayload struct {
+	Content string `json:"content" validate:"required,max=5000"`
+}
+
+// top level comments of the chapter, or the replies of a comment with ?parentId=
+func (r Router) FindComments(c echo.Context) error {
+	chapter, err := r.getViewableChapter(c)
+	if err != nil {
+		return err
+	}
+	filter := repositories.Filter{
+		Page:     1,
+		PageSize: 20,
+		SortSafeList: []string{
+			"created_at",
+			"-created_at",
+			"like_count",
+			"-like_count",
+		},
+		Sort: "-created_at",
+	}
+	var parentId int
+	queryParams := c.QueryParams()
+	if queryParams.Has("parentId") {
+		parentId, err = strconv.Atoi(queryParams.Get("parentId"))
+		if err != nil || parentId < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Sort = "created_at" // replies read top to bottom
+	}
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	comments, metadata, err := r.Repository.Comment.Find(chapter.ID, int64(parentId), r.viewerId(c), filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Comment]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     comments,
+	})
+}
+
+// comment on the chapter, or reply to another comment of the chapter with parentId
+func (r Router) CreateComment(c echo.Context) error {
+	chapter, err := r.getViewableChapter(c)
+	if err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	validate := utils.NewValidator()
+	payload := new(CreateCommentPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	comment := repositories.Comment{
+		ChapterID: chapter.ID,
+		UserID:    int64(userId),
+		ParentID:  payload.ParentID,
+		Content:   payload.Content,
+	}
+	if err := r.Repository.Comment.Insert(&comment); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.badRequestError(fmt.Errorf("can't reply to this comment"))
+		default:
+			return r.serverError(err)
+		}
+	}
+	if comment.ParentID != nil {
+		r.notifyReply(chapter, &comment)
+	}
+	return r.respondWithComment(c, http.StatusCreated, comment.ID)
+}
+
+// only the commenter can edit their comment
+func (r Router) UpdateComment(c echo.Context) error {
+	_, comment, err := r.getCommentFromParams(c)
+	if err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	if comment.UserID != int64(userId) {
+		return r.forbiddenError(utils.ErrorForbiddenResource)
+	}
+	validate := utils.NewValidator()
+	payload := new(UpdateCommentPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	comment.Content = payload.Content
+	if err := r.Repository.Comment.Update(comment); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithComment(c, http.StatusOK, comment.ID)
+}
+
+// soft delete, replies stay visible under a comment without content
+func (r Router) DeleteComment(c echo.Context) error {
+	_, comment, err := r.getCommentFromParams(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorize(c, repositories.PermissionCommentDelete, comment.UserID); err != nil {
+		return err
+	}
+	if err := r.Repository.Comment.Delete(comment.ID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+func (r Router) LikeComment(c echo.Context) error {
+	return r.toggleCommentLike(c, true)
+}
+
+func (r Router) UnlikeComment(c echo.Context) error {
+	return r.toggleCommentLike(c, false)
+}
+
+func (r Router) toggleCommentLike(c echo.Context, like bool) error {
+	_, comment, err := r.getCommentFromParams(c)
+	if err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	if like {
+		err = r.Repository.Comment.Like(comment.ID, int64(userId))
+	} else {
+		err = r.Repository.Comment.Unlike(comment.ID, int64(userId))
+	}
+	if err != nil {
+		return r.serverError(err)
+	}
+	return r.respondWithComment(c, http.StatusOK, comment.ID)
+}
+
+// the people managing the chapter can pin top level comments
+func (r Router) PinComment(c echo.Context) error {
+	return r.setCommentPinned(c, true)
+}
+
+func (r Router) UnpinComment(c echo.Context) error {
+	return r.setCommentPinned(c, false)
+}
+
+func (r Router) setCommentPinned(c echo.Context, pinned bool) error {
+	chapter, comment, err := r.getCommentFromParams(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorizeChapter(c, repositories.PermissionChapterWrite, chapter); err != nil {
+		return err
+	}
+	if comment.ParentID != nil {
+		return r.badRequestError(fmt.Errorf("only top level comments can be pinned"))
+	}
+	if err := r.Repository.Comment.Pin(comment.ID, pinned); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithComment(c, http.StatusOK, comment.ID)
+}
+
+// the chapter from the `bookId` & `chapterNo` params, if the current user can read it
+func (r Router) getViewableChapter(c echo.Context) (*repositories.Chapter, error) {
+	bookId, err := strconv.Atoi(c.Param("bookId"))
+	if err != nil {
+		return nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	chapterNo, err := strconv.Atoi(c.Param("chapterNo"))
+	if err != nil {
+		return nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	chapter, err := r.Repository.Chapter.Get(int64(chapterNo), int64(bookId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, r.notFoundError(err)
+		default:
+			return nil, r.serverError(err)
+		}
+	}
+	if err := r.authorizeChapterView(c, chapter); err != nil {
+		return nil, err
+	}
+	return chapter, nil
+}
+
+// the chapter & its comment from the `commentId` param
+func (r Router) getCommentFromParams(c echo.Context) (*repositories.Chapter, *repositories.Comment, error) {
+	chapter, err := r.getViewableChapter(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	commentId, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		return nil, nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	comment, err := r.Repository.Comment.Get(int64(commentId), r.viewerId(c))
+	if err != nil || comment.ChapterID != chapter.ID {
+		switch {
+		case err == nil, errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, nil, r.notFoundError(utils.ErrorRecordsNotFound)
+		default:
+			return nil, nil, r.serverError(err)
+		}
+	}
+	return chapter, comment, nil
+}
+
+func (r Router) respondWithComment(c echo.Context, status int, commentId int64) error {
+	comment, err := r.Repository.Comment.Get(commentId, r.viewerId(c))
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(status, Response[repositories.Comment]{
+		OK:   true,
+		Data: *comment,
+	})
+}
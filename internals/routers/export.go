@@ -0,0 +1,96 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+)
+
+// bump to regenerate the cached exports after changing how they are made
+const exportRevision = "2"
+
+// EPUB 3 of the published chapters of the book, ordered by chapter_no
+func (r Router) ExportEpub(c echo.Context) error {
+	return r.sendExport(c, "epub", "application/epub+zip", func(w io.Writer, export *repositories.BookExport) error {
+		return services.NewEpubService().Write(w, export.Epub())
+	})
+}
+
+// send the export of the book in the format as a download. Exports are cached on disk
+// until anything they are made of changes, clients can revalidate with If-None-Match
+func (r Router) sendExport(c echo.Context, format string, contentType string, generate func(io.Writer, *repositories.BookExport) error) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	book, err := r.Repository.Book.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if err := r.authorizeBookView(c, book); err != nil {
+		return err
+	}
+	fingerprint, err := r.Repository.Export.Fingerprint(book.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
+	cache, err := services.NewFileCacheService(viper.GetString("export.cache_dir"))
+	if err != nil {
+		return r.serverError(err)
+	}
+	name := fmt.Sprintf("book-%d.%s", book.ID, format)
+	version := exportRevision + "-" + fingerprint
+	file, found, err := cache.Open(name, version)
+	if err != nil {
+		return r.serverError(err)
+	}
+	if !found {
+		// a change made after the fingerprint was read only ends up in a file cached under the old
+		// fingerprint, the next download gets the new one
+		export, err := r.Repository.Export.Get(book.ID)
+		if err != nil {
+			return r.serverError(err)
+		}
+		file, err = cache.Put(name, version, func(w io.Writer) error {
+			return generate(w, export)
+		})
+		if err != nil {
+			return r.serverError(err)
+		}
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return r.serverError(err)
+	}
+	filename := downloadFilename(book, format)
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().Header().Set("ETag", fmt.Sprintf(`"%s-%s"`, format, version))
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	// same as c.Attachment, from the open file
+	http.ServeContent(c.Response(), c.Request(), filename, info.ModTime(), file)
+	return nil
+}
+
+// name of the downloads of the book, after its title
+func downloadFilename(book *repositories.Book, extension string) string {
+	filename := utils.Slugify(book.Title)
+	if filename == "" {
+		filename = fmt.Sprintf("book-%d", book.ID)
+	}
+	return filename + "." + extension
+}
@@ -0,0 +1,237 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (r Router) FollowUser(c echo.Context) error {
+	followerId, followeeId, err := r.getFollowParams(c)
+	if err != nil {
+		return err
+	}
+	if followerId == followeeId {
+		return r.badRequestError(fmt.Errorf("you can't follow yourself"))
+	}
+	followed, err := r.Repository.Follow.Follow(followerId, followeeId)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if followed {
+		follower, err := r.Repository.User.Get(followerId)
+		if err != nil {
+			return r.serverError(err)
+		}
+		r.notify(repositories.Notification{
+			Type:    repositories.NotificationTypeNewFollower,
+			ActorID: &follower.ID,
+			Message: fmt.Sprintf("%s started following you", follower.Username),
+		}, []int64{followeeId})
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+func (r Router) UnfollowUser(c echo.Context) error {
+	followerId, followeeId, err := r.getFollowParams(c)
+	if err != nil {
+		return err
+	}
+	if err := r.Repository.Follow.Unfollow(followerId, followeeId); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+func (r Router) FindFollowers(c echo.Context) error {
+	return r.findFollows(c, r.Repository.Follow.FindFollowers)
+}
+
+func (r Router) FindFollowing(c echo.Context) error {
+	return r.findFollows(c, r.Repository.Follow.FindFollowing)
+}
+
+// follower & following lists are public, most recent follows first
+func (r Router) findFollows(c echo.Context, find func(int64, repositories.Filter) ([]*repositories.FollowUser, repositories.Metadata, error)) error {
+	userId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	if _, err := r.Repository.User.Get(int64(userId)); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	filter := repositories.Filter{
+		Page:     1,
+		PageSize: 20,
+		SortSafeList: []string{
+			"followed_at",
+			"-followed_at",
+			"username",
+			"-username",
+		},
+		Sort: "-followed_at",
+	}
+	queryParams := c.QueryParams()
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	users, metadata, err := find(int64(userId), filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.FollowUser]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     users,
+	})
+}
+
+// subscribe to the new chapters of a book anyone can open
+func (r Router) SubscribeBook(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	book, err := r.getBookFromParam(c)
+	if err != nil {
+		return err
+	}
+	if !book.Visible() {
+		return r.notFoundError(utils.ErrorRecordsNotFound)
+	}
+	if err := r.Repository.Subscription.Subscribe(int64(userId), book.ID); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+func (r Router) UnsubscribeBook(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	bookId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	if err := r.Repository.Subscription.Unsubscribe(int64(userId), int64(bookId)); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// books the current user is subscribed to
+func (r Router) FindSubscriptions(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	filter := repositories.Filter{
+		Page:     1,
+		PageSize: 10,
+		SortSafeList: []string{
+			"subscribed_at",
+			"-subscribed_at",
+			"title",
+			"-title",
+			"published_at",
+			"-published_at",
+			"updated_at",
+			"-updated_at",
+		},
+		Sort: "-subscribed_at",
+	}
+	queryParams := c.QueryParams()
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	books, metadata, err := r.Repository.Subscription.FindBooks(int64(userId), filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Book]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     books,
+	})
+}
+
+// the current user & the user from the `userId` param
+func (r Router) getFollowParams(c echo.Context) (int64, int64, error) {
+	followerId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return 0, 0, r.unauthorizedError(err)
+	}
+	followeeId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		return 0, 0, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	return int64(followerId), int64(followeeId), nil
+}
@@ -0,0 +1,154 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ManuscriptChapterDump struct {
+	*repositories.Chapter
+	// oldest first, empty for chapters without content
+	Versions []*repositories.ChapterVersion `json:"versions"`
+}
+
+// everything the authors wrote in the book, see ExportManuscriptJSON
+type ManuscriptDump struct {
+	Book       *repositories.Book      `json:"book"`
+	Chapters   []ManuscriptChapterDump `json:"chapters"`
+	ExportedAt time.Time               `json:"exportedAt"`
+}
+
+// the whole book, drafts included, in a single Markdown file
+func (r Router) ExportManuscriptMarkdown(c echo.Context) error {
+	book, chapters, err := r.getManuscript(c)
+	if err != nil {
+		return err
+	}
+	r.startDownload(c, "text/markdown; charset=UTF-8", downloadFilename(book, "md"))
+	return services.NewManuscriptService().WriteMarkdown(c.Response(), manuscriptOf(book, chapters))
+}
+
+// a ZIP of 1 file per chapter, Markdown by default or plain text with ?format=txt
+func (r Router) ExportManuscriptZip(c echo.Context) error {
+	format := services.ManuscriptFormatMarkdown
+	if c.QueryParams().Has("format") {
+		format = c.QueryParam("format")
+		if !utils.IsItemInCollection(format, services.ManuscriptFormats) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	book, chapters, err := r.getManuscript(c)
+	if err != nil {
+		return err
+	}
+	r.startDownload(c, "application/zip", downloadFilename(book, "zip"))
+	return services.NewManuscriptService().WriteZip(c.Response(), manuscriptOf(book, chapters), format)
+}
+
+// the book & every chapter with its metadata, content & version history
+func (r Router) ExportManuscriptJSON(c echo.Context) error {
+	book, chapters, err := r.getManuscript(c)
+	if err != nil {
+		return err
+	}
+	dump := ManuscriptDump{
+		Book:       book,
+		Chapters:   make([]ManuscriptChapterDump, 0, len(chapters)),
+		ExportedAt: time.Now().UTC(),
+	}
+	for _, chapter := range chapters {
+		versions := []*repositories.ChapterVersion{}
+		if chapter.Content != nil {
+			versions, err = r.Repository.Version.History(chapter.Content.ID)
+			if err != nil {
+				return r.serverError(err)
+			}
+		}
+		dump.Chapters = append(dump.Chapters, ManuscriptChapterDump{Chapter: chapter, Versions: versions})
+	}
+	r.startDownload(c, echo.MIMEApplicationJSONCharsetUTF8, downloadFilename(book, "json"))
+	encoder := json.NewEncoder(c.Response())
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dump)
+}
+
+// the book of the route & all its chapters ordered by chapter_no, with their content when they have one.
+// only the users allowed to write the chapters can export them
+func (r Router) getManuscript(c echo.Context) (*repositories.Book, []*repositories.Chapter, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	book, err := r.Repository.Book.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, nil, r.notFoundError(err)
+		default:
+			return nil, nil, r.serverError(err)
+		}
+	}
+	if err := r.authorizeBook(c, repositories.PermissionChapterWrite, book.ID, book.UserID); err != nil {
+		return nil, nil, err
+	}
+	chapters, _, err := r.Repository.Chapter.Find(book.ID, "", nil, repositories.Filter{
+		Page:         1,
+		PageSize:     math.MaxInt,
+		SortSafeList: []string{"chapter_no"},
+		Sort:         "chapter_no",
+	})
+	if err != nil {
+		return nil, nil, r.serverError(err)
+	}
+	for _, chapter := range chapters {
+		content, err := r.Repository.Content.Get(chapter.ID)
+		if err != nil {
+			if errors.Is(err, utils.ErrorRecordsNotFound) {
+				continue
+			}
+			return nil, nil, r.serverError(err)
+		}
+		content.Chapter = nil // already the parent
+		chapter.Content = content
+	}
+	return book, chapters, nil
+}
+
+// send the headers of a file download, the body is written right after
+func (r Router) startDownload(c echo.Context, contentType string, filename string) {
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().WriteHeader(http.StatusOK)
+}
+
+func manuscriptOf(book *repositories.Book, chapters []*repositories.Chapter) services.Manuscript {
+	manuscript := services.Manuscript{
+		Title:       book.Title,
+		Description: book.Description,
+		Author:      book.Author.Username,
+		Chapters:    make([]services.ManuscriptChapter, 0, len(chapters)),
+	}
+	for _, chapter := range chapters {
+		text, format := "", services.ContentFormatPlain
+		if chapter.Content != nil {
+			text, format = chapter.Content.TextContent, chapter.Content.Format
+		}
+		manuscript.Chapters = append(manuscript.Chapters, services.ManuscriptChapter{
+			ChapterNO: chapter.ChapterNO,
+			Title:     chapter.Title,
+			Text:      text,
+			Format:    format,
+		})
+	}
+	return manuscript
+}
@@ -0,0 +1,396 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// notifications are delivered in the background, off the request that triggered them.
+// past this many deliveries waiting, the request delivers its own
+const notificationQueueSize = 1000
+
+// recipients of a notification handled at once, the subscribers of a popular book go in several batches
+const notificationBatchSize = 500
+
+// mark some notifications as read, every unread notification when ids is empty
+type MarkNotificationsReadPayload struct {
+	IDs []int64 `json:"ids" validate:"max=100,dive,gte=1"`
+}
+
+type NotificationPreferencePayload struct {
+	Type  string `json:"type" validate:"required,oneof=new_chapter comment_reply new_follower collaborator_invitation"`
+	InApp bool   `json:"inApp"`
+	Email bool   `json:"email"`
+}
+
+type UpdateNotificationPreferencesPayload struct {
+	Preferences []NotificationPreferencePayload `json:"preferences" validate:"required,min=1,dive"`
+}
+
+// notifications of the current user, newest first. Only the unread ones with ?unread=true
+func (r Router) FindNotifications(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	filter := repositories.Filter{
+		Page:     1,
+		PageSize: 20,
+		SortSafeList: []string{
+			"created_at",
+			"-created_at",
+		},
+		Sort: "-created_at",
+	}
+	unreadOnly := false
+	queryParams := c.QueryParams()
+	if queryParams.Has("unread") {
+		unreadOnly, err = strconv.ParseBool(queryParams.Get("unread"))
+		if err != nil {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	notifications, metadata, err := r.Repository.Notification.Find(int64(userId), unreadOnly, filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Notification]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     notifications,
+	})
+}
+
+func (r Router) CountUnreadNotifications(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	count, err := r.Repository.Notification.CountUnread(int64(userId))
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[int64]{
+		OK:   true,
+		Data: count,
+	})
+}
+
+func (r Router) MarkNotificationRead(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	notificationId, err := strconv.Atoi(c.Param("notificationId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	notification, err := r.Repository.Notification.Get(int64(notificationId))
+	if err != nil || notification.UserID != int64(userId) {
+		switch {
+		case err == nil, errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(utils.ErrorRecordsNotFound)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if _, err := r.Repository.Notification.MarkRead(int64(userId), []int64{notification.ID}); err != nil {
+		return r.serverError(err)
+	}
+	notification, err = r.Repository.Notification.Get(notification.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Notification]{
+		OK:   true,
+		Data: *notification,
+	})
+}
+
+// bulk version of MarkNotificationRead, answers with the number of notifications marked
+func (r Router) MarkNotificationsRead(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	validate := utils.NewValidator()
+	payload := new(MarkNotificationsReadPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	count, err := r.Repository.Notification.MarkRead(int64(userId), payload.IDs)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[int64]{
+		OK:   true,
+		Data: count,
+	})
+}
+
+// preference of every notification type, the default one for the types the user never set
+func (r Router) GetNotificationPreferences(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	return r.respondWithNotificationPreferences(c, int64(userId))
+}
+
+// only the types in the payload are changed
+func (r Router) UpdateNotificationPreferences(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	validate := utils.NewValidator()
+	payload := new(UpdateNotificationPreferencesPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	for _, preference := range payload.Preferences {
+		err := r.Repository.Notification.SetPreference(int64(userId), repositories.NotificationPreference{
+			Type:  preference.Type,
+			InApp: preference.InApp,
+			Email: preference.Email,
+		})
+		if err != nil {
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithNotificationPreferences(c, int64(userId))
+}
+
+func (r Router) respondWithNotificationPreferences(c echo.Context, userId int64) error {
+	preferences, err := r.Repository.Notification.GetPreferences(userId)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]repositories.NotificationPreference]{
+		OK:   true,
+		Data: preferences,
+	})
+}
+
+// deliver the notification to every user of userIds in the background, see deliverNotification
+func (r Router) notify(notification repositories.Notification, userIds []int64) {
+	r.queueNotification(func() {
+		r.deliverNotification(notification, userIds)
+	})
+}
+
+func (r Router) queueNotification(deliver func()) {
+	select {
+	case r.notifications <- deliver:
+	default:
+		deliver()
+	}
+}
+
+// run the queued deliveries one after the other until ctx is done, then the ones still queued.
+// ctx must only be done once no request can queue more, the deliveries would be lost otherwise
+func (r Router) DeliverNotifications(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			r.drainNotifications()
+			return
+		case deliver := <-r.notifications:
+			deliver()
+		}
+	}
+}
+
+func (r Router) drainNotifications() {
+	for {
+		select {
+		case deliver := <-r.notifications:
+			deliver()
+		default:
+			return
+		}
+	}
+}
+
+// deliver the notification to every user of userIds, in-app and/or by mail depending on their preferences.
+// the actor is never notified of their own action. Failures are logged, they must not fail the action
+// that triggered the notification
+func (r Router) deliverNotification(notification repositories.Notification, userIds []int64) {
+	if notification.ActorID != nil {
+		recipients := make([]int64, 0, len(userIds))
+		for _, userId := range userIds {
+			if userId != *notification.ActorID {
+				recipients = append(recipients, userId)
+			}
+		}
+		userIds = recipients
+	}
+	for len(userIds) > 0 {
+		batch := userIds[:min(len(userIds), notificationBatchSize)]
+		userIds = userIds[len(batch):]
+		r.deliverNotificationBatch(notification, batch)
+	}
+}
+
+func (r Router) deliverNotificationBatch(notification repositories.Notification, userIds []int64) {
+	recipients, err := r.Repository.Notification.FindRecipients(notification.Type, userIds)
+	if err != nil {
+		r.LoggerService.LogError(err, "failed to find the recipients of a notification")
+		return
+	}
+	inApp := []int64{}
+	for _, recipient := range recipients {
+		if recipient.Preference.InApp {
+			inApp = append(inApp, recipient.UserID)
+		}
+	}
+	if len(inApp) > 0 {
+		notifications, err := r.Repository.Notification.Insert(&notification, inApp)
+		if err != nil {
+			r.LoggerService.LogError(err, "failed to record a notification")
+		} else {
+			r.publishNotifications(notifications)
+		}
+	}
+
+	var data *services.MailData
+	mails := []*services.Mail{}
+	for _, recipient := range recipients {
+		if !recipient.Preference.Email {
+			continue
+		}
+		if data == nil {
+			data = r.notificationMailData(&notification)
+		}
+		data.Username = recipient.Username
+		mail, err := r.MailTemplateService.Render(services.NewNotificationService().Template(notification.Type), recipient.Locale, recipient.Email, *data)
+		if err != nil {
+			// the other recipients still get theirs
+			r.LoggerService.LogError(err, "failed to render the mail of a notification")
+			continue
+		}
+		mails = append(mails, mail)
+	}
+	if len(mails) > 0 {
+		if err := r.Repository.Outbox.Enqueue(mails...); err != nil {
+			r.LoggerService.LogError(err, "failed to queue the mails of a notification")
+		}
+	}
+}
+
+// what the mails of the notification have in common, whatever can't be found is left out
+func (r Router) notificationMailData(notification *repositories.Notification) *services.MailData {
+	notificationService := services.NewNotificationService()
+	data := &services.MailData{
+		Type:    notification.Type,
+		Message: notification.Message,
+		Link: r.MailTemplateService.Link(notificationService.Path(services.NotificationMail{
+			Type:           notification.Type,
+			BookID:         utils.ValueOf(notification.BookID),
+			ChapterID:      utils.ValueOf(notification.ChapterID),
+			CommentID:      utils.ValueOf(notification.CommentID),
+			ActorID:        utils.ValueOf(notification.ActorID),
+			CollaboratorID: utils.ValueOf(notification.CollaboratorID),
+		}), nil),
+	}
+	if notification.ActorID != nil {
+		if actor, err := r.Repository.User.Get(*notification.ActorID); err == nil {
+			data.Actor = actor.Username
+		}
+	}
+	if notification.BookID != nil {
+		if book, err := r.Repository.Book.Get(*notification.BookID); err == nil {
+			data.BookTitle = book.Title
+		}
+	}
+	return data
+}
+
+// tell the subscribers of the book about a chapter that just got published
+func (r Router) notifyNewChapter(book *repositories.Book, chapter *repositories.Chapter) {
+	if book == nil || !book.Visible() {
+		return
+	}
+	r.publishChapter(book, chapter)
+	bookId, chapterId, authorId := book.ID, chapter.ID, chapter.AuthorID
+	notification := repositories.Notification{
+		Type:      repositories.NotificationTypeNewChapter,
+		ActorID:   &authorId,
+		BookID:    &bookId,
+		ChapterID: &chapterId,
+		Message:   fmt.Sprintf("New chapter \"%s\" in \"%s\"", chapter.Title, book.Title),
+	}
+	// a book can have a lot of subscribers, they are found in the background as well
+	r.queueNotification(func() {
+		subscriberIds, err := r.Repository.Subscription.FindSubscriberIDs(bookId)
+		if err != nil {
+			r.LoggerService.LogError(err, "failed to find the subscribers of a book")
+			return
+		}
+		r.deliverNotification(notification, subscriberIds)
+	})
+}
+
+// tell the author of the parent comment about the reply
+func (r Router) notifyReply(chapter *repositories.Chapter, reply *repositories.Comment) {
+	parent, err := r.Repository.Comment.Get(*reply.ParentID, 0)
+	if err != nil {
+		r.LoggerService.LogError(err, "failed to get the parent of a reply")
+		return
+	}
+	replier, err := r.Repository.User.Get(reply.UserID)
+	if err != nil {
+		r.LoggerService.LogError(err, "failed to get the author of a reply")
+		return
+	}
+	r.notify(repositories.Notification{
+		Type:      repositories.NotificationTypeCommentReply,
+		ActorID:   &reply.UserID,
+		BookID:    &chapter.BookID,
+		ChapterID: &chapter.ID,
+		CommentID: &reply.ID,
+		Message:   fmt.Sprintf("%s replied to your comment on \"%s\"", replier.Username, chapter.Title),
+	}, []int64{parent.UserID})
+}
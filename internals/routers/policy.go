@@ -0,0 +1,127 @@
+package router
+
+import (
+	"errors"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// check the current user can perform `permission` on a resource owned by ownerId.
+// granted when the user has the `:any` variant of the permission,
+// or has the permission itself & owns the resource.
+// the returned error is ready to be returned from a handler
+func (r Router) authorize(c echo.Context, permission string, ownerId int64) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	allowed, err := r.can(int64(userId), permission, ownerId)
+	if err != nil {
+		return r.serverError(err)
+	}
+	if !allowed {
+		return r.forbiddenError(utils.ErrorForbiddenResource)
+	}
+	return nil
+}
+
+// same as authorize but for resources belonging to a book:
+// accepted collaborators of the book get the permissions of their collaborator role
+func (r Router) authorizeBook(c echo.Context, permission string, bookId int64, ownerIds ...int64) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	allowed, err := r.canOnBook(int64(userId), permission, bookId, ownerIds...)
+	if err != nil {
+		return r.serverError(err)
+	}
+	if !allowed {
+		return r.forbiddenError(utils.ErrorForbiddenResource)
+	}
+	return nil
+}
+
+// chapters can be managed by their author as well as by the owner of the book
+func (r Router) authorizeChapter(c echo.Context, permission string, chapter *repositories.Chapter) error {
+	ownerIds := []int64{chapter.AuthorID}
+	if chapter.Book != nil && chapter.Book.UserID > 0 {
+		ownerIds = append(ownerIds, chapter.Book.UserID)
+	}
+	return r.authorizeBook(c, permission, chapter.BookID, ownerIds...)
+}
+
+// anything that isn't a draft can be read by anyone, even without signing in.
+// drafts are limited to the people allowed to read the book
+func (r Router) authorizeBookView(c echo.Context, book *repositories.Book) error {
+	if book.Visible() {
+		return nil
+	}
+	return r.authorizeBook(c, repositories.PermissionBookRead, book.ID, book.UserID)
+}
+
+// a chapter is public when both the chapter and its book aren't drafts
+func (r Router) authorizeChapterView(c echo.Context, chapter *repositories.Chapter) error {
+	if chapter.Visible() {
+		return nil
+	}
+	return r.authorizeChapter(c, repositories.PermissionBookRead, chapter)
+}
+
+// whether the current user, if any, can see the drafts & unlisted content of the book
+func (r Router) canReadUnpublished(c echo.Context, book *repositories.Book) (bool, error) {
+	userId := r.viewerId(c)
+	if userId == 0 {
+		return false, nil
+	}
+	return r.canOnBook(userId, repositories.PermissionBookRead, book.ID, book.UserID)
+}
+
+// id of the current user on routes where authentication is optional, 0 when anonymous
+func (r Router) viewerId(c echo.Context) int64 {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return 0
+	}
+	return int64(userId)
+}
+
+func (r Router) can(userId int64, permission string, ownerIds ...int64) (bool, error) {
+	permissions, err := r.Repository.Role.GetUserPermissions(userId)
+	if err != nil {
+		return false, err
+	}
+	if utils.IsItemInCollection(permission+repositories.PermissionScopeAny, permissions) {
+		return true, nil
+	}
+	return utils.IsItemInCollection(userId, ownerIds) && utils.IsItemInCollection(permission, permissions), nil
+}
+
+func (r Router) canOnBook(userId int64, permission string, bookId int64, ownerIds ...int64) (bool, error) {
+	allowed, err := r.can(userId, permission, ownerIds...)
+	if err != nil || allowed {
+		return allowed, err
+	}
+	role, err := r.Repository.Collaborator.GetRole(bookId, userId)
+	if err != nil {
+		if errors.Is(err, utils.ErrorRecordsNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return utils.IsItemInCollection(permission, repositories.CollaboratorPermissions[role]), nil
+}
+
+// the owner of the book & the users allowed to manage the collaborators of any book
+func (r Router) canManageCollaborators(userId int64, book *repositories.Book) (bool, error) {
+	if userId == book.UserID {
+		return true, nil
+	}
+	permissions, err := r.Repository.Role.GetUserPermissions(userId)
+	if err != nil {
+		return false, err
+	}
+	return utils.IsItemInCollection(repositories.PermissionCollaboratorManage, permissions), nil
+}
@@ -0,0 +1,400 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+// updatedAt is when the progress was recorded on the device, defaults to now.
+// an older progress than the stored one is ignored & the stored one is returned instead
+type SaveReadingProgressPayload struct {
+	ChapterID  int64      `json:"chapterId" validate:"required,gte=1"`
+	Offset     int        `json:"offset" validate:"gte=0"`
+	Percentage float64    `json:"percentage" validate:"gte=0,lte=100"`
+	UpdatedAt  *time.Time `json:"updatedAt"`
+}
+
+type CreateBookmarkPayload struct {
+	ChapterID int64      `json:"chapterId" validate:"required,gte=1"`
+	Name      string     `json:"name" validate:"required,max=100"`
+	Position  int        `json:"position" validate:"gte=0"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+}
+
+type UpdateBookmarkPayload struct {
+	ChapterID int64      `json:"chapterId" validate:"omitempty,gte=1"`
+	Name      *string    `json:"name" validate:"omitempty,min=1,max=100"`
+	Position  *int       `json:"position" validate:"omitempty,gte=0"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+}
+
+// the books the current user started & hasn't finished, most recently read first
+func (r Router) ContinueReading(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	filter := repositories.Filter{
+		Page:     1,
+		PageSize: 10,
+		SortSafeList: []string{
+			"updated_at",
+			"-updated_at",
+		},
+		Sort: "-updated_at",
+	}
+	queryParams := c.QueryParams()
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	progresses, metadata, err := r.Repository.Progress.FindInProgress(int64(userId), filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.ReadingProgress]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     progresses,
+	})
+}
+
+func (r Router) GetReadingProgress(c echo.Context) error {
+	book, userId, err := r.getReadableBook(c)
+	if err != nil {
+		return err
+	}
+	progress, err := r.Repository.Progress.Get(userId, book.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[repositories.ReadingProgress]{
+		OK:   true,
+		Data: *progress,
+	})
+}
+
+// always answers with the progress that ends up stored, so devices can catch up with each other
+func (r Router) SaveReadingProgress(c echo.Context) error {
+	book, userId, err := r.getReadableBook(c)
+	if err != nil {
+		return err
+	}
+	validate := utils.NewValidator()
+	payload := new(SaveReadingProgressPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	if _, err := r.getReadableChapterOfBook(c, book, payload.ChapterID, payload.Offset); err != nil {
+		return err
+	}
+	progress := repositories.ReadingProgress{
+		UserID:     userId,
+		BookID:     book.ID,
+		ChapterID:  &payload.ChapterID,
+		Offset:     payload.Offset,
+		Percentage: payload.Percentage,
+		UpdatedAt:  syncTimestamp(payload.UpdatedAt),
+	}
+	if _, err := r.Repository.Progress.Save(&progress); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.ReadingProgress]{
+		OK:   true,
+		Data: progress,
+	})
+}
+
+func (r Router) DeleteReadingProgress(c echo.Context) error {
+	book, userId, err := r.getReadableBook(c)
+	if err != nil {
+		return err
+	}
+	if err := r.Repository.Progress.Delete(userId, book.ID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// bookmarks of the current user in the book, in reading order by default. ?chapterId= limits them to 1 chapter
+func (r Router) FindBookmarks(c echo.Context) error {
+	book, userId, err := r.getReadableBook(c)
+	if err != nil {
+		return err
+	}
+	filter := repositories.Filter{
+		Page:     1,
+		PageSize: 50,
+		SortSafeList: []string{
+			"position",
+			"-position",
+			"name",
+			"-name",
+			"created_at",
+			"-created_at",
+			"updated_at",
+			"-updated_at",
+		},
+		Sort: "position",
+	}
+	var chapterId int
+	queryParams := c.QueryParams()
+	if queryParams.Has("chapterId") {
+		chapterId, err = strconv.Atoi(queryParams.Get("chapterId"))
+		if err != nil || chapterId < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	bookmarks, metadata, err := r.Repository.Bookmark.Find(userId, book.ID, int64(chapterId), filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Bookmark]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     bookmarks,
+	})
+}
+
+func (r Router) CreateBookmark(c echo.Context) error {
+	book, userId, err := r.getReadableBook(c)
+	if err != nil {
+		return err
+	}
+	validate := utils.NewValidator()
+	payload := new(CreateBookmarkPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	if _, err := r.getReadableChapterOfBook(c, book, payload.ChapterID, payload.Position); err != nil {
+		return err
+	}
+	bookmark := repositories.Bookmark{
+		UserID:    userId,
+		BookID:    book.ID,
+		ChapterID: payload.ChapterID,
+		Name:      payload.Name,
+		Position:  payload.Position,
+		UpdatedAt: syncTimestamp(payload.UpdatedAt),
+	}
+	if err := r.Repository.Bookmark.Insert(&bookmark); err != nil {
+		return r.serverError(err)
+	}
+	stored, err := r.Repository.Bookmark.Get(bookmark.ID)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusCreated, Response[repositories.Bookmark]{
+		OK:   true,
+		Data: *stored,
+	})
+}
+
+// rename or move a bookmark, an edit older than the stored one is ignored
+func (r Router) UpdateBookmark(c echo.Context) error {
+	book, bookmark, err := r.getBookmarkFromParams(c)
+	if err != nil {
+		return err
+	}
+	validate := utils.NewValidator()
+	payload := new(UpdateBookmarkPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	if payload.ChapterID != 0 {
+		bookmark.ChapterID = payload.ChapterID
+	}
+	if payload.Name != nil {
+		bookmark.Name = *payload.Name
+	}
+	if payload.Position != nil {
+		bookmark.Position = *payload.Position
+	}
+	if _, err := r.getReadableChapterOfBook(c, book, bookmark.ChapterID, bookmark.Position); err != nil {
+		return err
+	}
+	bookmark.UpdatedAt = syncTimestamp(payload.UpdatedAt)
+	if _, err := r.Repository.Bookmark.Update(bookmark); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Bookmark]{
+		OK:   true,
+		Data: *bookmark,
+	})
+}
+
+func (r Router) DeleteBookmark(c echo.Context) error {
+	_, bookmark, err := r.getBookmarkFromParams(c)
+	if err != nil {
+		return err
+	}
+	if err := r.Repository.Bookmark.Delete(bookmark.ID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// the book from the `id` param if the current user can read it, along with the current user id
+func (r Router) getReadableBook(c echo.Context) (*repositories.Book, int64, error) {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return nil, 0, r.unauthorizedError(err)
+	}
+	book, err := r.getBookFromParam(c)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := r.authorizeBookView(c, book); err != nil {
+		return nil, 0, err
+	}
+	return book, int64(userId), nil
+}
+
+// a chapter of the book the current user can read, with position inside its content
+func (r Router) getReadableChapterOfBook(c echo.Context, book *repositories.Book, chapterId int64, position int) (*repositories.Chapter, error) {
+	chapter, err := r.Repository.Chapter.GetByID(chapterId)
+	if err != nil || chapter.BookID != book.ID {
+		switch {
+		case err == nil, errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, r.badRequestError(fmt.Errorf("chapter not found in this book"))
+		default:
+			return nil, r.serverError(err)
+		}
+	}
+	if err := r.authorizeChapterView(c, chapter); err != nil {
+		return nil, err
+	}
+	length := 0
+	content, err := r.Repository.Content.Get(chapter.ID)
+	switch {
+	case err == nil:
+		length = utf8.RuneCountInString(content.TextContent)
+	case !errors.Is(err, utils.ErrorRecordsNotFound):
+		return nil, r.serverError(err)
+	}
+	if position > length {
+		return nil, r.badRequestError(fmt.Errorf("position is past the end of the chapter"))
+	}
+	return chapter, nil
+}
+
+// the book & the bookmark from the `bookmarkId` param, bookmarks are private to their owner
+func (r Router) getBookmarkFromParams(c echo.Context) (*repositories.Book, *repositories.Bookmark, error) {
+	book, userId, err := r.getReadableBook(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	bookmarkId, err := strconv.Atoi(c.Param("bookmarkId"))
+	if err != nil {
+		return nil, nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	bookmark, err := r.Repository.Bookmark.Get(int64(bookmarkId))
+	if err != nil || bookmark.BookID != book.ID || bookmark.UserID != userId {
+		switch {
+		case err == nil, errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, nil, r.notFoundError(utils.ErrorRecordsNotFound)
+		default:
+			return nil, nil, r.serverError(err)
+		}
+	}
+	return book, bookmark, nil
+}
+
+// the time a change was made on the reader's device, a clock ahead of the server is brought back to now
+// so it can't shadow every later change
+func syncTimestamp(t *time.Time) time.Time {
+	now := time.Now().UTC()
+	if t == nil || t.After(now) {
+		return now
+	}
+	return t.UTC()
+}
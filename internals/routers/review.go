@@ -0,0 +1,279 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type CreateReviewPayload struct {
+	Rating  int    `json:"rating" validate:"required,min=1,max=5"`
+	Content string `json:"content" validate:"max=10000"`
+}
+
+type UpdateReviewPayload struct {
+	Rating  int     `json:"rating" validate:"omitempty,min=1,max=5"`
+	Content *string `json:"content" validate:"omitempty,max=10000"`
+}
+
+type ReplyReviewPayload struct {
+	Content string `json:"content" validate:"required,max=10000"`
+}
+
+// reviews of a visible book, filter by stars with ?rating=
+func (r Router) FindReviews(c echo.Context) error {
+	book, err := r.getBookFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorizeBookView(c, book); err != nil {
+		return err
+	}
+	filter := repositories.Filter{
+		Page:     1,
+		PageSize: 10,
+		SortSafeList: []string{
+			"created_at",
+			"-created_at",
+			"updated_at",
+			"-updated_at",
+			"rating",
+			"-rating",
+		},
+		Sort: "-created_at",
+	}
+	var rating int
+	queryParams := c.QueryParams()
+	if queryParams.Has("rating") {
+		rating, err = strconv.Atoi(queryParams.Get("rating"))
+		if err != nil || rating < 1 || rating > 5 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	reviews, metadata, err := r.Repository.Review.Find(book.ID, rating, filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Review]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     reviews,
+	})
+}
+
+// rate & review a book, once per user. Authors can't review their own books
+func (r Router) CreateReview(c echo.Context) error {
+	book, err := r.getBookFromParam(c)
+	if err != nil {
+		return err
+	}
+	if !book.Visible() {
+		return r.notFoundError(utils.ErrorRecordsNotFound)
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	if book.UserID == int64(userId) {
+		return r.forbiddenError(fmt.Errorf("authors can't review their own books"))
+	}
+	validate := utils.NewValidator()
+	payload := new(CreateReviewPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	review := repositories.Review{
+		BookID:  book.ID,
+		UserID:  int64(userId),
+		Rating:  payload.Rating,
+		Content: payload.Content,
+	}
+	if err := r.Repository.Review.Insert(&review); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordExisted):
+			return r.conflictError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithReview(c, http.StatusCreated, review.ID)
+}
+
+// only the reviewer can edit their review
+func (r Router) UpdateReview(c echo.Context) error {
+	_, review, err := r.getReviewFromParam(c)
+	if err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	if review.UserID != int64(userId) {
+		return r.forbiddenError(utils.ErrorForbiddenResource)
+	}
+	validate := utils.NewValidator()
+	payload := new(UpdateReviewPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	if payload.Rating != 0 {
+		review.Rating = payload.Rating
+	}
+	if payload.Content != nil {
+		review.Content = *payload.Content
+	}
+	if err := r.Repository.Review.Update(review); err != nil {
+		return r.serverError(err)
+	}
+	return r.respondWithReview(c, http.StatusOK, review.ID)
+}
+
+func (r Router) DeleteReview(c echo.Context) error {
+	_, review, err := r.getReviewFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorize(c, repositories.PermissionReviewDelete, review.UserID); err != nil {
+		return err
+	}
+	if err := r.Repository.Review.Delete(review); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// the author of the book answers a review, replying again replaces the previous reply
+func (r Router) ReplyToReview(c echo.Context) error {
+	book, review, err := r.getReviewFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorize(c, repositories.PermissionBookUpdate, book.UserID); err != nil {
+		return err
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	validate := utils.NewValidator()
+	payload := new(ReplyReviewPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	repliedBy := int64(userId)
+	review.Reply = &payload.Content
+	review.RepliedBy = &repliedBy
+	if err := r.Repository.Review.Reply(review); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Review]{
+		OK:   true,
+		Data: *review,
+	})
+}
+
+func (r Router) DeleteReviewReply(c echo.Context) error {
+	book, review, err := r.getReviewFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorize(c, repositories.PermissionBookUpdate, book.UserID); err != nil {
+		return err
+	}
+	review.Reply = nil
+	review.RepliedBy = nil
+	if err := r.Repository.Review.Reply(review); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Review]{
+		OK:   true,
+		Data: *review,
+	})
+}
+
+// the book from the `id` param & its review from the `reviewId` param
+func (r Router) getReviewFromParam(c echo.Context) (*repositories.Book, *repositories.Review, error) {
+	book, err := r.getBookFromParam(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	reviewId, err := strconv.Atoi(c.Param("reviewId"))
+	if err != nil {
+		return nil, nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	review, err := r.Repository.Review.Get(int64(reviewId))
+	if err != nil || review.BookID != book.ID {
+		switch {
+		case err == nil, errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, nil, r.notFoundError(utils.ErrorRecordsNotFound)
+		default:
+			return nil, nil, r.serverError(err)
+		}
+	}
+	return book, review, nil
+}
+
+func (r Router) respondWithReview(c echo.Context, status int, reviewId int64) error {
+	review, err := r.Repository.Review.Get(reviewId)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(status, Response[repositories.Review]{
+		OK:   true,
+		Data: *review,
+	})
+}
@@ -14,18 +14,27 @@ import (
 )
 
 type Router struct {
-	Repository    *repositories.Repository
-	MailerService *services.MailerService
-	JwtService    *services.JWTService
-	LoggerService *services.LoggerService
+	Repository          *repositories.Repository
+	MailerService       services.Mailer
+	MailTemplateService *services.MailTemplateService
+	JwtService          *services.JWTService
+	LoggerService       *services.LoggerService
+	SessionCache        *services.CacheService[string, bool]
+	StreamService       *services.StreamService
+	// deliveries waiting for DeliverNotifications
+	notifications chan func()
 }
 
-func New(repository *repositories.Repository, mailerService *services.MailerService, loggerService *services.LoggerService) Router {
+func New(repository *repositories.Repository, mailerService services.Mailer, mailTemplateService *services.MailTemplateService, loggerService *services.LoggerService, sessionCache *services.CacheService[string, bool], streamService *services.StreamService) Router {
 	return Router{
-		Repository:    repository,
-		MailerService: mailerService,
-		LoggerService: loggerService,
-		JwtService:    &services.JWTService{}, // recreate each router creation since it does not initiate any object instance
+		Repository:          repository,
+		MailerService:       mailerService,
+		MailTemplateService: mailTemplateService,
+		LoggerService:       loggerService,
+		SessionCache:        sessionCache,
+		StreamService:       streamService,
+		notifications:       make(chan func(), notificationQueueSize),
+		JwtService:          &services.JWTService{}, // recreate each router creation since it does not initiate any object instance
 	}
 }
 
@@ -68,7 +77,7 @@ func (r Router) SendTestMail(c echo.Context) error {
 		return r.badRequestError(err)
 	}
 	mail := services.Mail{
-		From:    "no-reply@novelism.com",
+		From:    r.MailTemplateService.Sender,
 		To:      mailerInfo.To,
 		Subject: mailerInfo.Title,
 		Content: mailerInfo.Text,
@@ -126,3 +135,7 @@ func (r Router) forbiddenError(err error) error {
 func (r Router) unauthorizedError(err error) error {
 	return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 }
+
+func (r Router) conflictError(err error) error {
+	return echo.NewHTTPError(http.StatusConflict, err.Error())
+}
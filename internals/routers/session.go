@@ -0,0 +1,105 @@
+package router
+
+import (
+	"errors"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// revoke the session the current access token belongs to
+func (r Router) Logout(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	jti, err := r.JwtService.RetrieveSessionFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	session, err := r.Repository.Session.GetByJTI(jti)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.unauthorizedError(utils.ErrorSessionRevoked)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if _, err := r.Repository.Session.Revoke(int64(userId), session.ID); err != nil && !errors.Is(err, utils.ErrorRecordsNotFound) {
+		return r.serverError(err)
+	}
+	r.SessionCache.Delete(jti)
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// list active sessions of the current user
+func (r Router) FindSessions(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	jti, err := r.JwtService.RetrieveSessionFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	sessions, err := r.Repository.Session.Find(int64(userId))
+	if err != nil {
+		return r.serverError(err)
+	}
+	for _, session := range sessions {
+		session.Current = session.JTI == jti
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Session]{
+		OK:       true,
+		Data:     sessions,
+		Metadata: repositories.CalculateMetadata(len(sessions), len(sessions), 1),
+	})
+}
+
+func (r Router) RevokeSession(c echo.Context) error {
+	sessionId, err := strconv.Atoi(c.Param("sessionId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	session, err := r.Repository.Session.Revoke(int64(userId), int64(sessionId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	r.SessionCache.Delete(session.JTI)
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// sign out everywhere, including the current session
+func (r Router) RevokeAllSessions(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	sessions, err := r.Repository.Session.RevokeAll(int64(userId))
+	if err != nil {
+		return r.serverError(err)
+	}
+	for _, session := range sessions {
+		r.SessionCache.Delete(session.JTI)
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
@@ -0,0 +1,395 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type CreateShelfPayload struct {
+	Name   string `json:"name" validate:"required,max=100"`
+	Public bool   `json:"public"`
+}
+
+// default shelves can't be renamed. position is the index of the shelf among the shelves of the user
+type UpdateShelfPayload struct {
+	Name     *string `json:"name" validate:"omitempty,min=1,max=100"`
+	Public   *bool   `json:"public"`
+	Position *int    `json:"position" validate:"omitempty,gte=0"`
+}
+
+// position is the index of the book on the shelf, the book goes last when omitted
+type ShelfBookPayload struct {
+	Position *int `json:"position" validate:"omitempty,gte=0"`
+}
+
+type MoveShelfBookPayload struct {
+	ShelfID  int64 `json:"shelfId" validate:"required,gte=1"`
+	Position *int  `json:"position" validate:"omitempty,gte=0"`
+}
+
+// shelves of the current user, the default ones are created on the first visit
+func (r Router) FindShelves(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	return r.respondWithShelves(c, int64(userId), false)
+}
+
+// public shelves of a user, or every shelf when looking at your own
+func (r Router) FindUserShelves(c echo.Context) error {
+	userId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	if _, err := r.Repository.User.Get(int64(userId)); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithShelves(c, int64(userId), r.viewerId(c) != int64(userId))
+}
+
+func (r Router) respondWithShelves(c echo.Context, userId int64, publicOnly bool) error {
+	if !publicOnly {
+		if err := r.Repository.Shelf.EnsureDefaults(userId); err != nil {
+			return r.serverError(err)
+		}
+	}
+	shelves, err := r.Repository.Shelf.Find(userId, publicOnly)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Shelf]{
+		OK:   true,
+		Data: shelves,
+	})
+}
+
+func (r Router) CreateShelf(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	validate := utils.NewValidator()
+	payload := new(CreateShelfPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	if err := r.Repository.Shelf.EnsureDefaults(int64(userId)); err != nil {
+		return r.serverError(err)
+	}
+	shelf := repositories.Shelf{
+		UserID: int64(userId),
+		Name:   payload.Name,
+		Public: payload.Public,
+	}
+	if err := r.Repository.Shelf.Insert(&shelf); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordExisted):
+			return r.conflictError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusCreated, Response[repositories.Shelf]{
+		OK:   true,
+		Data: shelf,
+	})
+}
+
+func (r Router) UpdateShelf(c echo.Context) error {
+	shelf, err := r.getOwnShelfFromParam(c)
+	if err != nil {
+		return err
+	}
+	validate := utils.NewValidator()
+	payload := new(UpdateShelfPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	if payload.Name != nil {
+		if shelf.Default() {
+			return r.badRequestError(fmt.Errorf("default shelves can't be renamed"))
+		}
+		shelf.Name = *payload.Name
+	}
+	if payload.Public != nil {
+		shelf.Public = *payload.Public
+	}
+	if err := r.Repository.Shelf.Update(shelf); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		case errors.Is(err, utils.ErrorRecordExisted):
+			return r.conflictError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if payload.Position != nil {
+		if err := r.Repository.Shelf.Reorder(shelf, *payload.Position); err != nil {
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Shelf]{
+		OK:   true,
+		Data: *shelf,
+	})
+}
+
+// the books on a shelf go with it, default shelves can't be deleted
+func (r Router) DeleteShelf(c echo.Context) error {
+	shelf, err := r.getOwnShelfFromParam(c)
+	if err != nil {
+		return err
+	}
+	if shelf.Default() {
+		return r.badRequestError(fmt.Errorf("default shelves can't be deleted"))
+	}
+	if err := r.Repository.Shelf.Delete(shelf.ID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// books of a public shelf, or of one of your own shelves. Sorted in the order of the shelf by default
+func (r Router) FindShelfBooks(c echo.Context) error {
+	shelf, err := r.getShelfFromParam(c)
+	if err != nil {
+		return err
+	}
+	if !shelf.Public && shelf.UserID != r.viewerId(c) {
+		return r.notFoundError(utils.ErrorRecordsNotFound)
+	}
+	filter := repositories.Filter{
+		Page:     1,
+		PageSize: 10,
+		SortSafeList: []string{
+			"position",
+			"-position",
+			"added_at",
+			"-added_at",
+			"title",
+			"-title",
+			"created_at",
+			"-created_at",
+			"published_at",
+			"-published_at",
+			"rating_average",
+			"-rating_average",
+			"rating_count",
+			"-rating_count",
+		},
+		Sort: "position",
+	}
+	queryParams := c.QueryParams()
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	books, metadata, err := r.Repository.Shelf.FindBooks(shelf.ID, filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Book]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     books,
+	})
+}
+
+// put a book on the shelf, or move it within the shelf when it is already there
+func (r Router) AddShelfBook(c echo.Context) error {
+	shelf, book, err := r.getOwnShelfBookFromParams(c)
+	if err != nil {
+		return err
+	}
+	validate := utils.NewValidator()
+	payload := new(ShelfBookPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	if err := r.Repository.Shelf.AddBook(shelf, book.ID, payload.Position); err != nil {
+		return r.serverError(err)
+	}
+	return r.respondWithShelf(c, shelf.ID)
+}
+
+func (r Router) RemoveShelfBook(c echo.Context) error {
+	shelf, err := r.getOwnShelfFromParam(c)
+	if err != nil {
+		return err
+	}
+	bookId, err := strconv.Atoi(c.Param("bookId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	if err := r.Repository.Shelf.RemoveBook(shelf.ID, int64(bookId)); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithShelf(c, shelf.ID)
+}
+
+// take a book off the shelf & put it on another shelf of the current user
+func (r Router) MoveShelfBook(c echo.Context) error {
+	shelf, book, err := r.getOwnShelfBookFromParams(c)
+	if err != nil {
+		return err
+	}
+	validate := utils.NewValidator()
+	payload := new(MoveShelfBookPayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	target, err := r.Repository.Shelf.Get(payload.ShelfID)
+	if err != nil || target.UserID != shelf.UserID {
+		switch {
+		case err == nil, errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.badRequestError(fmt.Errorf("shelf not found"))
+		default:
+			return r.serverError(err)
+		}
+	}
+	if err := r.Repository.Shelf.MoveBook(shelf, target, book.ID, payload.Position); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithShelf(c, target.ID)
+}
+
+// the shelf from the `shelfId` param
+func (r Router) getShelfFromParam(c echo.Context) (*repositories.Shelf, error) {
+	shelfId, err := strconv.Atoi(c.Param("shelfId"))
+	if err != nil {
+		return nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	shelf, err := r.Repository.Shelf.Get(int64(shelfId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, r.notFoundError(err)
+		default:
+			return nil, r.serverError(err)
+		}
+	}
+	return shelf, nil
+}
+
+// same as getShelfFromParam, shelves of other users are not found
+func (r Router) getOwnShelfFromParam(c echo.Context) (*repositories.Shelf, error) {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return nil, r.unauthorizedError(err)
+	}
+	shelf, err := r.getShelfFromParam(c)
+	if err != nil {
+		return nil, err
+	}
+	if shelf.UserID != int64(userId) {
+		return nil, r.notFoundError(utils.ErrorRecordsNotFound)
+	}
+	return shelf, nil
+}
+
+// the shelf of the current user & the book from the `bookId` param, drafts can't be shelved
+func (r Router) getOwnShelfBookFromParams(c echo.Context) (*repositories.Shelf, *repositories.Book, error) {
+	shelf, err := r.getOwnShelfFromParam(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	bookId, err := strconv.Atoi(c.Param("bookId"))
+	if err != nil {
+		return nil, nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	book, err := r.Repository.Book.Get(int64(bookId))
+	if err != nil || !book.Visible() {
+		switch {
+		case err == nil, errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, nil, r.notFoundError(utils.ErrorRecordsNotFound)
+		default:
+			return nil, nil, r.serverError(err)
+		}
+	}
+	return shelf, book, nil
+}
+
+func (r Router) respondWithShelf(c echo.Context, shelfId int64) error {
+	shelf, err := r.Repository.Shelf.Get(shelfId)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Shelf]{
+		OK:   true,
+		Data: *shelf,
+	})
+}
@@ -0,0 +1,324 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+// how many events a stream reads at once
+const streamBatchSize = 100
+
+// what the `events` channel carries, see migration 000025
+type eventNotification struct {
+	ID     int64  `json:"id"`
+	UserID *int64 `json:"userId"`
+	BookID *int64 `json:"bookId"`
+}
+
+type chapterPublishedEvent struct {
+	BookID      int64      `json:"bookId"`
+	BookTitle   string     `json:"bookTitle"`
+	ChapterID   int64      `json:"chapterId"`
+	ChapterNO   int64      `json:"chapterNo"`
+	Title       string     `json:"title"`
+	PublishedAt *time.Time `json:"publishedAt"`
+}
+
+type StreamTicketResponse struct {
+	Ticket    string     `json:"ticket"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// single use ticket to open the stream with, see StreamEvents.
+// EventSource can't set the Authorization header & access tokens must stay out of the URLs, which get logged
+func (r Router) CreateStreamTicket(c echo.Context) error {
+	userId, err := r.JwtService.RetrieveUserIdFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	jti, err := r.JwtService.RetrieveSessionFromContext(c)
+	if err != nil {
+		return r.unauthorizedError(err)
+	}
+	// refreshing the access token changes the jti of the session but not its family
+	session, err := r.Repository.Session.GetByJTI(jti)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.unauthorizedError(utils.ErrorSessionRevoked)
+		default:
+			return r.serverError(err)
+		}
+	}
+	ttl := viper.GetDuration("stream.ticket_ttl")
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	cryptoService := services.NewCryptoService()
+	ticket := cryptoService.GenerateSecureToken(32)
+	expiresAt := time.Now().UTC().Add(ttl)
+	record := repositories.StreamTicket{
+		UserID:    int64(userId),
+		FamilyID:  session.FamilyID,
+		TokenHash: cryptoService.HashToken(ticket),
+		ExpiresAt: &expiresAt,
+	}
+	if err := r.Repository.StreamTicket.Insert(&record); err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusCreated, Response[StreamTicketResponse]{
+		OK: true,
+		Data: StreamTicketResponse{
+			Ticket:    ticket,
+			ExpiresAt: record.ExpiresAt,
+		},
+	})
+}
+
+// live stream of the current user (Server-Sent Events): their notifications & the chapters published
+// in the books they are subscribed to. Opened with the `ticket` query param, see CreateStreamTicket.
+// a ticket only opens 1 stream, so clients get a new one to reconnect & resume with the `lastEventId`
+// query param (or the `Last-Event-ID` header).
+// events show up once older than repositories.EventCommitLag. The stream ends once its session is revoked or expired
+func (r Router) StreamEvents(c echo.Context) error {
+	ticket := c.QueryParam("ticket")
+	if ticket == "" {
+		return r.unauthorizedError(utils.ErrorInvalidToken)
+	}
+	streamTicket, err := r.Repository.StreamTicket.Consume(services.NewCryptoService().HashToken(ticket))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorInvalidToken):
+			return r.unauthorizedError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	session, err := r.Repository.Session.GetByFamily(streamTicket.FamilyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.unauthorizedError(utils.ErrorSessionRevoked)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if !session.Active() || session.UserID != streamTicket.UserID {
+		return r.unauthorizedError(utils.ErrorSessionRevoked)
+	}
+	userId := streamTicket.UserID
+	lastEventId := c.Request().Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.QueryParam("lastEventId")
+	}
+	var lastId int64
+	if lastEventId != "" {
+		lastId, err = strconv.ParseInt(lastEventId, 10, 64)
+		if err != nil || lastId < 0 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	} else {
+		// nothing to resume from, only what happens from now on
+		lastId, err = r.Repository.Event.LastID()
+		if err != nil {
+			return r.serverError(err)
+		}
+	}
+	heartbeatInterval := viper.GetDuration("stream.heartbeat_interval")
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = 25 * time.Second
+	}
+
+	// open before catching up, so that nothing inserted in between is missed
+	stream := r.StreamService.Open(userId)
+	defer r.StreamService.Close(stream)
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set(echo.HeaderCacheControl, "no-cache")
+	response.Header().Set(echo.HeaderConnection, "keep-alive")
+	response.Header().Set("X-Accel-Buffering", "no") // no proxy buffering
+	response.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(response, "retry: %d\n\n", 5000); err != nil {
+		return nil
+	}
+	response.Flush()
+
+	if lastId, err = r.sendEvents(response, userId, lastId); err != nil {
+		return nil
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	// events are read once they settled, woken tells if more were inserted since the read got scheduled.
+	// the events inserted right before the stream opened settle first
+	settle, woken := time.After(repositories.EventCommitLag), false
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-r.StreamService.Done():
+			return nil
+		case <-heartbeat.C:
+			if !r.streamSessionActive(session) {
+				return nil
+			}
+			if _, err := fmt.Fprint(response, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			response.Flush()
+		case <-stream.Wake:
+			if settle == nil {
+				settle = time.After(repositories.EventCommitLag)
+			} else {
+				woken = true
+			}
+		case <-settle:
+			if lastId, err = r.sendEvents(response, userId, lastId); err != nil {
+				return nil
+			}
+			settle = nil
+			if woken {
+				settle, woken = time.After(repositories.EventCommitLag), false
+			}
+		}
+	}
+}
+
+// whether the session a stream was opened with is still active, errors are only logged & end the stream too
+func (r Router) streamSessionActive(session *repositories.Session) bool {
+	current, err := r.Repository.Session.GetByFamily(session.FamilyID)
+	if err != nil {
+		if !errors.Is(err, utils.ErrorRecordsNotFound) {
+			r.LoggerService.LogError(err, "failed to check the session of a stream")
+		}
+		return false
+	}
+	return current.Active() && current.UserID == session.UserID
+}
+
+// write every event of the user after lastId, returns the id of the last one written.
+// the headers are already sent at this point, so errors end the stream & the client reconnects
+func (r Router) sendEvents(response *echo.Response, userId int64, lastId int64) (int64, error) {
+	for {
+		events, err := r.Repository.Event.FindSince(userId, lastId, streamBatchSize)
+		if err != nil {
+			r.LoggerService.LogError(err, "failed to read the events of a stream")
+			return lastId, err
+		}
+		for _, event := range events {
+			data := new(bytes.Buffer)
+			if err := json.Compact(data, event.Payload); err != nil {
+				return lastId, err
+			}
+			if _, err := fmt.Fprintf(response, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return lastId, err
+			}
+			lastId = event.ID
+		}
+		response.Flush()
+		if len(events) < streamBatchSize {
+			return lastId, nil
+		}
+	}
+}
+
+// wake up the streams of this replica concerned by the events inserted on any replica.
+// runs until the listener is closed
+func (r Router) DispatchEvents(listener *pq.Listener) {
+	for {
+		select {
+		case notification, ok := <-listener.Notify:
+			if !ok {
+				return
+			}
+			if notification == nil {
+				// reconnected, events may have been missed
+				r.StreamService.WakeAll()
+				continue
+			}
+			var event eventNotification
+			if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
+				r.LoggerService.LogError(err, "invalid event notification")
+				continue
+			}
+			if event.UserID != nil {
+				r.StreamService.Wake(*event.UserID)
+			}
+			if event.BookID != nil {
+				userIds := r.StreamService.UserIDs()
+				if len(userIds) == 0 {
+					continue
+				}
+				subscribers, err := r.Repository.Event.FindSubscribed(*event.BookID, userIds)
+				if err != nil {
+					r.LoggerService.LogError(err, "failed to find the subscribers of an event")
+					continue
+				}
+				r.StreamService.Wake(subscribers...)
+			}
+		case <-time.After(90 * time.Second):
+			// a quiet connection may be dead without the listener noticing
+			go listener.Ping()
+		}
+	}
+}
+
+// push the events to the live stream, failures are only logged
+func (r Router) publish(events ...*repositories.Event) {
+	if len(events) == 0 {
+		return
+	}
+	if err := r.Repository.Event.Insert(events...); err != nil {
+		r.LoggerService.LogError(err, "failed to publish events")
+	}
+}
+
+// a notification shows up on the stream of its user as is
+func (r Router) publishNotifications(notifications []*repositories.Notification) {
+	events := make([]*repositories.Event, 0, len(notifications))
+	for _, notification := range notifications {
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			r.LoggerService.LogError(err, "failed to encode a notification event")
+			continue
+		}
+		events = append(events, &repositories.Event{
+			UserID:  &notification.UserID,
+			Type:    repositories.EventTypeNotification,
+			Payload: payload,
+		})
+	}
+	r.publish(events...)
+}
+
+func (r Router) publishChapter(book *repositories.Book, chapter *repositories.Chapter) {
+	payload, err := json.Marshal(chapterPublishedEvent{
+		BookID:      book.ID,
+		BookTitle:   book.Title,
+		ChapterID:   chapter.ID,
+		ChapterNO:   chapter.ChapterNO,
+		Title:       chapter.Title,
+		PublishedAt: chapter.PublishedAt,
+	})
+	if err != nil {
+		r.LoggerService.LogError(err, "failed to encode a chapter event")
+		return
+	}
+	r.publish(&repositories.Event{
+		BookID:  &book.ID,
+		Type:    repositories.EventTypeChapterPublished,
+		Payload: payload,
+	})
+}
@@ -0,0 +1,402 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type CreateGenrePayload struct {
+	Name        string `json:"name" validate:"required,max=64"`
+	Slug        string `json:"slug" validate:"omitempty,max=64"`
+	Description string `json:"description" validate:"max=1000"`
+}
+
+type UpdateGenrePayload struct {
+	Name        string  `json:"name" validate:"max=64"`
+	Slug        string  `json:"slug" validate:"max=64"`
+	Description *string `json:"description" validate:"omitempty,max=1000"`
+}
+
+type TagPayload struct {
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+func (r Router) FindGenres(c echo.Context) error {
+	genres, err := r.Repository.Genre.Find()
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Genre]{
+		OK:   true,
+		Data: genres,
+	})
+}
+
+func (r Router) CreateGenre(c echo.Context) error {
+	validate := utils.NewValidator()
+	payload := new(CreateGenrePayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	genre := repositories.Genre{
+		Name:        strings.TrimSpace(payload.Name),
+		Slug:        utils.Slugify(payload.Slug),
+		Description: payload.Description,
+	}
+	if err := r.Repository.Genre.Insert(&genre); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordExisted):
+			return r.conflictError(err)
+		case errors.Is(err, utils.ErrorInvalidModel):
+			return r.badRequestError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusCreated, Response[repositories.Genre]{
+		OK:   true,
+		Data: genre,
+	})
+}
+
+func (r Router) UpdateGenre(c echo.Context) error {
+	genreId, err := strconv.Atoi(c.Param("genreId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	validate := utils.NewValidator()
+	payload := new(UpdateGenrePayload)
+	if err := c.Bind(payload); err != nil {
+		return r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return verr.TranslateError()
+		} else {
+			return r.serverError(err)
+		}
+	}
+	genre, err := r.Repository.Genre.Get(int64(genreId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	if name := strings.TrimSpace(payload.Name); name != "" {
+		genre.Name = name
+	}
+	if slug := utils.Slugify(payload.Slug); slug != "" {
+		genre.Slug = slug
+	}
+	if payload.Description != nil {
+		genre.Description = *payload.Description
+	}
+	if err := r.Repository.Genre.Update(genre); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordExisted):
+			return r.conflictError(err)
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Genre]{
+		OK:   true,
+		Data: *genre,
+	})
+}
+
+func (r Router) DeleteGenre(c echo.Context) error {
+	genreId, err := strconv.Atoi(c.Param("genreId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	if err := r.Repository.Genre.Delete(int64(genreId)); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// search tags by the beginning of their name or synonyms, used for autocompletion
+func (r Router) FindTags(c echo.Context) error {
+	filter := repositories.Filter{
+		Page:         1,
+		PageSize:     20,
+		SortSafeList: []string{"name", "-name", "book_count", "-book_count"},
+		Sort:         "-book_count",
+	}
+	queryParams := c.QueryParams()
+	if queryParams.Has("page") {
+		page, err := strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page < 1 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.Page = page
+	}
+	if queryParams.Has("pageSize") {
+		pageSize, err := strconv.Atoi(queryParams.Get("pageSize"))
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+		filter.PageSize = pageSize
+	}
+	if queryParams.Has("sort") {
+		filter.Sort = queryParams.Get("sort")
+		if !utils.IsItemInCollection(filter.Sort, filter.SortSafeList) {
+			return r.badRequestError(utils.ErrorInvalidQueryParams)
+		}
+	}
+	tags, metadata, err := r.Repository.Tag.Find(queryParams.Get("name"), filter)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[[]*repositories.Tag]{
+		OK:       true,
+		Metadata: metadata,
+		Data:     tags,
+	})
+}
+
+func (r Router) GetTag(c echo.Context) error {
+	tag, err := r.getTagFromParam(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Tag]{
+		OK:   true,
+		Data: *tag,
+	})
+}
+
+func (r Router) CreateTag(c echo.Context) error {
+	payload, err := r.bindTagPayload(c)
+	if err != nil {
+		return err
+	}
+	tag := repositories.Tag{
+		Name: payload.Name,
+	}
+	if err := r.Repository.Tag.Insert(&tag); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordExisted):
+			return r.conflictError(err)
+		case errors.Is(err, utils.ErrorInvalidModel):
+			return r.badRequestError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusCreated, Response[repositories.Tag]{
+		OK:   true,
+		Data: tag,
+	})
+}
+
+// rename a tag
+func (r Router) UpdateTag(c echo.Context) error {
+	tag, err := r.getTagFromParam(c)
+	if err != nil {
+		return err
+	}
+	payload, err := r.bindTagPayload(c)
+	if err != nil {
+		return err
+	}
+	tag.Name = payload.Name
+	if err := r.Repository.Tag.Update(tag); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordExisted):
+			return r.conflictError(err)
+		case errors.Is(err, utils.ErrorInvalidModel):
+			return r.badRequestError(err)
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithTag(c, tag.ID)
+}
+
+func (r Router) DeleteTag(c echo.Context) error {
+	tagId, err := strconv.Atoi(c.Param("tagId"))
+	if err != nil {
+		return r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	if err := r.Repository.Tag.Delete(int64(tagId)); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, Response[any]{
+		OK: true,
+	})
+}
+
+// make another name resolve to the tag, an existing tag with that name is merged into this one
+func (r Router) AddTagSynonym(c echo.Context) error {
+	tag, err := r.getTagFromParam(c)
+	if err != nil {
+		return err
+	}
+	payload, err := r.bindTagPayload(c)
+	if err != nil {
+		return err
+	}
+	if err := r.Repository.Tag.AddSynonym(tag.ID, payload.Name); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorInvalidModel):
+			return r.badRequestError(fmt.Errorf("a tag can't be a synonym of itself"))
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithTag(c, tag.ID)
+}
+
+func (r Router) RemoveTagSynonym(c echo.Context) error {
+	tag, err := r.getTagFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := r.Repository.Tag.RemoveSynonym(tag.ID, c.Param("synonym")); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return r.notFoundError(err)
+		default:
+			return r.serverError(err)
+		}
+	}
+	return r.respondWithTag(c, tag.ID)
+}
+
+func (r Router) getTagFromParam(c echo.Context) (*repositories.Tag, error) {
+	tagId, err := strconv.Atoi(c.Param("tagId"))
+	if err != nil {
+		return nil, r.badRequestError(utils.ErrorInvalidRouteParam)
+	}
+	tag, err := r.Repository.Tag.Get(int64(tagId))
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrorRecordsNotFound):
+			return nil, r.notFoundError(err)
+		default:
+			return nil, r.serverError(err)
+		}
+	}
+	return tag, nil
+}
+
+func (r Router) bindTagPayload(c echo.Context) (*TagPayload, error) {
+	validate := utils.NewValidator()
+	payload := new(TagPayload)
+	if err := c.Bind(payload); err != nil {
+		return nil, r.badRequestError(err)
+	}
+	if err := validate.ValidateStruct(payload); err != nil {
+		if verr, ok := err.(*utils.StructValidationErrors); ok {
+			return nil, verr.TranslateError()
+		} else {
+			return nil, r.serverError(err)
+		}
+	}
+	return payload, nil
+}
+
+// reload the tag so synonyms & book count are up to date
+func (r Router) respondWithTag(c echo.Context, tagId int64) error {
+	tag, err := r.Repository.Tag.Get(tagId)
+	if err != nil {
+		return r.serverError(err)
+	}
+	return c.JSON(http.StatusOK, Response[repositories.Tag]{
+		OK:   true,
+		Data: *tag,
+	})
+}
+
+// genres, excludeGenres, tags & excludeTags query params, either repeated or comma separated
+func (r Router) parseBookTaxonomyFilter(c echo.Context) (repositories.BookTaxonomyFilter, error) {
+	queryParams := c.QueryParams()
+	list := func(key string) []string {
+		values := []string{}
+		for _, param := range queryParams[key] {
+			for _, value := range strings.Split(param, ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					values = append(values, value)
+				}
+			}
+		}
+		return values
+	}
+	slugs := func(key string) []string {
+		values := list(key)
+		for i := range values {
+			values[i] = utils.Slugify(values[i])
+		}
+		return values
+	}
+	filter := repositories.BookTaxonomyFilter{
+		Genres:        slugs("genres"),
+		ExcludeGenres: slugs("excludeGenres"),
+	}
+	var err error
+	if filter.Tags, err = r.Repository.Tag.Resolve(list("tags")); err != nil {
+		return filter, r.serverError(err)
+	}
+	if filter.ExcludeTags, err = r.Repository.Tag.Resolve(list("excludeTags")); err != nil {
+		return filter, r.serverError(err)
+	}
+	return filter, nil
+}
+
+// check every slug is a curated genre
+func (r Router) validateGenres(slugs []string) error {
+	if len(slugs) == 0 {
+		return nil
+	}
+	genres, err := r.Repository.Genre.Find()
+	if err != nil {
+		return r.serverError(err)
+	}
+	known := make([]string, 0, len(genres))
+	for _, genre := range genres {
+		known = append(known, genre.Slug)
+	}
+	for _, slug := range slugs {
+		if !utils.IsItemInCollection(slug, known) {
+			return r.badRequestError(fmt.Errorf("unknown genre: %s", slug))
+		}
+	}
+	return nil
+}
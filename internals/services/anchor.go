@@ -0,0 +1,146 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrorInvalidAnchor = errors.New("range is outside of the paragraph")
+
+// a quote found more often than this can't be told apart from its copies, Reanchor orphans it.
+// it also bounds the search of short quotes in long texts
+const maxQuoteOccurrences = 1000
+
+// 1 non blank line of a chapter text
+type Paragraph struct {
+	Index int `json:"index"`
+	// derived from the paragraph text, so it survives paragraphs being added or removed around it.
+	// identical paragraphs get a `-2`, `-3`... suffix in order of appearance
+	Anchor string `json:"anchor"`
+	Text   string `json:"text"`
+}
+
+// position of a range of text, offsets are in runes relative to the paragraph.
+// the quoted text is kept to find the range again once the paragraph changes
+type TextAnchor struct {
+	Paragraph int    `json:"paragraph"`
+	Anchor    string `json:"anchor"`
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+	Quote     string `json:"quote"`
+}
+
+type AnchorService struct{}
+
+func NewAnchorService() AnchorService {
+	return AnchorService{}
+}
+
+func (service AnchorService) Paragraphs(text string) []Paragraph {
+	paragraphs := []Paragraph{}
+	occurrences := map[string]int{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		sum := sha256.Sum256([]byte(trimmed))
+		anchor := hex.EncodeToString(sum[:])[:16]
+		occurrences[anchor]++
+		if n := occurrences[anchor]; n > 1 {
+			anchor = fmt.Sprintf("%s-%d", anchor, n)
+		}
+		paragraphs = append(paragraphs, Paragraph{
+			Index:  len(paragraphs),
+			Anchor: anchor,
+			Text:   line,
+		})
+	}
+	return paragraphs
+}
+
+// anchor the range [start, end) of the paragraph at index paragraph
+func (service AnchorService) Locate(text string, paragraph int, start int, end int) (TextAnchor, error) {
+	paragraphs := service.Paragraphs(text)
+	if paragraph < 0 || paragraph >= len(paragraphs) {
+		return TextAnchor{}, ErrorInvalidAnchor
+	}
+	runes := []rune(paragraphs[paragraph].Text)
+	if start < 0 || start >= end || end > len(runes) {
+		return TextAnchor{}, ErrorInvalidAnchor
+	}
+	return TextAnchor{
+		Paragraph: paragraph,
+		Anchor:    paragraphs[paragraph].Anchor,
+		Start:     start,
+		End:       end,
+		Quote:     string(runes[start:end]),
+	}, nil
+}
+
+// find the anchored range in the paragraphs of a new version of the text, see Paragraphs.
+// an untouched paragraph keeps its offsets, otherwise the quote is searched in every paragraph
+// & the occurrence closest to the previous position wins. false when the quote is gone or too common
+func (service AnchorService) Reanchor(anchor TextAnchor, paragraphs []Paragraph) (TextAnchor, bool) {
+	if anchor.Quote == "" {
+		return anchor, false
+	}
+	for _, paragraph := range paragraphs {
+		if paragraph.Anchor != anchor.Anchor {
+			continue
+		}
+		runes := []rune(paragraph.Text)
+		if anchor.End <= len(runes) && string(runes[anchor.Start:anchor.End]) == anchor.Quote {
+			anchor.Paragraph = paragraph.Index
+			return anchor, true
+		}
+	}
+
+	found := false
+	best := anchor
+	bestDistance := [2]int{}
+	quoteLength := utf8.RuneCountInString(anchor.Quote)
+	occurrences := 0
+	for _, paragraph := range paragraphs {
+		// runes before paragraph.Text[from:], counted as the search moves forward
+		position := 0
+		for from := 0; from < len(paragraph.Text); {
+			i := strings.Index(paragraph.Text[from:], anchor.Quote)
+			if i < 0 {
+				break
+			}
+			if occurrences++; occurrences > maxQuoteOccurrences {
+				return anchor, false
+			}
+			start := position + utf8.RuneCountInString(paragraph.Text[from:from+i])
+			distance := [2]int{abs(paragraph.Index - anchor.Paragraph), abs(start - anchor.Start)}
+			if !found || distance[0] < bestDistance[0] || (distance[0] == bestDistance[0] && distance[1] < bestDistance[1]) {
+				found = true
+				bestDistance = distance
+				best = TextAnchor{
+					Paragraph: paragraph.Index,
+					Anchor:    paragraph.Anchor,
+					Start:     start,
+					End:       start + quoteLength,
+					Quote:     anchor.Quote,
+				}
+			}
+			_, size := utf8.DecodeRuneInString(paragraph.Text[from+i:])
+			from += i + size
+			position = start + 1
+		}
+	}
+	return best, found
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
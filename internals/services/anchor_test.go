@@ -0,0 +1,96 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnchorServiceParagraphs(t *testing.T) {
+	paragraphs := NewAnchorService().Paragraphs("first\n\n  \nsecond\r\nfirst\n")
+	if len(paragraphs) != 3 {
+		t.Fatalf("%d paragraphs, expected 3", len(paragraphs))
+	}
+	if paragraphs[1].Text != "second" || paragraphs[2].Index != 2 {
+		t.Errorf("unexpected paragraphs %+v", paragraphs)
+	}
+	if paragraphs[2].Anchor != paragraphs[0].Anchor+"-2" {
+		t.Errorf("the copy of the first paragraph is anchored at %q, expected %q", paragraphs[2].Anchor, paragraphs[0].Anchor+"-2")
+	}
+}
+
+func TestAnchorServiceLocate(t *testing.T) {
+	service := NewAnchorService()
+	anchor, err := service.Locate("intro\nhéllo wörld", 1, 6, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anchor.Quote != "wörld" || anchor.Paragraph != 1 {
+		t.Errorf("unexpected anchor %+v", anchor)
+	}
+	for _, bounds := range [][3]int{{2, 0, 1}, {1, 3, 3}, {1, 6, 12}, {-1, 0, 1}} {
+		if _, err := service.Locate("intro\nhéllo wörld", bounds[0], bounds[1], bounds[2]); err != ErrorInvalidAnchor {
+			t.Errorf("Locate(%v) gives %v, expected ErrorInvalidAnchor", bounds, err)
+		}
+	}
+}
+
+func TestAnchorServiceReanchor(t *testing.T) {
+	service := NewAnchorService()
+	text := "intro\nthe cat sat on the mat\noutro"
+	anchor, err := service.Locate(text, 1, 4, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		text     string
+		found    bool
+		expected TextAnchor
+	}{
+		{
+			name: "untouched paragraph", text: "new first paragraph\n" + text, found: true,
+			expected: TextAnchor{Paragraph: 2, Anchor: anchor.Anchor, Start: 4, End: 7, Quote: "cat"},
+		},
+		{
+			name: "edited paragraph", text: "intro\nthé big cat sat on the mat\noutro", found: true,
+			expected: TextAnchor{Paragraph: 1, Start: 8, End: 11, Quote: "cat"},
+		},
+		{
+			name: "closest occurrence", text: "intro\nthe dog & cat sat, like the other cat\nno\na cat", found: true,
+			expected: TextAnchor{Paragraph: 1, Start: 10, End: 13, Quote: "cat"},
+		},
+		{
+			name: "removed quote", text: "intro\nthe dog sat on the mat\noutro", found: false,
+		},
+		{
+			name: "too common quote", text: strings.Repeat("cat ", maxQuoteOccurrences+1), found: false,
+		},
+	}
+	for _, test := range tests {
+		reanchored, found := service.Reanchor(anchor, service.Paragraphs(test.text))
+		if found != test.found {
+			t.Errorf("%s: found is %v, expected %v", test.name, found, test.found)
+			continue
+		}
+		if !found {
+			continue
+		}
+		if test.expected.Anchor == "" {
+			test.expected.Anchor = service.Paragraphs(test.text)[test.expected.Paragraph].Anchor
+		}
+		if reanchored != test.expected {
+			t.Errorf("%s: reanchored at %+v, expected %+v", test.name, reanchored, test.expected)
+		}
+	}
+}
+
+// a short quote in a long chapter, the search must not start over from the paragraph start for every match
+func TestAnchorServiceReanchorLongText(t *testing.T) {
+	service := NewAnchorService()
+	anchor := TextAnchor{Paragraph: 0, Anchor: "gone", Start: 150000, End: 150001, Quote: "é"}
+	text := strings.Repeat("aé", maxQuoteOccurrences/2) + strings.Repeat("b", 200000)
+	reanchored, found := service.Reanchor(anchor, service.Paragraphs(text))
+	if !found || reanchored.Start != maxQuoteOccurrences-1 {
+		t.Errorf("reanchored at %+v (%v), expected the last occurrence", reanchored, found)
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// tiny in-process cache with a fixed time to live.
+// expired entries are evicted on read & whenever the cache doubled in size since they were last purged,
+// so it holds at most about twice the entries set within a time to live
+type CacheService[K comparable, V any] struct {
+	ttl     time.Duration
+	mu      sync.RWMutex
+	entries map[K]cacheEntry[V]
+	// size from which Set purges the expired entries
+	purgeAt int
+}
+
+// smallest purgeAt, purging tiny caches isn't worth it
+const minCachePurgeSize = 1024
+
+type cacheEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+func NewCacheService[K comparable, V any](ttl time.Duration) *CacheService[K, V] {
+	return &CacheService[K, V]{
+		ttl:     ttl,
+		entries: map[K]cacheEntry[V]{},
+		purgeAt: minCachePurgeSize,
+	}
+}
+
+func (service *CacheService[K, V]) Get(key K) (V, bool) {
+	service.mu.RLock()
+	entry, found := service.entries[key]
+	service.mu.RUnlock()
+	if !found {
+		var zero V
+		return zero, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		service.Delete(key)
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (service *CacheService[K, V]) Set(key K, value V) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if len(service.entries) >= service.purgeAt {
+		service.purge()
+		service.purgeAt = max(2*len(service.entries), minCachePurgeSize)
+	}
+	service.entries[key] = cacheEntry[V]{
+		value:     value,
+		expiresAt: time.Now().Add(service.ttl),
+	}
+}
+
+func (service *CacheService[K, V]) Delete(key K) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	delete(service.entries, key)
+}
+
+// drop every expired entry
+func (service *CacheService[K, V]) Purge() {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	service.purge()
+}
+
+// the lock must be held
+func (service *CacheService[K, V]) purge() {
+	now := time.Now()
+	for key, entry := range service.entries {
+		if now.After(entry.expiresAt) {
+			delete(service.entries, key)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"gin_stuff/internals/utils"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// how the text of a chapter content is written
+const (
+	ContentFormatPlain    = "plain"
+	ContentFormatMarkdown = "markdown"
+	ContentFormatHTML     = "html"
+)
+
+var ContentFormats = []string{ContentFormatPlain, ContentFormatMarkdown, ContentFormatHTML}
+
+// renders chapter contents to HTML that is safe to show as is.
+// the output is XHTML as well, so that it can be embedded in EPUBs
+type ContentService struct{}
+
+func NewContentService() *ContentService {
+	return &ContentService{}
+}
+
+// the text of the format as sanitized HTML. Plain text paragraphs are separated by blank lines
+func (service *ContentService) Render(text string, format string) string {
+	switch format {
+	case ContentFormatMarkdown:
+		return service.Sanitize(renderMarkdown(text))
+	case ContentFormatHTML:
+		return service.Sanitize(text)
+	default:
+		builder := new(strings.Builder)
+		for _, paragraph := range textParagraphs(text) {
+			builder.WriteString("<p>")
+			for index, line := range paragraph {
+				if index > 0 {
+					builder.WriteString("<br/>\n")
+				}
+				builder.WriteString(epubEscape(line))
+			}
+			builder.WriteString("</p>\n")
+		}
+		return builder.String()
+	}
+}
+
+// elements kept by Sanitize with the attributes they can have, other elements are replaced by their content
+var sanitizedElements = map[atom.Atom][]string{
+	atom.P: nil, atom.Br: nil, atom.Hr: nil, atom.Div: nil, atom.Span: nil,
+	atom.H1: nil, atom.H2: nil, atom.H3: nil, atom.H4: nil, atom.H5: nil, atom.H6: nil,
+	atom.Blockquote: {"cite"}, atom.Pre: nil, atom.Code: {"class"},
+	atom.Em: nil, atom.Strong: nil, atom.B: nil, atom.I: nil, atom.U: nil, atom.S: nil, atom.Del: nil, atom.Ins: nil,
+	atom.Sub: nil, atom.Sup: nil, atom.Mark: nil, atom.Small: nil, atom.Abbr: nil, atom.Cite: nil, atom.Q: {"cite"},
+	atom.Kbd: nil, atom.Samp: nil, atom.Var: nil,
+	atom.A: {"href"}, atom.Img: {"src", "alt", "width", "height"},
+	atom.Ul: nil, atom.Ol: {"start"}, atom.Li: nil, atom.Dl: nil, atom.Dt: nil, atom.Dd: nil,
+	atom.Table: nil, atom.Caption: nil, atom.Thead: nil, atom.Tbody: nil, atom.Tfoot: nil, atom.Tr: nil,
+	atom.Th: {"colspan", "rowspan", "align"}, atom.Td: {"colspan", "rowspan", "align"},
+	atom.Figure: nil, atom.Figcaption: nil,
+}
+
+// attributes every kept element can have
+var sanitizedGlobalAttributes = []string{"title", "lang", "dir"}
+
+// elements removed along with their content
+var sanitizedDroppedElements = []atom.Atom{
+	atom.Script, atom.Style, atom.Iframe, atom.Object, atom.Embed, atom.Noscript, atom.Template,
+	atom.Textarea, atom.Select, atom.Button, atom.Form, atom.Title, atom.Head, atom.Svg, atom.Math,
+}
+
+var (
+	sanitizedCodeClass = regexp.MustCompile(`^language-[A-Za-z0-9_+-]+$`)
+	sanitizedNumber    = regexp.MustCompile(`^[0-9]{1,4}$`)
+)
+
+var sanitizedVoidElements = []atom.Atom{atom.Br, atom.Hr, atom.Img}
+
+// kept elements nested deeper are replaced by their content
+const maxSanitizedDepth = 64
+
+// keep only the allowlisted elements & attributes of the HTML, links & images only to http(s) URLs,
+// relative ones or mail addresses. Comments are removed, the output is well-formed.
+// the HTML is tokenized rather than parsed into a tree, so it takes time linear to its length
+func (service *ContentService) Sanitize(source string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(source))
+	builder := new(strings.Builder)
+	// the kept elements not closed yet
+	open := []string{}
+	// the element whose content is being removed, with how many of it are open
+	dropped, droppedDepth := "", 0
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			// end of the input, malformed HTML is tokenized as text
+			for index := len(open) - 1; index >= 0; index-- {
+				builder.WriteString("</" + open[index] + ">")
+			}
+			return builder.String()
+		case html.TextToken:
+			if dropped == "" {
+				builder.WriteString(epubEscape(string(tokenizer.Text())))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if dropped != "" {
+				if token.Data == dropped && tokenType == html.StartTagToken {
+					droppedDepth++
+				}
+				continue
+			}
+			if utils.IsItemInCollection(token.DataAtom, sanitizedDroppedElements) {
+				if tokenType == html.StartTagToken {
+					dropped, droppedDepth = token.Data, 1
+				}
+				continue
+			}
+			allowed, kept := sanitizedElements[token.DataAtom]
+			if !kept || len(open) >= maxSanitizedDepth {
+				continue
+			}
+			builder.WriteString("<" + token.Data)
+			for _, attr := range token.Attr {
+				if !utils.IsItemInCollection(attr.Key, allowed) && !utils.IsItemInCollection(attr.Key, sanitizedGlobalAttributes) {
+					continue
+				}
+				value, ok := sanitizeAttribute(token.DataAtom, attr.Key, attr.Val)
+				if !ok {
+					continue
+				}
+				builder.WriteString(" " + attr.Key + `="` + epubEscape(value) + `"`)
+			}
+			if token.DataAtom == atom.A {
+				builder.WriteString(` rel="nofollow noopener noreferrer"`)
+			}
+			switch {
+			case utils.IsItemInCollection(token.DataAtom, sanitizedVoidElements):
+				builder.WriteString("/>")
+			case tokenType == html.SelfClosingTagToken:
+				builder.WriteString("></" + token.Data + ">")
+			default:
+				builder.WriteString(">")
+				open = append(open, token.Data)
+			}
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if dropped != "" {
+				if token.Data == dropped {
+					if droppedDepth--; droppedDepth == 0 {
+						dropped = ""
+					}
+				}
+				continue
+			}
+			// close the elements left open inside the matching one, end tags of elements that aren't open are left out
+			for index := len(open) - 1; index >= 0; index-- {
+				if open[index] != token.Data {
+					continue
+				}
+				for closed := len(open) - 1; closed >= index; closed-- {
+					builder.WriteString("</" + open[closed] + ">")
+				}
+				open = open[:index]
+				break
+			}
+		}
+	}
+}
+
+func sanitizeAttribute(element atom.Atom, key string, value string) (string, bool) {
+	switch key {
+	case "href", "src", "cite":
+		return sanitizeURL(value, element == atom.A && key == "href")
+	case "class":
+		return value, sanitizedCodeClass.MatchString(value)
+	case "colspan", "rowspan", "width", "height", "start":
+		return value, sanitizedNumber.MatchString(value)
+	case "align":
+		return value, utils.IsItemInCollection(value, []string{"left", "center", "right"})
+	case "dir":
+		return value, utils.IsItemInCollection(value, []string{"ltr", "rtl", "auto"})
+	default:
+		return value, true
+	}
+}
+
+func sanitizeURL(value string, allowMail bool) (string, bool) {
+	value = strings.TrimSpace(value)
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return "", false
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "":
+		// no scheme hidden behind a relative looking URL, like //evil.com
+		return value, parsed.Host == ""
+	case "http", "https":
+		return value, true
+	case "mailto":
+		return value, allowMail
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// inputs that take time quadratic to their length or depth to parse naively
+func pathologicalContents() map[string][2]string {
+	return map[string][2]string{
+		"unmatched emphasis": {strings.Repeat("a*", 100000), ContentFormatMarkdown},
+		"nested lists":       {strings.Repeat("- ", 9000) + "a", ContentFormatMarkdown},
+		"nested quotes":      {strings.Repeat("> ", 9000) + "a", ContentFormatMarkdown},
+		"brackets":           {strings.Repeat("[", 100000), ContentFormatMarkdown},
+		"backticks":          {strings.Repeat("`a", 100000), ContentFormatMarkdown},
+		"long nested lists":  {strings.Repeat("- ", 99999) + "a", ContentFormatMarkdown},
+		"indented lists":     {nestedList(400), ContentFormatMarkdown},
+		"nested HTML":        {strings.Repeat("<div><b>", 25000), ContentFormatHTML},
+	}
+}
+
+// the output keeps the text, stays about as long as the input & isn't nested past maxSanitizedDepth
+func TestContentServiceRenderPathologicalContent(t *testing.T) {
+	service := NewContentService()
+	for name, content := range pathologicalContents() {
+		input, format := content[0], content[1]
+		output := service.Render(input, format)
+		if len(output) > 20*len(input) {
+			t.Errorf("%s: %d bytes rendered from %d", name, len(output), len(input))
+		}
+		if depth := htmlDepth(output); depth > maxSanitizedDepth {
+			t.Errorf("%s: nested %d elements deep", name, depth)
+		}
+		if format == ContentFormatMarkdown && strings.Count(output, "a") < strings.Count(input, "a") {
+			t.Errorf("%s: text left out", name)
+		}
+	}
+}
+
+// rendering has to stay about linear, it runs on every save of a chapter
+func BenchmarkContentServiceRenderPathologicalContent(b *testing.B) {
+	service := NewContentService()
+	for name, content := range pathologicalContents() {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				service.Render(content[0], content[1])
+			}
+		})
+	}
+}
+
+// how deep the elements of the sanitized HTML are nested
+func htmlDepth(source string) int {
+	depth, deepest := 0, 0
+	tokenizer := html.NewTokenizer(strings.NewReader(source))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return deepest
+		case html.StartTagToken:
+			depth++
+			deepest = max(deepest, depth)
+		case html.EndTagToken:
+			depth--
+		}
+	}
+}
+
+// each item 1 level deeper than the previous one
+func nestedList(depth int) string {
+	builder := new(strings.Builder)
+	for level := 0; level < depth; level++ {
+		builder.WriteString(strings.Repeat("  ", level) + "- item\n")
+	}
+	return builder.String()
+}
+
+func TestContentServiceSanitize(t *testing.T) {
+	tests := map[string]string{
+		`<p onclick="x()">a<script>alert(1)</script></p>`:    `<p>a</p>`,
+		`<a href="javascript:alert(1)">a</a>`:                `<a rel="nofollow noopener noreferrer">a</a>`,
+		`<a href="https://example.com">a</a>`:                `<a href="https://example.com" rel="nofollow noopener noreferrer">a</a>`,
+		`<img src="//example.com/a.png" onerror="x()">`:      `<img/>`,
+		`<code class="language-go">a</code><!-- comment -->`: `<code class="language-go">a</code>`,
+		`<iframe src="https://example.com"></iframe>b<br>`:   `b<br/>`,
+		`<div><p>a</div>b</p><em>c`:                          `<div><p>a</p></div>b<em>c</em>`,
+	}
+	service := NewContentService()
+	for input, expected := range tests {
+		if output := service.Sanitize(input); output != expected {
+			t.Errorf("Sanitize(%q) = %q, expected %q", input, output, expected)
+		}
+	}
+}
+
+func TestContentServiceRenderMarkdown(t *testing.T) {
+	output := NewContentService().Render("# Title\n\nSome *text* <script>alert(1)</script> [link](javascript:alert(1))", ContentFormatMarkdown)
+	for _, unexpected := range []string{"<script", "javascript:"} {
+		if strings.Contains(output, unexpected) {
+			t.Errorf("rendered markdown contains %q: %s", unexpected, output)
+		}
+	}
+	if !strings.Contains(output, "<h1>Title</h1>") || !strings.Contains(output, "<em>text</em>") {
+		t.Errorf("markdown not rendered: %s", output)
+	}
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 
 	"golang.org/x/crypto/bcrypt"
@@ -32,3 +33,10 @@ func (service CryptoService) GenerateSecureToken(length int) string {
 	}
 	return hex.EncodeToString(b)
 }
+
+// deterministic digest for high entropy tokens that need to be looked up by value.
+// don't use this for password, use Hash instead
+func (service CryptoService) HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	DiffGranularityWord = "word"
+	DiffGranularityLine = "line"
+
+	DiffOpEqual  = "equal"
+	DiffOpInsert = "insert"
+	DiffOpDelete = "delete"
+)
+
+// beyond this many edits the differ stops looking for the shortest script
+// and reports the remaining middle part as a single delete + insert
+const maxDiffEdits = 2000
+
+type DiffService struct{}
+
+func NewDiffService() DiffService {
+	return DiffService{}
+}
+
+// a run of consecutive tokens sharing the same operation
+type DiffRun struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+type DiffResult struct {
+	Granularity string    `json:"granularity"`
+	Runs        []DiffRun `json:"runs"`
+	Inserted    int       `json:"inserted"` // number of inserted tokens
+	Deleted     int       `json:"deleted"`  // number of deleted tokens
+}
+
+// diff 2 texts, splitting them into words or lines depending on granularity
+func (service DiffService) Diff(from, to string, granularity string) DiffResult {
+	var a, b []string
+	switch granularity {
+	case DiffGranularityLine:
+		a, b = splitLines(from), splitLines(to)
+	default:
+		granularity = DiffGranularityWord
+		a, b = splitWords(from), splitWords(to)
+	}
+
+	result := DiffResult{
+		Granularity: granularity,
+		Runs:        []DiffRun{},
+	}
+	// the text of the last run is built up here & only copied into it once the run ends
+	text := new(strings.Builder)
+	endRun := func() {
+		if last := len(result.Runs) - 1; last >= 0 {
+			result.Runs[last].Text = text.String()
+		}
+		text = new(strings.Builder)
+	}
+	appendToken := func(op string, token string) {
+		switch op {
+		case DiffOpInsert:
+			result.Inserted++
+		case DiffOpDelete:
+			result.Deleted++
+		}
+		if last := len(result.Runs) - 1; last < 0 || result.Runs[last].Op != op {
+			endRun()
+			result.Runs = append(result.Runs, DiffRun{Op: op})
+		}
+		text.WriteString(token)
+	}
+
+	// common prefix & suffix never need the expensive part
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for _, token := range a[:prefix] {
+		appendToken(DiffOpEqual, token)
+	}
+	for _, edit := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		appendToken(edit.op, edit.token)
+	}
+	for _, token := range a[len(a)-suffix:] {
+		appendToken(DiffOpEqual, token)
+	}
+	endRun()
+	return result
+}
+
+type diffEdit struct {
+	op    string
+	token string
+}
+
+// Myers' O(ND) shortest edit script
+func myers(a, b []string) []diffEdit {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	if max > maxDiffEdits {
+		max = maxDiffEdits
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// v before each step d, only the diagonals -d-1 to d+1 that step reads
+	trace := [][]int{}
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		edits := make([]diffEdit, 0, n+m)
+		for _, token := range a {
+			edits = append(edits, diffEdit{op: DiffOpDelete, token: token})
+		}
+		for _, token := range b {
+			edits = append(edits, diffEdit{op: DiffOpInsert, token: token})
+		}
+		return edits
+	}
+
+	// walk the trace backwards to rebuild the script
+	edits := []diffEdit{}
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, offset := trace[d], d+1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, diffEdit{op: DiffOpEqual, token: a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, diffEdit{op: DiffOpInsert, token: b[prevY]})
+			} else {
+				edits = append(edits, diffEdit{op: DiffOpDelete, token: a[prevX]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// split into lines, each line keeps its trailing newline
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// split into alternating runs of words & whitespaces so joining the tokens gives back the text
+func splitWords(text string) []string {
+	tokens := []string{}
+	start := 0
+	inSpace := false
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if i > start && space != inSpace {
+			tokens = append(tokens, text[start:i])
+			start = i
+		}
+		inSpace = space
+	}
+	if start < len(text) {
+		tokens = append(tokens, text[start:])
+	}
+	return tokens
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDiffServiceDiff(t *testing.T) {
+	tests := []struct {
+		name        string
+		from, to    string
+		granularity string
+		expected    []DiffRun
+	}{
+		{
+			name: "replaced word", from: "the quick fox", to: "the slow fox", granularity: DiffGranularityWord,
+			expected: []DiffRun{{DiffOpEqual, "the "}, {DiffOpDelete, "quick"}, {DiffOpInsert, "slow"}, {DiffOpEqual, " fox"}},
+		},
+		{
+			name: "inserted line", from: "a\nc\n", to: "a\nb\nc\n", granularity: DiffGranularityLine,
+			expected: []DiffRun{{DiffOpEqual, "a\n"}, {DiffOpInsert, "b\n"}, {DiffOpEqual, "c\n"}},
+		},
+		{
+			name: "same text", from: "a b", to: "a b", granularity: DiffGranularityWord,
+			expected: []DiffRun{{DiffOpEqual, "a b"}},
+		},
+		{
+			name: "from nothing", from: "", to: "a b", granularity: DiffGranularityWord,
+			expected: []DiffRun{{DiffOpInsert, "a b"}},
+		},
+		{
+			name: "to nothing", from: "a\nb", to: "", granularity: DiffGranularityLine,
+			expected: []DiffRun{{DiffOpDelete, "a\nb"}},
+		},
+		{
+			name: "nothing", from: "", to: "", granularity: DiffGranularityWord,
+			expected: []DiffRun{},
+		},
+	}
+	for _, test := range tests {
+		result := NewDiffService().Diff(test.from, test.to, test.granularity)
+		if fmt.Sprint(result.Runs) != fmt.Sprint(test.expected) {
+			t.Errorf("%s: runs are %q, expected %q", test.name, result.Runs, test.expected)
+		}
+	}
+}
+
+// both texts can be rebuilt from the runs, which are as short as possible & never repeat an operation
+func TestDiffServiceDiffIsShortest(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	words := []string{"a", "b", "c", "d"}
+	randomText := func() string {
+		tokens := make([]string, random.Intn(30))
+		for index := range tokens {
+			tokens[index] = words[random.Intn(len(words))]
+		}
+		return strings.Join(tokens, " ")
+	}
+	for i := 0; i < 1000; i++ {
+		from, to := randomText(), randomText()
+		result := NewDiffService().Diff(from, to, DiffGranularityWord)
+		checkDiffRuns(t, from, to, result)
+		a, b := splitWords(from), splitWords(to)
+		if edits := result.Inserted + result.Deleted; edits != len(a)+len(b)-2*longestCommonSubsequence(a, b) {
+			t.Fatalf("Diff(%q, %q) takes %d edits, more than needed", from, to, edits)
+		}
+	}
+}
+
+// a chapter at the maximum content length, with a few words changed & completely rewritten
+func TestDiffServiceDiffLargeText(t *testing.T) {
+	from := largeText(200000)
+	to := strings.Replace(from, "word17 ", "changed ", 3)
+	result := NewDiffService().Diff(from, to, DiffGranularityWord)
+	checkDiffRuns(t, from, to, result)
+	if result.Inserted != 3 || result.Deleted != 3 {
+		t.Errorf("%d inserted & %d deleted tokens, expected 3 & 3", result.Inserted, result.Deleted)
+	}
+
+	// past maxDiffEdits the middle is replaced as a whole
+	to = strings.ReplaceAll(from, "word", "other")
+	result = NewDiffService().Diff(from, to, DiffGranularityWord)
+	checkDiffRuns(t, from, to, result)
+	if len(result.Runs) > 3 {
+		t.Errorf("%d runs, expected the whole text replaced", len(result.Runs))
+	}
+}
+
+func BenchmarkDiffServiceDiff(b *testing.B) {
+	from := largeText(200000)
+	to := strings.Replace(from, "word17 ", "changed ", 50)
+	for i := 0; i < b.N; i++ {
+		NewDiffService().Diff(from, to, DiffGranularityWord)
+	}
+}
+
+func checkDiffRuns(t *testing.T, from string, to string, result DiffResult) {
+	t.Helper()
+	source, target := new(strings.Builder), new(strings.Builder)
+	for index, run := range result.Runs {
+		if index > 0 && result.Runs[index-1].Op == run.Op {
+			t.Fatalf("runs %d & %d are both %s", index-1, index, run.Op)
+		}
+		if run.Op != DiffOpInsert {
+			source.WriteString(run.Text)
+		}
+		if run.Op != DiffOpDelete {
+			target.WriteString(run.Text)
+		}
+	}
+	if source.String() != from || target.String() != to {
+		t.Fatalf("the runs don't rebuild the texts %.40q & %.40q", from, to)
+	}
+}
+
+func longestCommonSubsequence(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+// about length bytes of words separated by spaces & a line break every 20 words
+func largeText(length int) string {
+	builder := new(strings.Builder)
+	for index := 0; builder.Len() < length; index++ {
+		separator := " "
+		if index%20 == 19 {
+			separator = "\n"
+		}
+		fmt.Fprintf(builder, "word%d%s", index%100, separator)
+	}
+	return builder.String()
+}
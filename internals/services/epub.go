@@ -0,0 +1,294 @@
+package services
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// EPUB 3 package of a book: a generated cover, the navigation document & 1 XHTML file per chapter
+type EpubBook struct {
+	// unique & stable across exports of the same book
+	Identifier  string
+	Title       string
+	Description string
+	Author      string
+	Language    string
+	Modified    time.Time
+	Chapters    []EpubChapter
+}
+
+type EpubChapter struct {
+	Title string
+	// plain text, blank lines separate the paragraphs
+	Text string
+	// sanitized XHTML used instead of the text when set, see ContentService.Render
+	HTML string
+}
+
+type EpubService struct{}
+
+func NewEpubService() *EpubService {
+	return &EpubService{}
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubStyle = `body { font-family: serif; line-height: 1.5; margin: 0 5%; }
+h1 { text-align: center; margin: 2em 0 1em; }
+p { text-indent: 1.5em; margin: 0 0 0.5em; }
+.cover { text-align: center; margin: 0; }
+.cover img { max-width: 100%; max-height: 100%; }
+`
+
+var epubTemplates = template.Must(template.New("epub").Funcs(template.FuncMap{"xml": epubEscape, "epubChapterPath": epubChapterPath}).Parse(`
+{{- define "package" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="{{xml .Language}}">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">{{xml .Identifier}}</dc:identifier>
+    <dc:title>{{xml .Title}}</dc:title>
+    <dc:creator>{{xml .Author}}</dc:creator>
+    <dc:language>{{xml .Language}}</dc:language>
+    {{- if .Description}}
+    <dc:description>{{xml .Description}}</dc:description>
+    {{- end}}
+    <meta property="dcterms:modified">{{.Modified.UTC.Format "2006-01-02T15:04:05Z"}}</meta>
+    <meta name="cover" content="cover-image"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+    <item id="cover-image" href="cover.svg" media-type="image/svg+xml" properties="cover-image"/>
+    <item id="cover" href="cover.xhtml" media-type="application/xhtml+xml"/>
+    {{- range $index, $chapter := .Chapters}}
+    <item id="chapter-{{$index}}" href="{{epubChapterPath $index}}" media-type="application/xhtml+xml"/>
+    {{- end}}
+  </manifest>
+  <spine>
+    <itemref idref="cover" linear="no"/>
+    <itemref idref="nav"/>
+    {{- range $index, $chapter := .Chapters}}
+    <itemref idref="chapter-{{$index}}"/>
+    {{- end}}
+  </spine>
+</package>
+{{end}}
+
+{{- define "head" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{xml .Language}}" lang="{{xml .Language}}">
+<head>
+  <meta charset="UTF-8"/>
+  <title>{{xml .Title}}</title>
+  <link rel="stylesheet" type="text/css" href="{{.Root}}style.css"/>
+</head>
+{{- end}}
+
+{{- define "nav" -}}
+{{template "head" .}}
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>{{xml .Title}}</h1>
+    <ol>
+      {{- range $index, $chapter := .Chapters}}
+      <li><a href="{{epubChapterPath $index}}">{{xml $chapter.Title}}</a></li>
+      {{- end}}
+    </ol>
+  </nav>
+  <nav epub:type="landmarks" hidden="hidden">
+    <ol>
+      <li><a epub:type="cover" href="cover.xhtml">Cover</a></li>
+      <li><a epub:type="toc" href="nav.xhtml">Contents</a></li>
+      {{- if .Chapters}}
+      <li><a epub:type="bodymatter" href="{{epubChapterPath 0}}">Start</a></li>
+      {{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+{{end}}
+
+{{- define "cover" -}}
+{{template "head" .}}
+<body>
+  <section epub:type="cover" class="cover">
+    <img src="cover.svg" alt="{{xml .Title}}"/>
+  </section>
+</body>
+</html>
+{{end}}
+
+{{- define "chapter" -}}
+{{template "head" .}}
+<body>
+  <section epub:type="chapter">
+    <h1>{{xml .Title}}</h1>
+    {{- if .HTML}}
+    {{.HTML}}
+    {{- else}}
+    {{- range .Paragraphs}}
+    <p>{{range $index, $line := .}}{{if $index}}<br/>{{end}}{{xml $line}}{{end}}</p>
+    {{- end}}
+    {{- end}}
+  </section>
+</body>
+</html>
+{{end}}
+
+{{- define "coverImage" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.1" viewBox="0 0 600 900" width="600" height="900">
+  <rect width="600" height="900" fill="#2b3a4a"/>
+  <rect x="30" y="30" width="540" height="840" fill="none" stroke="#d8c9a3" stroke-width="4"/>
+  <text x="300" y="{{.TitleY}}" fill="#f5efe0" font-family="serif" font-size="48" text-anchor="middle">
+    {{- range $index, $line := .TitleLines}}<tspan x="300" dy="{{if $index}}60{{else}}0{{end}}">{{xml $line}}</tspan>{{end -}}
+  </text>
+  <text x="300" y="780" fill="#d8c9a3" font-family="serif" font-size="32" text-anchor="middle">{{xml .Author}}</text>
+</svg>
+{{end}}
+`))
+
+// write the EPUB of the book to w, entry by entry
+func (service *EpubService) Write(w io.Writer, book EpubBook) error {
+	if book.Language == "" {
+		book.Language = "en"
+	}
+	if book.Modified.IsZero() {
+		book.Modified = time.Now()
+	}
+	archive := zip.NewWriter(w)
+	// the mimetype must come first & uncompressed
+	mimetype, err := archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+	if err := writeZipEntry(archive, "META-INF/container.xml", epubContainer); err != nil {
+		return err
+	}
+	if err := writeZipEntry(archive, "OEBPS/style.css", epubStyle); err != nil {
+		return err
+	}
+	titleLines := wrapText(book.Title, 18, 6)
+	err = executeZipEntry(archive, "OEBPS/cover.svg", "coverImage", map[string]interface{}{
+		"TitleLines": titleLines,
+		"TitleY":     420 - 30*(len(titleLines)-1),
+		"Author":     book.Author,
+	})
+	if err != nil {
+		return err
+	}
+	page := func(title string, root string) map[string]interface{} {
+		return map[string]interface{}{"Language": book.Language, "Title": title, "Root": root}
+	}
+	cover := page(book.Title, "")
+	if err := executeZipEntry(archive, "OEBPS/cover.xhtml", "cover", cover); err != nil {
+		return err
+	}
+	for index, chapter := range book.Chapters {
+		data := page(chapter.Title, "../")
+		data["Paragraphs"] = textParagraphs(chapter.Text)
+		data["HTML"] = chapter.HTML
+		if err := executeZipEntry(archive, "OEBPS/"+epubChapterPath(index), "chapter", data); err != nil {
+			return err
+		}
+	}
+	nav := page(book.Title, "")
+	nav["Chapters"] = book.Chapters
+	if err := executeZipEntry(archive, "OEBPS/nav.xhtml", "nav", nav); err != nil {
+		return err
+	}
+	if err := executeZipEntry(archive, "OEBPS/content.opf", "package", book); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func epubChapterPath(index int) string {
+	return fmt.Sprintf("chapters/chapter-%04d.xhtml", index+1)
+}
+
+func writeZipEntry(archive *zip.Writer, name string, content string) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(entry, content)
+	return err
+}
+
+func executeZipEntry(archive *zip.Writer, name string, template string, data interface{}) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	return epubTemplates.ExecuteTemplate(entry, template, data)
+}
+
+// escape the text for XML, dropping the characters XML can't hold
+func epubEscape(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (!unicode.IsControl(r) && r != unicode.ReplacementChar) {
+			return r
+		}
+		return -1
+	}, text)
+	return html.EscapeString(text)
+}
+
+// paragraphs of a plain text, each split into its lines
+func textParagraphs(text string) [][]string {
+	paragraphs := [][]string{}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for _, block := range strings.Split(text, "\n\n") {
+		block = strings.TrimSpace(block)
+		if block == "" {
+			continue
+		}
+		lines := strings.Split(block, "\n")
+		for index := range lines {
+			lines[index] = strings.TrimSpace(lines[index])
+		}
+		paragraphs = append(paragraphs, lines)
+	}
+	return paragraphs
+}
+
+// split the text in lines of about width characters, the last line is truncated past maxLines
+func wrapText(text string, width int, maxLines int) []string {
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len([]rune(line))+1+len([]rune(word)) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] += "…"
+	}
+	return lines
+}
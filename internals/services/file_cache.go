@@ -0,0 +1,79 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// keeps generated files on disk, one version per name: storing a new version removes the other ones.
+// the versions are expected to change with whatever the file is generated from. Files are handed out
+// open, so that they stay readable when a concurrent Put removes them. A version removed by a Put that
+// finished late is merely generated again by the next one asking for it
+type FileCacheService struct {
+	Directory string
+}
+
+func NewFileCacheService(directory string) (*FileCacheService, error) {
+	if directory == "" {
+		directory = "cache"
+	}
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileCacheService{Directory: directory}, nil
+}
+
+// the cached version of the file, found is false when it wasn't generated yet. The caller closes it
+func (service *FileCacheService) Open(name string, version string) (*os.File, bool, error) {
+	file, err := os.Open(service.path(name, version))
+	switch {
+	case err == nil:
+		return file, true, nil
+	case errors.Is(err, os.ErrNotExist):
+		return nil, false, nil
+	default:
+		return nil, false, err
+	}
+}
+
+// generate the version of the file & return it open for reading, the caller closes it.
+// the file only shows up once fully written, concurrent generations of the same version are fine, the last one wins
+func (service *FileCacheService) Put(name string, version string, generate func(io.Writer) error) (*os.File, error) {
+	directory := filepath.Join(service.Directory, name)
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(directory, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	if err := generate(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := os.Rename(file.Name(), service.path(name, version)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return file, nil
+	}
+	for _, entry := range entries {
+		if entry.Name() != version && entry.Name()[0] != '.' {
+			os.Remove(filepath.Join(directory, entry.Name()))
+		}
+	}
+	return file, nil
+}
+
+func (service *FileCacheService) path(name string, version string) string {
+	return filepath.Join(service.Directory, name, version)
+}
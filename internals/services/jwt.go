@@ -18,6 +18,7 @@ type JwtClaims struct {
 }
 
 type JwtSignOption struct {
+	ID                 string // `jti` claim, optional
 	Secret             string
 	ExpirationDuration time.Duration
 	SigningMethod      jwt.SigningMethod
@@ -33,6 +34,8 @@ func (j JWTService) signToken(claims interface{}, option *JwtSignOption) (Signed
 	token := jwt.NewWithClaims(option.SigningMethod, JwtClaims{
 		Claims: claims,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        option.ID,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 		},
 	})
@@ -43,23 +46,26 @@ func (j JWTService) signToken(claims interface{}, option *JwtSignOption) (Signed
 	}, err
 }
 
-func (j JWTService) SignAccessToken(claims interface{}) (SignedJwtResult, error) {
+// the token id is the `jti` of the session this token belongs to
+func (j JWTService) SignAccessToken(claims interface{}, tokenId string) (SignedJwtResult, error) {
 	secret := viper.GetViper().GetString("jwt.access_secret")
 	expirationDuration := viper.GetViper().GetDuration("jwt.access_expiration_duration")
 
 	return j.signToken(claims, &JwtSignOption{
+		ID:                 tokenId,
 		Secret:             secret,
 		ExpirationDuration: expirationDuration,
 		SigningMethod:      jwt.SigningMethodHS256,
 	})
 }
 
-// unused
-func (j JWTService) SignRefreshToken(claims interface{}) (SignedJwtResult, error) {
+// the token id is what gets persisted in refresh_tokens, so it has to be unique per token
+func (j JWTService) SignRefreshToken(claims interface{}, tokenId string) (SignedJwtResult, error) {
 	secret := viper.GetViper().GetString("jwt.refresh_secret")
 	expirationDuration := viper.GetViper().GetDuration("jwt.refresh_expiration_duration")
 
 	return j.signToken(claims, &JwtSignOption{
+		ID:                 tokenId,
 		Secret:             secret,
 		ExpirationDuration: expirationDuration,
 		SigningMethod:      jwt.SigningMethodHS256,
@@ -75,9 +81,21 @@ func (j JWTService) RetrieveUserIdFromContext(c echo.Context) (int, error) {
 	return userId, nil
 }
 
+func (j JWTService) RetrieveSessionFromContext(c echo.Context) (string, error) {
+	jti, ok := c.Get("session").(string)
+	if !ok || jti == "" {
+		return "", errors.New("invalid server context")
+	}
+
+	return jti, nil
+}
+
 func (j JWTService) verifyToken(tokenString string, secret string) (jwt.MapClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(t *jwt.Token) (interface{}, error) {
-		return secret, nil
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(secret), nil
 	})
 	if err != nil {
 		return nil, err
@@ -89,12 +107,11 @@ func (j JWTService) verifyToken(tokenString string, secret string) (jwt.MapClaim
 }
 
 func (j JWTService) VerifyAccessToken(tokenString string) (jwt.MapClaims, error) {
-	secret := viper.GetViper().GetString("jwt.accessSecret")
+	secret := viper.GetViper().GetString("jwt.access_secret")
 	return j.verifyToken(tokenString, secret)
 }
 
-// unused
 func (j JWTService) VerifyRefreshToken(tokenString string) (jwt.MapClaims, error) {
-	secret := viper.GetViper().GetString("jwt.refreshSecret")
+	secret := viper.GetViper().GetString("jwt.refresh_secret")
 	return j.verifyToken(tokenString, secret)
 }
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates/mail
+var mailTemplateFS embed.FS
+
+// the mails every locale has a translation of
+const (
+	MailTemplateVerification  = "verification"
+	MailTemplatePasswordReset = "password_reset"
+	MailTemplateInvitation    = "invitation"
+	MailTemplateNotification  = "notification"
+)
+
+var MailTemplates = []string{MailTemplateVerification, MailTemplatePasswordReset, MailTemplateInvitation, MailTemplateNotification}
+
+// what the mail templates are rendered with, each template only uses some of the fields
+type MailData struct {
+	Locale   string
+	Username string
+	Link     string
+	// notification & invitation mails
+	Type      string
+	Message   string
+	Actor     string
+	BookTitle string
+}
+
+// translations of the mails, from templates/mail/<locale>/<template>.{txt,html}.
+// the text file defines the `subject` & `text` blocks, the html one the `content` block
+// rendered inside templates/mail/layout.html
+type MailTemplateService struct {
+	Sender        string
+	FrontendURL   string
+	DefaultLocale string
+	text          map[string]*texttemplate.Template
+	html          map[string]*htmltemplate.Template
+}
+
+type MailTemplateConfig struct {
+	Sender        string
+	FrontendURL   string
+	DefaultLocale string
+}
+
+func NewMailTemplateService(config MailTemplateConfig) (*MailTemplateService, error) {
+	if config.Sender == "" {
+		config.Sender = "no-reply@novelism.com"
+	}
+	if config.DefaultLocale == "" {
+		config.DefaultLocale = "en"
+	}
+	service := &MailTemplateService{
+		Sender:        config.Sender,
+		FrontendURL:   strings.TrimSuffix(config.FrontendURL, "/"),
+		DefaultLocale: config.DefaultLocale,
+		text:          map[string]*texttemplate.Template{},
+		html:          map[string]*htmltemplate.Template{},
+	}
+	locales, err := fs.ReadDir(mailTemplateFS, "templates/mail")
+	if err != nil {
+		return nil, err
+	}
+	for _, locale := range locales {
+		if !locale.IsDir() {
+			continue
+		}
+		for _, name := range MailTemplates {
+			key := path.Join(locale.Name(), name)
+			text, err := texttemplate.ParseFS(mailTemplateFS, path.Join("templates/mail", key+".txt"))
+			if err != nil {
+				return nil, err
+			}
+			html, err := htmltemplate.New(name).Funcs(htmltemplate.FuncMap{"button": mailButton}).
+				ParseFS(mailTemplateFS, "templates/mail/layout.html", path.Join("templates/mail", key+".html"))
+			if err != nil {
+				return nil, err
+			}
+			service.text[key] = text
+			service.html[key] = html
+		}
+	}
+	if !service.Supports(service.DefaultLocale) {
+		return nil, fmt.Errorf("no mail templates for the default locale %q", service.DefaultLocale)
+	}
+	return service, nil
+}
+
+// whether the mails have a translation in the locale
+func (service *MailTemplateService) Supports(locale string) bool {
+	_, ok := service.text[path.Join(locale, MailTemplateVerification)]
+	return locale != "" && ok
+}
+
+// the locales the mails are translated in
+func (service *MailTemplateService) Locales() []string {
+	locales := []string{}
+	for key := range service.text {
+		if locale, name := path.Split(key); name == MailTemplateVerification {
+			locales = append(locales, strings.TrimSuffix(locale, "/"))
+		}
+	}
+	return locales
+}
+
+// the first supported locale of an Accept-Language header, the default locale when there is none
+func (service *MailTemplateService) Negotiate(acceptLanguage string) string {
+	for _, tag := range strings.Split(acceptLanguage, ",") {
+		language, _, _ := strings.Cut(strings.TrimSpace(tag), ";")
+		language, _, _ = strings.Cut(language, "-")
+		language = strings.ToLower(language)
+		if service.Supports(language) {
+			return language
+		}
+	}
+	return service.DefaultLocale
+}
+
+// absolute link to a page of the frontend
+func (service *MailTemplateService) Link(pagePath string, query url.Values) string {
+	link := service.FrontendURL + pagePath
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link
+}
+
+// the mail of the template sent to `to`, in their locale when there is a translation for it
+func (service *MailTemplateService) Render(name string, locale string, to string, data MailData) (*Mail, error) {
+	if !service.Supports(locale) {
+		locale = service.DefaultLocale
+	}
+	data.Locale = locale
+	key := path.Join(locale, name)
+	text, ok := service.text[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown mail template %q", name)
+	}
+	subject := new(bytes.Buffer)
+	if err := text.ExecuteTemplate(subject, "subject", data); err != nil {
+		return nil, err
+	}
+	content := new(bytes.Buffer)
+	if err := text.ExecuteTemplate(content, "text", data); err != nil {
+		return nil, err
+	}
+	html := new(bytes.Buffer)
+	if err := service.html[key].ExecuteTemplate(html, "layout", data); err != nil {
+		return nil, err
+	}
+	return &Mail{
+		From:    service.Sender,
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Content: strings.TrimSpace(content.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+type mailLink struct {
+	URL   string
+	Label string
+}
+
+// {{template "link" (button .Link "label")}}
+func mailButton(url string, label string) mailLink {
+	return mailLink{URL: url, Label: label}
+}
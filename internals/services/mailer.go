@@ -1,15 +1,41 @@
 package services
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/wneessen/go-mail"
 )
 
-// currently doens't work
-type MailerService struct {
-	Client *mail.Client
-	Config MailerSMTPConfig
+// sends mails, the transport is picked by NewMailer from the `[mailer]` config
+type Mailer interface {
+	Perform(input *Mail) error
+}
+
+// Content is the plain text body, HTML an optional alternative to it
+type Mail struct {
+	From    string
+	To      string
+	Subject string
+	Content string
+	HTML    string
+}
+
+const (
+	MailerTransportSMTP   = "smtp"
+	MailerTransportFile   = "file"
+	MailerTransportMemory = "memory"
+)
+
+type MailerConfig struct {
+	// smtp (default), file or memory
+	Transport string
+	// where the file transport writes the mails
+	Directory string
+	SMTP      MailerSMTPConfig
 }
 
 type MailerSMTPConfig struct {
@@ -20,14 +46,45 @@ type MailerSMTPConfig struct {
 	Timeout  time.Duration
 }
 
-type Mail struct {
-	From    string
-	To      string
-	Subject string
-	Content string
+func NewMailer(config MailerConfig) (Mailer, error) {
+	switch config.Transport {
+	case "", MailerTransportSMTP:
+		return NewSMTPMailer(config.SMTP)
+	case MailerTransportFile:
+		return NewFileMailer(config.Directory)
+	case MailerTransportMemory:
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer transport %q", config.Transport)
+	}
+}
+
+// the message as sent by the transports writing real mails
+func newMessage(input *Mail) (*mail.Msg, error) {
+	message := mail.NewMsg()
+	if err := message.From(input.From); err != nil {
+		return nil, err
+	}
+	if err := message.To(input.To); err != nil {
+		return nil, err
+	}
+	message.Subject(input.Subject)
+	message.SetDate()
+	message.SetMessageID()
+	message.SetBodyString(mail.TypeTextPlain, input.Content)
+	if input.HTML != "" {
+		message.AddAlternativeString(mail.TypeTextHTML, input.HTML)
+	}
+	return message, nil
 }
 
-func NewMailerService(config MailerSMTPConfig) (*MailerService, error) {
+// sends the mails through an SMTP relay
+type SMTPMailer struct {
+	Client *mail.Client
+	Config MailerSMTPConfig
+}
+
+func NewSMTPMailer(config MailerSMTPConfig) (*SMTPMailer, error) {
 	var zeroDuration time.Duration
 	if config.Timeout == zeroDuration {
 		config.Timeout = 3 * time.Second
@@ -43,7 +100,7 @@ func NewMailerService(config MailerSMTPConfig) (*MailerService, error) {
 	if err != nil {
 		return nil, err
 	}
-	mailer := &MailerService{
+	mailer := &SMTPMailer{
 		Config: config,
 		Client: client,
 	}
@@ -51,18 +108,66 @@ func NewMailerService(config MailerSMTPConfig) (*MailerService, error) {
 }
 
 // send mail
-func (m MailerService) Perform(input *Mail) error {
-	message := mail.NewMsg()
-	err := message.From(input.From)
+func (m SMTPMailer) Perform(input *Mail) error {
+	message, err := newMessage(input)
 	if err != nil {
 		return err
 	}
-	err = message.To(input.To)
+	return m.Client.DialAndSend(message)
+}
+
+// writes every mail to an .eml file of a directory instead of sending it, for development.
+// the files open in any mail client
+type FileMailer struct {
+	Directory string
+}
+
+func NewFileMailer(directory string) (*FileMailer, error) {
+	if directory == "" {
+		directory = "mails"
+	}
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{Directory: directory}, nil
+}
+
+func (m FileMailer) Perform(input *Mail) error {
+	message, err := newMessage(input)
 	if err != nil {
 		return err
 	}
-	message.Subject(input.Subject)
-	message.SetBodyString(mail.TypeTextPlain, input.Content)
+	cryptoService := NewCryptoService()
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), cryptoService.GenerateSecureToken(4))
+	return message.WriteToFile(filepath.Join(m.Directory, name))
+}
 
-	return m.Client.DialAndSend(message)
+// keeps the mails in memory instead of sending them, for tests & CI
+type MemoryMailer struct {
+	mu    sync.Mutex
+	mails []Mail
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Perform(input *Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mails = append(m.mails, *input)
+	return nil
+}
+
+// every mail performed so far, oldest first
+func (m *MemoryMailer) Sent() []Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Mail(nil), m.mails...)
+}
+
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mails = nil
 }
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"archive/zip"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// the whole text of a book as written by its authors, drafts included
+type Manuscript struct {
+	Title       string
+	Description string
+	Author      string
+	Chapters    []ManuscriptChapter
+}
+
+type ManuscriptChapter struct {
+	ChapterNO int64
+	Title     string
+	Text      string
+	Format    string // see ContentFormats
+}
+
+const (
+	ManuscriptFormatMarkdown = "md"
+	ManuscriptFormatText     = "txt"
+)
+
+var ManuscriptFormats = []string{ManuscriptFormatMarkdown, ManuscriptFormatText}
+
+type ManuscriptService struct{}
+
+func NewManuscriptService() *ManuscriptService {
+	return &ManuscriptService{}
+}
+
+// the book in a single Markdown file, 1 level 2 heading per chapter
+func (service *ManuscriptService) WriteMarkdown(w io.Writer, manuscript Manuscript) error {
+	header := new(strings.Builder)
+	fmt.Fprintf(header, "# %s\n\n", singleLine(manuscript.Title))
+	if manuscript.Author != "" {
+		fmt.Fprintf(header, "*by %s*\n\n", singleLine(manuscript.Author))
+	}
+	if description := strings.TrimSpace(manuscript.Description); description != "" {
+		fmt.Fprintf(header, "%s\n\n", description)
+	}
+	if _, err := io.WriteString(w, header.String()); err != nil {
+		return err
+	}
+	for _, chapter := range manuscript.Chapters {
+		if _, err := io.WriteString(w, service.chapterMarkdown(chapter, 2)+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a ZIP of 1 file per chapter in the format, named after the chapter number & title
+func (service *ManuscriptService) WriteZip(w io.Writer, manuscript Manuscript, format string) error {
+	archive := zip.NewWriter(w)
+	for _, chapter := range manuscript.Chapters {
+		name := fmt.Sprintf("%04d", chapter.ChapterNO)
+		if slug := utils.Slugify(chapter.Title); slug != "" {
+			name += "-" + slug
+		}
+		var content string
+		switch format {
+		case ManuscriptFormatMarkdown:
+			content = service.chapterMarkdown(chapter, 1)
+		case ManuscriptFormatText:
+			content = service.chapterText(chapter)
+		default:
+			return fmt.Errorf("unknown manuscript format %q", format)
+		}
+		if err := writeZipEntry(archive, name+"."+format, content); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func (service *ManuscriptService) chapterMarkdown(chapter ManuscriptChapter, level int) string {
+	text := chapter.Text
+	switch chapter.Format {
+	case ContentFormatMarkdown:
+	case ContentFormatHTML:
+		// the formatting is not kept, as when importing
+		text = markdownParagraphs(htmlText(text))
+	default:
+		text = markdownParagraphs(text)
+	}
+	return fmt.Sprintf("%s %s\n\n%s", strings.Repeat("#", level), singleLine(chapter.Title), chapterBody(text))
+}
+
+// the title underlined, as plain text files usually do
+func (service *ManuscriptService) chapterText(chapter ManuscriptChapter) string {
+	text := chapter.Text
+	switch chapter.Format {
+	case ContentFormatMarkdown:
+		text = htmlText(renderMarkdown(text))
+	case ContentFormatHTML:
+		text = htmlText(text)
+	}
+	title := singleLine(chapter.Title)
+	return fmt.Sprintf("%s\n%s\n\n%s", title, strings.Repeat("=", max(utf8.RuneCountInString(title), 3)), chapterBody(text))
+}
+
+// elements whose content is a paragraph of its own
+var htmlTextBlockElements = []atom.Atom{
+	atom.P, atom.Div, atom.Section, atom.Article, atom.Blockquote, atom.Pre, atom.Hr,
+	atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+	atom.Ul, atom.Ol, atom.Li, atom.Dl, atom.Dt, atom.Dd,
+	atom.Table, atom.Caption, atom.Tr, atom.Figure, atom.Figcaption,
+}
+
+// the text of the HTML, paragraphs separated by blank lines. Line breaks are kept, the content of
+// the elements Sanitize removes is left out
+func htmlText(source string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(source))
+	paragraphs := []string{}
+	paragraph := new(strings.Builder)
+	// the element whose content is being left out, with how many of it are open
+	dropped, droppedDepth := "", 0
+	// whitespace is kept as is in preformatted text
+	preformatted := 0
+	flush := func() {
+		lines := strings.Split(paragraph.String(), "\n")
+		for index := range lines {
+			lines[index] = strings.TrimSpace(lines[index])
+		}
+		paragraph.Reset()
+		if text := strings.TrimSpace(strings.Join(lines, "\n")); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	}
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			flush()
+			return strings.Join(paragraphs, "\n\n")
+		case html.TextToken:
+			if dropped != "" {
+				continue
+			}
+			text := strings.ReplaceAll(string(tokenizer.Text()), "\r\n", "\n")
+			if preformatted == 0 {
+				text = collapseSpaces(text)
+			}
+			paragraph.WriteString(text)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if dropped != "" {
+				if token.Data == dropped && tokenType == html.StartTagToken {
+					droppedDepth++
+				}
+				continue
+			}
+			switch {
+			case utils.IsItemInCollection(token.DataAtom, sanitizedDroppedElements):
+				if tokenType == html.StartTagToken {
+					dropped, droppedDepth = token.Data, 1
+				}
+			case token.DataAtom == atom.Br:
+				paragraph.WriteString("\n")
+			case utils.IsItemInCollection(token.DataAtom, htmlTextBlockElements):
+				flush()
+				if token.DataAtom == atom.Pre && tokenType == html.StartTagToken {
+					preformatted++
+				}
+			}
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if dropped != "" {
+				if token.Data == dropped {
+					if droppedDepth--; droppedDepth == 0 {
+						dropped = ""
+					}
+				}
+				continue
+			}
+			if utils.IsItemInCollection(token.DataAtom, htmlTextBlockElements) {
+				flush()
+				if token.DataAtom == atom.Pre && preformatted > 0 {
+					preformatted--
+				}
+			}
+		}
+	}
+}
+
+var (
+	markdownEscaper = strings.NewReplacer(
+		`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+		`<`, `\<`, `>`, `\>`, `&`, `\&`, `~`, `\~`, `|`, `\|`,
+	)
+	// what starts a heading, a list item or a setext underline at the start of a line
+	markdownLineStart = regexp.MustCompile(`^(?:[#+=-]|[0-9]{1,9}[.)])`)
+)
+
+// the plain text as Markdown that renders to the same text, lines of a paragraph are kept with hard line breaks
+func markdownParagraphs(text string) string {
+	paragraphs := []string{}
+	for _, lines := range textParagraphs(text) {
+		for index, line := range lines {
+			line = markdownEscaper.Replace(line)
+			lines[index] = markdownLineStart.ReplaceAllStringFunc(line, func(marker string) string {
+				return marker[:len(marker)-1] + `\` + marker[len(marker)-1:]
+			})
+		}
+		paragraphs = append(paragraphs, strings.Join(lines, "\\\n"))
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// the text with normalized line endings & exactly 1 trailing newline
+func chapterBody(text string) string {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if text == "" {
+		return ""
+	}
+	return text + "\n"
+}
+
+func singleLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,555 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"gin_stuff/internals/utils"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// the files a manuscript can be imported from
+var ManuscriptImportExtensions = []string{".md", ".markdown", ".docx", ".epub"}
+
+// how much is decompressed from a .docx/.epub archive, all files together
+const maxManuscriptSize = 100 << 20
+
+var ErrorManuscriptTooLarge = errors.New("manuscript exceeds the maximum allowed size")
+
+// how much of a manuscript Parse reads before failing with ErrorManuscriptTooLarge, 0 for no limit
+type ManuscriptLimits struct {
+	Chapters int
+	// in characters
+	ChapterLength int
+}
+
+// fail once there are too many chapters or the last one is too long
+func (limits ManuscriptLimits) check(chapters []ManuscriptChapter) error {
+	if limits.Chapters > 0 && len(chapters) > limits.Chapters {
+		return fmt.Errorf("%w: more than %d chapters", ErrorManuscriptTooLarge, limits.Chapters)
+	}
+	if len(chapters) == 0 || limits.ChapterLength <= 0 {
+		return nil
+	}
+	chapter := chapters[len(chapters)-1]
+	if utf8.RuneCountInString(chapter.Text) > limits.ChapterLength {
+		return fmt.Errorf("%w: chapter %d \"%s\" is longer than %d characters", ErrorManuscriptTooLarge, chapter.ChapterNO, chapter.Title, limits.ChapterLength)
+	}
+	return nil
+}
+
+// a .docx/.epub archive, its files share a budget of maxManuscriptSize decompressed bytes
+type manuscriptArchive struct {
+	*zip.Reader
+	remaining int64
+}
+
+// the file of the archive, reading it past the remaining budget fails
+func (archive *manuscriptArchive) open(name string) (io.ReadCloser, error) {
+	file, err := archive.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return budgetedReader{ReadCloser: file, archive: archive}, nil
+}
+
+type budgetedReader struct {
+	io.ReadCloser
+	archive *manuscriptArchive
+}
+
+func (reader budgetedReader) Read(p []byte) (int, error) {
+	if reader.archive.remaining <= 0 {
+		return 0, fmt.Errorf("%w: more than %d MB once decompressed", ErrorManuscriptTooLarge, maxManuscriptSize>>20)
+	}
+	if int64(len(p)) > reader.archive.remaining {
+		p = p[:reader.archive.remaining]
+	}
+	n, err := reader.ReadCloser.Read(p)
+	reader.archive.remaining -= int64(n)
+	return n, err
+}
+
+// a part of a manuscript, a heading or some text
+type manuscriptBlock struct {
+	// level of the heading, 0 for text
+	level int
+	text  string
+}
+
+// read the manuscript in the file, split into chapters by headings (.md, .docx) or spine items (.epub).
+// the book title is the one of the document when it has one, the name of the file otherwise
+func (service *ManuscriptService) Parse(filename string, file io.ReaderAt, size int64, limits ManuscriptLimits) (*Manuscript, error) {
+	extension := strings.ToLower(filepath.Ext(filename))
+	fallbackTitle := strings.TrimSpace(strings.NewReplacer("_", " ", "-", " ").Replace(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))))
+	switch extension {
+	case ".md", ".markdown":
+		content, err := io.ReadAll(io.NewSectionReader(file, 0, size))
+		if err != nil {
+			return nil, err
+		}
+		return splitManuscript(parseMarkdown(string(content)), fallbackTitle, true, limits)
+	case ".docx":
+		archive, err := zip.NewReader(file, size)
+		if err != nil {
+			return nil, err
+		}
+		blocks, err := parseDocx(&manuscriptArchive{Reader: archive, remaining: maxManuscriptSize})
+		if err != nil {
+			return nil, err
+		}
+		return splitManuscript(blocks, fallbackTitle, false, limits)
+	case ".epub":
+		archive, err := zip.NewReader(file, size)
+		if err != nil {
+			return nil, err
+		}
+		return parseEpub(&manuscriptArchive{Reader: archive, remaining: maxManuscriptSize}, fallbackTitle, limits)
+	default:
+		return nil, utils.ErrorUnsupportedFile
+	}
+}
+
+// the chapters are the headings of the highest level, unless the first heading is the only one of that level:
+// it is then the title of the book & the chapters are the headings of the next level.
+// The text before the first chapter is the description. With markdown, the lower headings keep their `#` in the text
+// & the chapters are in the markdown format
+func splitManuscript(blocks []manuscriptBlock, fallbackTitle string, markdown bool, limits ManuscriptLimits) (*Manuscript, error) {
+	manuscript := &Manuscript{Title: fallbackTitle, Chapters: []ManuscriptChapter{}}
+	format := ContentFormatPlain
+	if markdown {
+		format = ContentFormatMarkdown
+	}
+	counts := map[int]int{}
+	levels := []int{}
+	for _, block := range blocks {
+		if block.level > 0 {
+			if counts[block.level] == 0 {
+				levels = append(levels, block.level)
+			}
+			counts[block.level]++
+		}
+	}
+	if len(levels) == 0 {
+		text := joinBlocks(blocks)
+		if text != "" {
+			manuscript.Chapters = append(manuscript.Chapters, ManuscriptChapter{ChapterNO: 1, Title: fallbackTitle, Text: text, Format: format})
+		}
+		return manuscript, limits.check(manuscript.Chapters)
+	}
+	highest := minOf(levels)
+	chapterLevel := highest
+	titleIndex := -1
+	for index, block := range blocks {
+		if block.level == 0 {
+			continue
+		}
+		if block.level == highest && counts[highest] == 1 && len(levels) > 1 {
+			titleIndex = index
+			chapterLevel = minOf(levels[1:])
+		}
+		break
+	}
+	if titleIndex >= 0 {
+		manuscript.Title = blocks[titleIndex].text
+	}
+	var chapter *ManuscriptChapter
+	body := []manuscriptBlock{}
+	flush := func() error {
+		if chapter != nil {
+			chapter.Text = joinBlocks(body)
+			manuscript.Chapters = append(manuscript.Chapters, *chapter)
+		} else {
+			manuscript.Description = joinBlocks(body)
+		}
+		body = []manuscriptBlock{}
+		return limits.check(manuscript.Chapters)
+	}
+	for index, block := range blocks {
+		switch {
+		case index == titleIndex:
+		case block.level == chapterLevel:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			chapter = &ManuscriptChapter{ChapterNO: int64(len(manuscript.Chapters) + 1), Title: block.text, Format: format}
+		case block.level > 0 && markdown:
+			// lower headings stay in the text of the chapter
+			body = append(body, manuscriptBlock{text: strings.Repeat("#", max(block.level-chapterLevel+1, 2)) + " " + block.text})
+		case block.level > 0:
+			body = append(body, manuscriptBlock{text: block.text})
+		default:
+			body = append(body, block)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return manuscript, nil
+}
+
+func minOf(levels []int) int {
+	lowest := levels[0]
+	for _, level := range levels {
+		lowest = min(lowest, level)
+	}
+	return lowest
+}
+
+func joinBlocks(blocks []manuscriptBlock) string {
+	texts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if text := strings.Trim(block.text, "\n"); strings.TrimSpace(text) != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+var (
+	markdownATXHeading = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	markdownSetextLine = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	markdownFence      = regexp.MustCompile("^ {0,3}(```|~~~)")
+)
+
+// ATX (# Title) & setext (Title\n=====) headings, anything in fenced code blocks is text
+func parseMarkdown(content string) []manuscriptBlock {
+	content = strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\r", "\n")
+	content = strings.TrimPrefix(content, "\ufeff")
+	blocks := []manuscriptBlock{}
+	lines := []string{}
+	flush := func() {
+		if len(lines) > 0 {
+			blocks = append(blocks, manuscriptBlock{text: strings.Join(lines, "\n")})
+			lines = []string{}
+		}
+	}
+	fence := ""
+	for _, line := range strings.Split(content, "\n") {
+		if fence != "" {
+			lines = append(lines, line)
+			if strings.HasPrefix(strings.TrimSpace(line), fence) {
+				fence = ""
+			}
+			continue
+		}
+		if match := markdownFence.FindStringSubmatch(line); match != nil {
+			fence = match[1]
+			lines = append(lines, line)
+			continue
+		}
+		if match := markdownATXHeading.FindStringSubmatch(line); match != nil {
+			flush()
+			blocks = append(blocks, manuscriptBlock{level: len(match[1]), text: strings.TrimSpace(match[2])})
+			continue
+		}
+		// the underline of a setext heading turns the single line of text above it into a heading
+		if match := markdownSetextLine.FindStringSubmatch(line); match != nil && len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" &&
+			(len(lines) == 1 || strings.TrimSpace(lines[len(lines)-2]) == "") {
+			title := strings.TrimSpace(lines[len(lines)-1])
+			lines = lines[:len(lines)-1]
+			flush()
+			level := 1
+			if match[1][0] == '-' {
+				level = 2
+			}
+			blocks = append(blocks, manuscriptBlock{level: level, text: title})
+			continue
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return blocks
+}
+
+var docxHeadingStyle = regexp.MustCompile(`^heading ?([1-9])$`)
+
+// 1 block per paragraph of word/document.xml, the heading levels come from the paragraph styles.
+// the Title style is above Heading 1
+func parseDocx(archive *manuscriptArchive) ([]manuscriptBlock, error) {
+	styleNames := map[string]string{}
+	if styles, err := archive.open("word/styles.xml"); err == nil {
+		decoder := xml.NewDecoder(styles)
+		id := ""
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				break
+			}
+			if element, ok := token.(xml.StartElement); ok {
+				switch element.Name.Local {
+				case "style":
+					id = xmlAttr(element, "styleId")
+				case "name":
+					if id != "" {
+						styleNames[id] = xmlAttr(element, "val")
+					}
+				}
+			}
+		}
+		styles.Close()
+	}
+	document, err := archive.open("word/document.xml")
+	if err != nil {
+		return nil, fmt.Errorf("not a word document: %w", err)
+	}
+	defer document.Close()
+
+	blocks := []manuscriptBlock{}
+	decoder := xml.NewDecoder(document)
+	paragraph := new(strings.Builder)
+	style := ""
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "p":
+				paragraph.Reset()
+				style = ""
+			case "pStyle":
+				style = xmlAttr(element, "val")
+			case "t":
+				inText = true
+			case "tab":
+				paragraph.WriteString("\t")
+			case "br", "cr":
+				paragraph.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(element)
+			}
+		case xml.EndElement:
+			switch element.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				name := strings.ToLower(style)
+				if styleName, ok := styleNames[style]; ok {
+					name = strings.ToLower(styleName)
+				}
+				block := manuscriptBlock{text: strings.TrimSpace(paragraph.String())}
+				if name == "title" {
+					block.level = 1
+				} else if match := docxHeadingStyle.FindStringSubmatch(name); match != nil {
+					level, _ := strconv.Atoi(match[1])
+					block.level = level + 1
+				}
+				if block.text != "" {
+					blocks = append(blocks, block)
+				}
+			}
+		}
+	}
+	return blocks, nil
+}
+
+type epubItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+type epubPackage struct {
+	Metadata struct {
+		Titles       []string `xml:"title"`
+		Descriptions []string `xml:"description"`
+	} `xml:"metadata"`
+	Manifest []epubItem `xml:"manifest>item"`
+	Spine    []struct {
+		IDRef  string `xml:"idref,attr"`
+		Linear string `xml:"linear,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// 1 chapter per document of the spine, titled after its first heading. Documents without any text,
+// like cover pages, are skipped & so are the documents already in the spine
+func parseEpub(archive *manuscriptArchive, fallbackTitle string, limits ManuscriptLimits) (*Manuscript, error) {
+	container := struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}{}
+	if err := readZipXML(archive, "META-INF/container.xml", &container); err != nil {
+		return nil, fmt.Errorf("not an epub: %w", err)
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, fmt.Errorf("not an epub: no package document")
+	}
+	packagePath := container.Rootfiles[0].FullPath
+	pkg := epubPackage{}
+	if err := readZipXML(archive, packagePath, &pkg); err != nil {
+		return nil, err
+	}
+	manuscript := &Manuscript{Title: fallbackTitle, Chapters: []ManuscriptChapter{}}
+	if len(pkg.Metadata.Titles) > 0 && strings.TrimSpace(pkg.Metadata.Titles[0]) != "" {
+		manuscript.Title = strings.TrimSpace(pkg.Metadata.Titles[0])
+	}
+	if len(pkg.Metadata.Descriptions) > 0 {
+		manuscript.Description = strings.TrimSpace(pkg.Metadata.Descriptions[0])
+	}
+	items := make(map[string]epubItem, len(pkg.Manifest))
+	for _, item := range pkg.Manifest {
+		items[item.ID] = item
+	}
+	parsed := map[string]bool{}
+	for _, itemref := range pkg.Spine {
+		item, ok := items[itemref.IDRef]
+		if !ok || parsed[item.ID] || itemref.Linear == "no" || item.MediaType != "application/xhtml+xml" || strings.Contains(item.Properties, "nav") {
+			continue
+		}
+		parsed[item.ID] = true
+		href, err := url.PathUnescape(strings.SplitN(item.Href, "#", 2)[0])
+		if err != nil {
+			return nil, err
+		}
+		document, err := archive.open(path.Join(path.Dir(packagePath), href))
+		if err != nil {
+			return nil, err
+		}
+		title, blocks, err := parseXHTML(document)
+		document.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", href, err)
+		}
+		text := joinBlocks(blocks)
+		if title == "" && text == "" {
+			continue
+		}
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", len(manuscript.Chapters)+1)
+		}
+		manuscript.Chapters = append(manuscript.Chapters, ManuscriptChapter{
+			ChapterNO: int64(len(manuscript.Chapters) + 1),
+			Title:     title,
+			Text:      text,
+			Format:    ContentFormatPlain,
+		})
+		if err := limits.check(manuscript.Chapters); err != nil {
+			return nil, err
+		}
+	}
+	return manuscript, nil
+}
+
+var xhtmlBlockElements = []string{"p", "div", "section", "article", "blockquote", "li", "tr", "pre", "h1", "h2", "h3", "h4", "h5", "h6", "hr"}
+
+// the first heading of the body & its text, 1 block per paragraph
+func parseXHTML(document io.Reader) (string, []manuscriptBlock, error) {
+	decoder := xml.NewDecoder(document)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	title := ""
+	blocks := []manuscriptBlock{}
+	paragraph := new(strings.Builder)
+	heading := false
+	skipped := 0
+	flush := func() {
+		lines := strings.Split(paragraph.String(), "\n")
+		for index := range lines {
+			lines[index] = strings.TrimSpace(lines[index])
+		}
+		text := strings.TrimSpace(strings.Join(lines, "\n"))
+		paragraph.Reset()
+		if text == "" {
+			return
+		}
+		if heading && title == "" {
+			title = text
+			return
+		}
+		blocks = append(blocks, manuscriptBlock{text: text})
+	}
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(element.Name.Local)
+			switch {
+			case name == "head" || name == "script" || name == "style":
+				skipped++
+			case name == "br":
+				paragraph.WriteString("\n")
+			case utils.IsItemInCollection(name, xhtmlBlockElements):
+				flush()
+				heading = len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6'
+			}
+		case xml.EndElement:
+			name := strings.ToLower(element.Name.Local)
+			switch {
+			case name == "head" || name == "script" || name == "style":
+				skipped--
+			case utils.IsItemInCollection(name, xhtmlBlockElements):
+				flush()
+				heading = false
+			}
+		case xml.CharData:
+			if skipped == 0 {
+				paragraph.WriteString(collapseSpaces(string(element)))
+			}
+		}
+	}
+	flush()
+	return title, blocks, nil
+}
+
+// whitespace runs become a single space, as browsers render them
+func collapseSpaces(text string) string {
+	collapsed := strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return ""
+	}
+	if collapsed == "" {
+		return " "
+	}
+	if first, _ := utf8.DecodeRuneInString(text); unicode.IsSpace(first) {
+		collapsed = " " + collapsed
+	}
+	if last, _ := utf8.DecodeLastRuneInString(text); unicode.IsSpace(last) {
+		collapsed += " "
+	}
+	return collapsed
+}
+
+func readZipXML(archive *manuscriptArchive, name string, destination interface{}) error {
+	file, err := archive.open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return xml.NewDecoder(file).Decode(destination)
+}
+
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
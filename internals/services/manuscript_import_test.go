@@ -0,0 +1,180 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestManuscriptServiceParseMarkdown(t *testing.T) {
+	source := "# The Book\n\nWhat it is about.\n\n## One\n\nFirst text.\n\n### Part\n\n```\n# not a heading\n```\n\nTwo\n---\n\nLast text."
+	manuscript, err := parseManuscript("the-book.md", []byte(source), ManuscriptLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manuscript.Title != "The Book" || manuscript.Description != "What it is about." {
+		t.Errorf("title %q & description %q", manuscript.Title, manuscript.Description)
+	}
+	expected := []ManuscriptChapter{
+		{ChapterNO: 1, Title: "One", Text: "First text.\n\n## Part\n\n```\n# not a heading\n```", Format: ContentFormatMarkdown},
+		{ChapterNO: 2, Title: "Two", Text: "Last text.", Format: ContentFormatMarkdown},
+	}
+	checkManuscriptChapters(t, manuscript, expected)
+}
+
+func TestManuscriptServiceParseMarkdownWithoutHeadings(t *testing.T) {
+	manuscript, err := parseManuscript("my_story.markdown", []byte("Once upon a time."), ManuscriptLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ManuscriptChapter{{ChapterNO: 1, Title: "my story", Text: "Once upon a time.", Format: ContentFormatMarkdown}}
+	checkManuscriptChapters(t, manuscript, expected)
+}
+
+func TestManuscriptServiceParseDocx(t *testing.T) {
+	paragraph := func(style string, text string) string {
+		return fmt.Sprintf(`<w:p><w:pPr><w:pStyle w:val="%s"/></w:pPr><w:r><w:t>%s</w:t></w:r></w:p>`, style, text)
+	}
+	document := `<w:document xmlns:w="w"><w:body>` +
+		paragraph("Title", "The Book") + paragraph("", "What it is about.") +
+		paragraph("H1", "One") + paragraph("", "First text.") + paragraph("Heading2", "Part") + paragraph("", "More text.") +
+		paragraph("H1", "Two") + `<w:p><w:r><w:t>Last</w:t><w:tab/><w:t>text.</w:t></w:r></w:p>` +
+		`</w:body></w:document>`
+	styles := `<w:styles xmlns:w="w"><w:style w:styleId="H1"><w:name w:val="heading 1"/></w:style></w:styles>`
+	file := zipFile(t, map[string]string{"word/document.xml": document, "word/styles.xml": styles})
+	manuscript, err := parseManuscript("book.docx", file, ManuscriptLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manuscript.Title != "The Book" || manuscript.Description != "What it is about." {
+		t.Errorf("title %q & description %q", manuscript.Title, manuscript.Description)
+	}
+	expected := []ManuscriptChapter{
+		{ChapterNO: 1, Title: "One", Text: "First text.\n\nPart\n\nMore text.", Format: ContentFormatPlain},
+		{ChapterNO: 2, Title: "Two", Text: "Last\ttext.", Format: ContentFormatPlain},
+	}
+	checkManuscriptChapters(t, manuscript, expected)
+}
+
+func TestManuscriptServiceParseEpub(t *testing.T) {
+	chapter := func(title string, text string) string {
+		return fmt.Sprintf(`<html><head><title>ignored</title></head><body><h1>%s</h1><p>%s</p></body></html>`, title, text)
+	}
+	// the first chapter is in the spine twice, the cover has no text & the nav is left out
+	files := epubFiles(`<itemref idref="cover"/><itemref idref="nav"/><itemref idref="one"/><itemref idref="two"/><itemref idref="one"/>`, map[string]string{
+		"cover": `<html><body><img src="cover.png"/></body></html>`,
+		"nav":   chapter("Contents", "One"),
+		"one":   chapter("One", "First <em>text</em>.<br/>Next line."),
+		"two":   chapter("Two &amp; more", "Last text."),
+	})
+	manuscript, err := parseManuscript("book.epub", zipFile(t, files), ManuscriptLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manuscript.Title != "The Book" || manuscript.Description != "What it is about." {
+		t.Errorf("title %q & description %q", manuscript.Title, manuscript.Description)
+	}
+	expected := []ManuscriptChapter{
+		{ChapterNO: 1, Title: "One", Text: "First text.\nNext line.", Format: ContentFormatPlain},
+		{ChapterNO: 2, Title: "Two & more", Text: "Last text.", Format: ContentFormatPlain},
+	}
+	checkManuscriptChapters(t, manuscript, expected)
+}
+
+func TestManuscriptServiceParseLimits(t *testing.T) {
+	markdown := []byte("## One\n\ntext\n\n## Two\n\n" + strings.Repeat("é", 101))
+	if _, err := parseManuscript("book.md", markdown, ManuscriptLimits{Chapters: 1}); !errors.Is(err, ErrorManuscriptTooLarge) {
+		t.Errorf("too many chapters gives %v", err)
+	}
+	if _, err := parseManuscript("book.md", markdown, ManuscriptLimits{ChapterLength: 100}); !errors.Is(err, ErrorManuscriptTooLarge) {
+		t.Errorf("a chapter too long gives %v", err)
+	}
+	if _, err := parseManuscript("book.md", markdown, ManuscriptLimits{Chapters: 2, ChapterLength: 101}); err != nil {
+		t.Errorf("a manuscript within the limits gives %v", err)
+	}
+
+	// the spine can't make the same documents count more than once
+	spine := strings.Repeat(`<itemref idref="one"/>`, 1000) + `<itemref idref="two"/>`
+	files := epubFiles(spine, map[string]string{
+		"one": "<html><body><p>text</p></body></html>",
+		"two": "<html><body><p>" + strings.Repeat("a", 101) + "</p></body></html>",
+	})
+	if _, err := parseManuscript("book.epub", zipFile(t, files), ManuscriptLimits{Chapters: 2, ChapterLength: 100}); !errors.Is(err, ErrorManuscriptTooLarge) {
+		t.Errorf("an epub chapter too long gives %v", err)
+	}
+	if _, err := parseManuscript("book.epub", zipFile(t, files), ManuscriptLimits{Chapters: 2, ChapterLength: 101}); err != nil {
+		t.Errorf("an epub within the limits gives %v", err)
+	}
+}
+
+// whatever the archive says, no more than maxManuscriptSize bytes are decompressed
+func TestManuscriptServiceParseArchiveSize(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compresses more than maxManuscriptSize bytes")
+	}
+	document := `<w:document xmlns:w="w"><w:body><w:p><w:r><w:t>` + strings.Repeat(" ", maxManuscriptSize) + `</w:t></w:r></w:p></w:body></w:document>`
+	file := zipFile(t, map[string]string{"word/document.xml": document})
+	if _, err := parseManuscript("book.docx", file, ManuscriptLimits{}); !errors.Is(err, ErrorManuscriptTooLarge) {
+		t.Errorf("a document too large gives %v", err)
+	}
+}
+
+func TestManuscriptServiceParseUnsupported(t *testing.T) {
+	if _, err := parseManuscript("book.pdf", []byte("%PDF"), ManuscriptLimits{}); err == nil {
+		t.Error("a pdf was parsed")
+	}
+}
+
+func parseManuscript(filename string, content []byte, limits ManuscriptLimits) (*Manuscript, error) {
+	return NewManuscriptService().Parse(filename, bytes.NewReader(content), int64(len(content)), limits)
+}
+
+func checkManuscriptChapters(t *testing.T, manuscript *Manuscript, expected []ManuscriptChapter) {
+	t.Helper()
+	if fmt.Sprintf("%q", manuscript.Chapters) != fmt.Sprintf("%q", expected) {
+		t.Errorf("chapters are\n%q\nexpected\n%q", manuscript.Chapters, expected)
+	}
+}
+
+// an epub with the documents by id, in the order of the spine
+func epubFiles(spine string, documents map[string]string) map[string]string {
+	manifest := new(strings.Builder)
+	files := map[string]string{
+		"mimetype":               "application/epub+zip",
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="OEBPS/content.opf"/></rootfiles></container>`,
+	}
+	for id, document := range documents {
+		properties := ""
+		if id == "nav" {
+			properties = ` properties="nav"`
+		}
+		fmt.Fprintf(manifest, `<item id="%s" href="text/%s.xhtml" media-type="application/xhtml+xml"%s/>`, id, id, properties)
+		files["OEBPS/text/"+id+".xhtml"] = document
+	}
+	files["OEBPS/content.opf"] = `<package><metadata><title>The Book</title><description>What it is about.</description></metadata>` +
+		`<manifest>` + manifest.String() + `</manifest><spine>` + spine + `</spine></package>`
+	return files
+}
+
+func zipFile(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	buffer := new(bytes.Buffer)
+	archive := zip.NewWriter(buffer)
+	for name, content := range files {
+		file, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(file, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
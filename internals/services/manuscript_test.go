@@ -0,0 +1,83 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestManuscriptServiceWriteZip(t *testing.T) {
+	manuscript := Manuscript{
+		Title: "The Book",
+		Chapters: []ManuscriptChapter{
+			{ChapterNO: 1, Title: "Plain", Text: "# not a heading\n1. not a list\n\n*not emphasized* <b>", Format: ContentFormatPlain},
+			{ChapterNO: 2, Title: "Markdown", Text: "Some *emphasis*\n\n- item", Format: ContentFormatMarkdown},
+			{ChapterNO: 3, Title: "HTML", Text: "<p>First &amp; <em>only</em><br/>line</p><script>alert(1)</script><ul><li>item</li></ul>", Format: ContentFormatHTML},
+		},
+	}
+	tests := []struct {
+		format   string
+		expected map[string]string
+	}{
+		{
+			format: ManuscriptFormatMarkdown,
+			expected: map[string]string{
+				"0001-plain.md":    "# Plain\n\n\\# not a heading\\\n1\\. not a list\n\n\\*not emphasized\\* \\<b\\>\n",
+				"0002-markdown.md": "# Markdown\n\nSome *emphasis*\n\n- item\n",
+				"0003-html.md":     "# HTML\n\nFirst \\& only\\\nline\n\nitem\n",
+			},
+		},
+		{
+			format: ManuscriptFormatText,
+			expected: map[string]string{
+				"0001-plain.txt":    "Plain\n=====\n\n# not a heading\n1. not a list\n\n*not emphasized* <b>\n",
+				"0002-markdown.txt": "Markdown\n========\n\nSome emphasis\n\nitem\n",
+				"0003-html.txt":     "HTML\n====\n\nFirst & only\nline\n\nitem\n",
+			},
+		},
+	}
+	for _, test := range tests {
+		output := new(bytes.Buffer)
+		if err := NewManuscriptService().WriteZip(output, manuscript, test.format); err != nil {
+			t.Fatal(err)
+		}
+		archive, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(archive.File) != len(test.expected) {
+			t.Errorf("%s: %d files, expected %d", test.format, len(archive.File), len(test.expected))
+		}
+		for _, file := range archive.File {
+			entry, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(entry)
+			entry.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, ok := test.expected[file.Name]; !ok || string(content) != expected {
+				t.Errorf("%s: %q is %q, expected %q", test.format, file.Name, content, expected)
+			}
+		}
+	}
+}
+
+// escaped plain text renders back to the same text
+func TestManuscriptServiceMarkdownParagraphs(t *testing.T) {
+	texts := []string{
+		"a * b _ c ` d [e](f) <g> h & i ~~j~~ | k \\ l",
+		"# heading\n## heading\n- item\n+ item\n* item\n> quote\n12. item\n3) item",
+		"text\n===\n\ntext\n---",
+		"&amp; &#65; \\* \\",
+	}
+	for _, text := range texts {
+		rendered := htmlText(renderMarkdown(markdownParagraphs(text)))
+		if rendered != text {
+			t.Errorf("%q renders to %q", text, rendered)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// CommonMark with the strikethrough & tables of GitHub. Raw HTML is left out, the output is XHTML
+var markdownRenderer = goldmark.New(
+	goldmark.WithExtensions(extension.Strikethrough, extension.Table),
+	goldmark.WithRendererOptions(goldmarkhtml.WithXHTML()),
+)
+
+// blockquotes & list items opened by a single line past this depth are left as text.
+// parsing containers takes time quadratic to their depth
+const maxMarkdownNesting = 32
+
+var (
+	markdownContainerMarker = regexp.MustCompile(`^[ \t]{0,3}(?:>|(?:[-+*]|[0-9]{1,9}[.)])(?:[ \t]|$))`)
+	markdownThematicBreak   = regexp.MustCompile(`^[ \t]*(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+)
+
+// renders the Markdown to HTML, the output still has to be sanitized
+func renderMarkdown(text string) string {
+	output := new(bytes.Buffer)
+	if err := markdownRenderer.Convert([]byte(limitMarkdownNesting(text)), output); err != nil {
+		return epubEscape(text)
+	}
+	return output.String()
+}
+
+// escape the container markers of each line past maxMarkdownNesting
+func limitMarkdownNesting(text string) string {
+	lines := strings.Split(text, "\n")
+	for index, line := range lines {
+		if markdownThematicBreak.MatchString(line) {
+			continue
+		}
+		offset, depth := 0, 0
+		for {
+			location := markdownContainerMarker.FindStringIndex(line[offset:])
+			if location == nil {
+				break
+			}
+			marker := strings.TrimRight(line[offset:offset+location[1]], " \t")
+			if depth++; depth > maxMarkdownNesting {
+				// the last character of the marker: >, the bullet or the delimiter of the number
+				position := offset + len(marker) - 1
+				lines[index] = line[:position] + `\` + line[position:]
+				break
+			}
+			offset += location[1]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
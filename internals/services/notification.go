@@ -0,0 +1,45 @@
+package services
+
+import (
+	"fmt"
+)
+
+// what a notification points to
+type NotificationMail struct {
+	Type           string
+	BookID         int64
+	ChapterID      int64
+	CommentID      int64
+	ActorID        int64
+	CollaboratorID int64
+}
+
+type NotificationService struct{}
+
+func NewNotificationService() NotificationService {
+	return NotificationService{}
+}
+
+// the mail template of the notification type
+func (service NotificationService) Template(notificationType string) string {
+	if notificationType == "collaborator_invitation" {
+		return MailTemplateInvitation
+	}
+	return MailTemplateNotification
+}
+
+// where the notification leads on the frontend, relative to its base url
+func (service NotificationService) Path(notification NotificationMail) string {
+	switch notification.Type {
+	case "new_chapter":
+		return fmt.Sprintf("/books/%d/chapters/%d", notification.BookID, notification.ChapterID)
+	case "comment_reply":
+		return fmt.Sprintf("/books/%d/chapters/%d#comment-%d", notification.BookID, notification.ChapterID, notification.CommentID)
+	case "new_follower":
+		return fmt.Sprintf("/users/%d", notification.ActorID)
+	case "collaborator_invitation":
+		return fmt.Sprintf("/invitations/%d", notification.CollaboratorID)
+	default:
+		return "/notifications"
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"sync"
+)
+
+// the live streams connected to this replica. A stream is only woken up, what it sends is read
+// back from the database so that ordering & Last-Event-ID resume work the same way everywhere
+type StreamService struct {
+	mu      sync.RWMutex
+	streams map[int64]map[*Stream]struct{}
+	// closed when the server shuts down, the streams end so that it does not wait for them
+	done     chan struct{}
+	shutdown sync.Once
+}
+
+type Stream struct {
+	UserID int64
+	// never blocks, wake ups that happen while the stream is busy are merged into 1
+	Wake chan struct{}
+}
+
+func NewStreamService() *StreamService {
+	return &StreamService{
+		streams: map[int64]map[*Stream]struct{}{},
+		done:    make(chan struct{}),
+	}
+}
+
+// closed once Shutdown is called
+func (service *StreamService) Done() <-chan struct{} {
+	return service.done
+}
+
+// end every stream, the clients reconnect to another replica with their Last-Event-ID
+func (service *StreamService) Shutdown() {
+	service.shutdown.Do(func() {
+		close(service.done)
+	})
+}
+
+func (service *StreamService) Open(userID int64) *Stream {
+	stream := &Stream{
+		UserID: userID,
+		Wake:   make(chan struct{}, 1),
+	}
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if service.streams[userID] == nil {
+		service.streams[userID] = map[*Stream]struct{}{}
+	}
+	service.streams[userID][stream] = struct{}{}
+	return stream
+}
+
+func (service *StreamService) Close(stream *Stream) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	delete(service.streams[stream.UserID], stream)
+	if len(service.streams[stream.UserID]) == 0 {
+		delete(service.streams, stream.UserID)
+	}
+}
+
+// wake up every stream of the users
+func (service *StreamService) Wake(userIDs ...int64) {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	for _, userID := range userIDs {
+		for stream := range service.streams[userID] {
+			stream.wake()
+		}
+	}
+}
+
+// wake up every stream, after the database connection was lost for instance
+func (service *StreamService) WakeAll() {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	for _, streams := range service.streams {
+		for stream := range streams {
+			stream.wake()
+		}
+	}
+}
+
+// users with at least 1 stream on this replica
+func (service *StreamService) UserIDs() []int64 {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	ids := make([]int64, 0, len(service.streams))
+	for userID := range service.streams {
+		ids = append(ids, userID)
+	}
+	return ids
+}
+
+func (stream *Stream) wake() {
+	select {
+	case stream.Wake <- struct{}{}:
+	default:
+	}
+}
@@ -17,6 +17,12 @@ var (
 	ErrorInvalidModel       = NewError("invalid model object", http.StatusBadRequest)
 	ErrorUnverfiedUser      = NewError("unverified user", http.StatusUnauthorized)
 	ErrorInvalidToken       = NewError("invalid token", http.StatusUnauthorized)
+	ErrorRecordExisted      = NewError("record already existed", http.StatusConflict)
+	ErrorTokenReused        = NewError("refresh token reuse detected", http.StatusUnauthorized)
+	ErrorSessionRevoked     = NewError("session has been revoked", http.StatusUnauthorized)
+	ErrorContentTooLarge    = NewError("content exceeds the maximum allowed length", http.StatusRequestEntityTooLarge)
+	ErrorUnsupportedLocale  = NewError("unsupported locale", http.StatusBadRequest)
+	ErrorUnsupportedFile    = NewError("unsupported file type", http.StatusUnsupportedMediaType)
 )
 
 func NewError(message string, code int) error {
@@ -1,5 +1,10 @@
 package utils
 
+import (
+	"strings"
+	"unicode"
+)
+
 func IsItemInCollection[T comparable](item T, collection []T) bool {
 	for _, s := range collection {
 		if s == item {
@@ -8,3 +13,34 @@ func IsItemInCollection[T comparable](item T, collection []T) bool {
 	}
 	return false
 }
+
+// copy of collection without duplicates, first occurrence order is kept
+func Unique[T comparable](collection []T) []T {
+	seen := make(map[T]bool, len(collection))
+	result := make([]T, 0, len(collection))
+	for _, item := range collection {
+		if !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// lowercase s and join its words with `-`, anything that isn't a letter or a digit separates words.
+// "  Enemies to Lovers! " -> "enemies-to-lovers"
+func Slugify(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "-")
+}
+
+// the value pointer points to, the zero value for nil
+func ValueOf[T any](pointer *T) T {
+	var value T
+	if pointer != nil {
+		value = *pointer
+	}
+	return value
+}
@@ -0,0 +1,118 @@
+package workers
+
+import (
+	"context"
+	"gin_stuff/internals/repositories"
+	"gin_stuff/internals/services"
+	"math/rand/v2"
+	"time"
+)
+
+// sends the emails of the outbox in the background. Every replica runs one,
+// claims keep them from sending the same email at the same time
+type MailWorker struct {
+	Outbox repositories.OutboxQueries
+	Mailer services.Mailer
+	Logger *services.LoggerService
+	Config MailWorkerConfig
+}
+
+type MailWorkerConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	// how long a claimed email is left alone, longer than sending a whole batch
+	Lease time.Duration
+}
+
+func NewMailWorker(outbox repositories.OutboxQueries, mailer services.Mailer, logger *services.LoggerService, config MailWorkerConfig) *MailWorker {
+	if config.PollInterval <= 0 {
+		config.PollInterval = 5 * time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 20
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 8
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = 30 * time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 6 * time.Hour
+	}
+	if config.Lease <= 0 {
+		config.Lease = 2 * time.Minute
+	}
+	return &MailWorker{
+		Outbox: outbox,
+		Mailer: mailer,
+		Logger: logger,
+		Config: config,
+	}
+}
+
+// poll the outbox until ctx is done
+func (worker *MailWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(worker.Config.PollInterval)
+	defer ticker.Stop()
+	for {
+		worker.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// send batches until nothing is due
+func (worker *MailWorker) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		mails, err := worker.Outbox.Claim(worker.Config.BatchSize, worker.Config.Lease)
+		if err != nil {
+			worker.Logger.LogError(err, "failed to claim emails from the outbox")
+			return
+		}
+		for _, mail := range mails {
+			worker.send(mail)
+		}
+		if len(mails) < worker.Config.BatchSize {
+			return
+		}
+	}
+}
+
+func (worker *MailWorker) send(mail *repositories.OutboxMail) {
+	err := worker.Mailer.Perform(mail.Mail())
+	if err == nil {
+		if err := worker.Outbox.MarkSent(mail.ID); err != nil {
+			worker.Logger.LogError(err, "failed to mark an email as sent")
+		}
+		return
+	}
+	var retryAt *time.Time
+	if mail.Attempts < worker.Config.MaxAttempts {
+		next := time.Now().Add(worker.Backoff(mail.Attempts))
+		retryAt = &next
+		worker.Logger.LogError(err, "failed to send an email, it will be retried")
+	} else {
+		worker.Logger.LogError(err, "failed to send an email, it is moved to the dead letters")
+	}
+	if err := worker.Outbox.MarkFailed(mail.ID, err.Error(), retryAt); err != nil {
+		worker.Logger.LogError(err, "failed to record an email failure")
+	}
+}
+
+// wait before the next attempt: BaseBackoff after the 1st one, twice as long after each
+// following one up to MaxBackoff, plus up to 10% of jitter so failed batches spread out
+func (worker *MailWorker) Backoff(attempts int) time.Duration {
+	backoff := worker.Config.BaseBackoff
+	for i := 1; i < attempts && backoff < worker.Config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, worker.Config.MaxBackoff)
+	return backoff + time.Duration(rand.Int64N(int64(backoff)/10+1))
+}
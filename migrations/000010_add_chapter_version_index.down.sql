@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_chapter_versions_content_id;
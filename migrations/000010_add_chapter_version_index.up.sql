@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_chapter_versions_content_id
+ON chapter_versions (content_id, created_at);
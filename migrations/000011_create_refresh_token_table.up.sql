@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id),
+	token_hash TEXT NOT NULL UNIQUE, -- sha256 of the token id (jti)
+	family_id TEXT NOT NULL, -- every token rotated from the same sign-in share a family
+	parent_id INT REFERENCES refresh_tokens(id),
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_refresh_tokens_family_id
+ON refresh_tokens (family_id);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id),
+	jti TEXT NOT NULL UNIQUE, -- id of the latest access token issued for this session
+	family_id TEXT NOT NULL UNIQUE, -- refresh token family of this session
+	user_agent TEXT,
+	ip_address VARCHAR(64),
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP
+);
+
+CREATE INDEX idx_sessions_user_id
+ON sessions (user_id);
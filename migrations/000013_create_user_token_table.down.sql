@@ -0,0 +1,7 @@
+ALTER TABLE users
+ADD COLUMN verification_token VARCHAR(255),
+ADD COLUMN password_reset_token VARCHAR(255);
+
+DROP INDEX IF EXISTS idx_user_tokens_user_id_purpose;
+DROP TABLE IF EXISTS user_tokens;
+DROP TYPE IF EXISTS USER_TOKEN_PURPOSE;
@@ -0,0 +1,19 @@
+CREATE TYPE USER_TOKEN_PURPOSE AS ENUM ('email_verification', 'password_reset');
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id),
+	purpose USER_TOKEN_PURPOSE NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE, -- sha256 of the token sent by email
+	expires_at TIMESTAMP NOT NULL,
+	consumed_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_tokens_user_id_purpose
+ON user_tokens (user_id, purpose);
+
+-- plaintext tokens are replaced by user_tokens
+ALTER TABLE users
+DROP COLUMN IF EXISTS verification_token,
+DROP COLUMN IF EXISTS password_reset_token;
@@ -0,0 +1,58 @@
+CREATE TABLE IF NOT EXISTS roles (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(50) NOT NULL UNIQUE,
+	description TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- permission without a suffix only applies to resources the user owns,
+-- the `:any` variant applies to every resource
+CREATE TABLE IF NOT EXISTS permissions (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(100) NOT NULL UNIQUE,
+	description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_id INT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+	permission_id INT NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+	PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id INT NOT NULL REFERENCES users(id),
+	role_id INT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name, description) VALUES
+	('reader', 'Read published books'),
+	('author', 'Write & manage their own books'),
+	('moderator', 'Read & take down any book or chapter'),
+	('admin', 'Full access');
+
+INSERT INTO permissions (name, description) VALUES
+	('book:create', 'Create books'),
+	('book:read', 'Read own books'),
+	('book:read:any', 'Read any book'),
+	('book:update', 'Update own books'),
+	('book:update:any', 'Update any book'),
+	('book:delete', 'Delete own books'),
+	('book:delete:any', 'Delete any book'),
+	('chapter:write', 'Create & edit chapters of own books'),
+	('chapter:write:any', 'Create & edit chapters of any book'),
+	('chapter:delete', 'Delete own chapters'),
+	('chapter:delete:any', 'Delete any chapter'),
+	('role:manage', 'Grant & revoke user roles');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE (r.name = 'author' AND p.name IN ('book:create', 'book:read', 'book:update', 'book:delete', 'chapter:write', 'chapter:delete'))
+OR (r.name = 'moderator' AND p.name IN ('book:create', 'book:read', 'book:update', 'book:delete', 'chapter:write', 'chapter:delete', 'book:read:any', 'book:delete:any', 'chapter:delete:any'))
+OR (r.name = 'admin');
+
+-- everyone could write books before roles existed
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users u, roles r
+WHERE r.name = 'author';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_book_collaborators_user_id;
+DROP TABLE IF EXISTS book_collaborators;
+DROP TYPE IF EXISTS COLLABORATOR_STATUS;
+DROP TYPE IF EXISTS COLLABORATOR_ROLE;
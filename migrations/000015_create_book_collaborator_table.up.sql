@@ -0,0 +1,19 @@
+CREATE TYPE COLLABORATOR_ROLE AS ENUM ('co-author', 'editor', 'beta-reader');
+
+CREATE TYPE COLLABORATOR_STATUS AS ENUM ('pending', 'accepted', 'declined');
+
+CREATE TABLE IF NOT EXISTS book_collaborators (
+	id SERIAL PRIMARY KEY,
+	book_id INT NOT NULL REFERENCES books(id),
+	user_id INT NOT NULL REFERENCES users(id),
+	invited_by INT NOT NULL REFERENCES users(id),
+	role COLLABORATOR_ROLE NOT NULL,
+	status COLLABORATOR_STATUS NOT NULL DEFAULT 'pending',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP,
+	responded_at TIMESTAMP,
+	UNIQUE (book_id, user_id)
+);
+
+CREATE INDEX idx_book_collaborators_user_id
+ON book_collaborators (user_id, status);
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_books_status_published_at;
+
+ALTER TABLE chapters
+DROP COLUMN status,
+DROP COLUMN published_at;
+
+ALTER TABLE books
+DROP COLUMN status,
+DROP COLUMN published_at;
+
+DROP TYPE IF EXISTS PUBLICATION_STATUS;
@@ -0,0 +1,15 @@
+CREATE TYPE PUBLICATION_STATUS AS ENUM ('draft', 'published', 'unlisted', 'archived');
+
+ALTER TABLE books
+ADD COLUMN status PUBLICATION_STATUS NOT NULL DEFAULT 'draft',
+ADD COLUMN published_at TIMESTAMP;
+
+ALTER TABLE chapters
+ADD COLUMN status PUBLICATION_STATUS NOT NULL DEFAULT 'draft',
+ADD COLUMN published_at TIMESTAMP;
+
+-- chapters were publicly listed before, keep them that way
+UPDATE chapters SET status = 'published', published_at = created_at;
+
+CREATE INDEX idx_books_status_published_at
+ON books (status, published_at);
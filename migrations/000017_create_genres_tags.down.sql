@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE name = 'taxonomy:manage';
+
+DROP TABLE IF EXISTS book_tags;
+DROP TABLE IF EXISTS book_genres;
+DROP TABLE IF EXISTS tag_synonyms;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS genres;
@@ -0,0 +1,47 @@
+-- curated list, managed by admins
+CREATE TABLE IF NOT EXISTS genres (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(64) NOT NULL UNIQUE,
+	slug VARCHAR(64) NOT NULL UNIQUE,
+	description TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP
+);
+
+-- free-form, created on the fly when assigned to a book. names are normalized
+CREATE TABLE IF NOT EXISTS tags (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(64) NOT NULL UNIQUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP
+);
+
+-- alternative names resolving to a canonical tag
+CREATE TABLE IF NOT EXISTS tag_synonyms (
+	name VARCHAR(64) PRIMARY KEY,
+	tag_id INT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS book_genres (
+	book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+	genre_id INT NOT NULL REFERENCES genres(id) ON DELETE CASCADE,
+	PRIMARY KEY (book_id, genre_id)
+);
+
+CREATE TABLE IF NOT EXISTS book_tags (
+	book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+	tag_id INT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY (book_id, tag_id)
+);
+
+CREATE INDEX idx_tag_synonyms_tag_id ON tag_synonyms (tag_id);
+CREATE INDEX idx_book_genres_genre_id ON book_genres (genre_id);
+CREATE INDEX idx_book_tags_tag_id ON book_tags (tag_id);
+
+INSERT INTO permissions (name, description) VALUES
+	('taxonomy:manage', 'Manage genres, tags & tag synonyms');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name IN ('moderator', 'admin') AND p.name = 'taxonomy:manage';
@@ -0,0 +1,9 @@
+DELETE FROM permissions WHERE name IN ('review:write', 'review:delete', 'review:delete:any');
+
+DROP TABLE IF EXISTS reviews;
+
+DROP INDEX IF EXISTS idx_books_rating_average;
+
+ALTER TABLE books
+DROP COLUMN rating_average,
+DROP COLUMN rating_count;
@@ -0,0 +1,31 @@
+-- kept up to date whenever a review is written, edited or removed
+ALTER TABLE books
+ADD COLUMN rating_average NUMERIC(3, 2) NOT NULL DEFAULT 0,
+ADD COLUMN rating_count INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS reviews (
+	id SERIAL PRIMARY KEY,
+	book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+	user_id INT NOT NULL REFERENCES users(id),
+	rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+	content TEXT NOT NULL DEFAULT '',
+	reply TEXT,
+	replied_by INT REFERENCES users(id),
+	replied_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP,
+	UNIQUE (book_id, user_id)
+);
+
+CREATE INDEX idx_reviews_book_id_created_at ON reviews (book_id, created_at);
+CREATE INDEX idx_books_rating_average ON books (rating_average);
+
+INSERT INTO permissions (name, description) VALUES
+	('review:write', 'Rate & review books'),
+	('review:delete', 'Delete own reviews'),
+	('review:delete:any', 'Delete any review');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE (r.name IN ('reader', 'author') AND p.name IN ('review:write', 'review:delete'))
+OR (r.name IN ('moderator', 'admin') AND p.name IN ('review:write', 'review:delete', 'review:delete:any'));
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE name IN ('comment:write', 'comment:delete', 'comment:delete:any');
+
+DROP TABLE IF EXISTS comment_likes;
+DROP TABLE IF EXISTS comments;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS comments (
+	id SERIAL PRIMARY KEY,
+	chapter_id INT NOT NULL REFERENCES chapters(id) ON DELETE CASCADE,
+	user_id INT NOT NULL REFERENCES users(id),
+	parent_id INT REFERENCES comments(id) ON DELETE CASCADE,
+	content TEXT NOT NULL,
+	-- kept up to date by likes & unlikes
+	like_count INT NOT NULL DEFAULT 0,
+	pinned_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP,
+	deleted_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS comment_likes (
+	comment_id INT NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+	user_id INT NOT NULL REFERENCES users(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (comment_id, user_id)
+);
+
+CREATE INDEX idx_comments_chapter_id_parent_id ON comments (chapter_id, parent_id);
+
+INSERT INTO permissions (name, description) VALUES
+	('comment:write', 'Comment on chapters'),
+	('comment:delete', 'Delete own comments'),
+	('comment:delete:any', 'Delete any comment');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE (r.name IN ('reader', 'author') AND p.name IN ('comment:write', 'comment:delete'))
+OR (r.name IN ('moderator', 'admin') AND p.name IN ('comment:write', 'comment:delete', 'comment:delete:any'));
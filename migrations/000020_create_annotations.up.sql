@@ -0,0 +1,22 @@
+-- feedback anchored to a range of the chapter content, offsets are in characters within the paragraph
+CREATE TABLE IF NOT EXISTS annotations (
+	id SERIAL PRIMARY KEY,
+	content_id INT NOT NULL REFERENCES contents(id) ON DELETE CASCADE,
+	user_id INT NOT NULL REFERENCES users(id),
+	paragraph_index INT NOT NULL,
+	paragraph_anchor VARCHAR(32) NOT NULL,
+	start_offset INT NOT NULL,
+	end_offset INT NOT NULL,
+	quote TEXT NOT NULL,
+	body TEXT NOT NULL,
+	-- set when the quoted text disappeared from the content, along with
+	-- the snapshot holding the last text the annotation matched
+	orphaned_at TIMESTAMP,
+	orphaned_version_id INT REFERENCES chapter_versions(id) ON DELETE SET NULL,
+	resolved_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP,
+	CHECK (start_offset >= 0 AND start_offset < end_offset)
+);
+
+CREATE INDEX idx_annotations_content_id ON annotations (content_id);
@@ -0,0 +1,28 @@
+-- where each user stopped reading each book, updated_at is the time the progress
+-- was recorded on the device so the latest position wins when several devices sync
+CREATE TABLE IF NOT EXISTS reading_progress (
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+	chapter_id INT REFERENCES chapters(id) ON DELETE SET NULL,
+	-- position in the chapter content, in characters
+	scroll_offset INT NOT NULL DEFAULT 0 CHECK (scroll_offset >= 0),
+	percentage NUMERIC(5,2) NOT NULL DEFAULT 0 CHECK (percentage BETWEEN 0 AND 100),
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, book_id)
+);
+
+CREATE INDEX idx_reading_progress_user_id_updated_at ON reading_progress (user_id, updated_at DESC);
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+	chapter_id INT NOT NULL REFERENCES chapters(id) ON DELETE CASCADE,
+	name VARCHAR(100) NOT NULL,
+	-- position in the chapter content, in characters
+	position INT NOT NULL DEFAULT 0 CHECK (position >= 0),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_bookmarks_user_id_book_id ON bookmarks (user_id, book_id);
@@ -0,0 +1,34 @@
+-- reading lists of a user. default shelves have a kind & can't be renamed or deleted,
+-- the other ones are created by the user
+CREATE TABLE IF NOT EXISTS shelves (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	kind TEXT CHECK (kind IN ('reading', 'want_to_read', 'finished', 'dropped')),
+	name VARCHAR(100) NOT NULL,
+	public BOOLEAN NOT NULL DEFAULT FALSE,
+	position INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP,
+	UNIQUE (user_id, kind),
+	UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS shelf_books (
+	shelf_id INT NOT NULL REFERENCES shelves(id) ON DELETE CASCADE,
+	book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+	position INT NOT NULL DEFAULT 0,
+	added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (shelf_id, book_id)
+);
+
+CREATE INDEX idx_shelf_books_book_id ON shelf_books (book_id);
+
+-- new users get theirs the first time they look at their library
+INSERT INTO shelves (user_id, kind, name, position)
+SELECT u.id, d.kind, d.name, d.position
+FROM users u, (VALUES
+	('reading', 'Reading', 0),
+	('want_to_read', 'Want to read', 1),
+	('finished', 'Finished', 2),
+	('dropped', 'Dropped', 3)
+) AS d (kind, name, position);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS book_subscriptions;
+DROP TABLE IF EXISTS follows;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS follows (
+	follower_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	followee_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (follower_id, followee_id),
+	CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX idx_follows_followee_id ON follows (followee_id);
+
+-- readers subscribed to a book get told about its new chapters
+CREATE TABLE IF NOT EXISTS book_subscriptions (
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, book_id)
+);
+
+CREATE INDEX idx_book_subscriptions_book_id ON book_subscriptions (book_id);
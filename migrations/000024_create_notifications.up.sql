@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS notifications (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	type TEXT NOT NULL CHECK (type IN ('new_chapter', 'comment_reply', 'new_follower', 'collaborator_invitation')),
+	-- the user that caused the notification, if any
+	actor_id INT REFERENCES users(id) ON DELETE SET NULL,
+	book_id INT REFERENCES books(id) ON DELETE CASCADE,
+	chapter_id INT REFERENCES chapters(id) ON DELETE CASCADE,
+	comment_id INT REFERENCES comments(id) ON DELETE CASCADE,
+	collaborator_id INT REFERENCES book_collaborators(id) ON DELETE CASCADE,
+	message TEXT NOT NULL,
+	read_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_notifications_user_id_created_at ON notifications (user_id, created_at DESC);
+CREATE INDEX idx_notifications_user_id_unread ON notifications (user_id) WHERE read_at IS NULL;
+
+-- a missing row means the default delivery of the type
+CREATE TABLE IF NOT EXISTS notification_preferences (
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	type TEXT NOT NULL CHECK (type IN ('new_chapter', 'comment_reply', 'new_follower', 'collaborator_invitation')),
+	in_app BOOLEAN NOT NULL,
+	email BOOLEAN NOT NULL,
+	updated_at TIMESTAMP,
+	PRIMARY KEY (user_id, type)
+);
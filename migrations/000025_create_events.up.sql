@@ -0,0 +1,26 @@
+-- what is pushed to the live stream, kept for a while so clients can resume with Last-Event-ID.
+-- an event goes either to a user, or to the subscribers of a book
+CREATE TABLE IF NOT EXISTS events (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INT REFERENCES users(id) ON DELETE CASCADE,
+	book_id INT REFERENCES books(id) ON DELETE CASCADE,
+	type TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CHECK (user_id IS NOT NULL OR book_id IS NOT NULL)
+);
+
+CREATE INDEX idx_events_user_id ON events (user_id, id) WHERE user_id IS NOT NULL;
+CREATE INDEX idx_events_book_id ON events (book_id, id) WHERE book_id IS NOT NULL;
+CREATE INDEX idx_events_created_at ON events (created_at);
+
+-- wake up the API replicas, the payload stays small since NOTIFY payloads are limited to 8000 bytes
+CREATE OR REPLACE FUNCTION notify_event() RETURNS TRIGGER AS $$
+BEGIN
+	PERFORM pg_notify('events', json_build_object('id', NEW.id, 'userId', NEW.user_id, 'bookId', NEW.book_id)::text);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER events_notify AFTER INSERT ON events
+FOR EACH ROW EXECUTE FUNCTION notify_event();
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE name = 'email:manage';
+DROP TABLE IF EXISTS email_outbox;
@@ -0,0 +1,27 @@
+-- emails waiting to be sent, enqueued in the same transaction as what they are about.
+-- a pending email is claimed by pushing next_attempt_at forward, so a worker that dies mid-send
+-- only delays it. Emails that keep failing end up dead
+CREATE TABLE IF NOT EXISTS email_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	sender TEXT NOT NULL,
+	recipient TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	content TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'dead')),
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	sent_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP
+);
+
+CREATE INDEX idx_email_outbox_pending ON email_outbox (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_email_outbox_dead ON email_outbox (updated_at) WHERE status = 'dead';
+
+INSERT INTO permissions (name, description) VALUES
+	('email:manage', 'Inspect & retry the emails that could not be sent');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'email:manage';
@@ -0,0 +1,3 @@
+ALTER TABLE chapter_versions DROP COLUMN IF EXISTS format;
+
+ALTER TABLE contents DROP COLUMN IF EXISTS format;
@@ -0,0 +1,5 @@
+-- how the text is written, markdown & html are rendered to sanitized HTML for the readers
+ALTER TABLE contents ADD COLUMN IF NOT EXISTS format TEXT NOT NULL DEFAULT 'plain' CHECK (format IN ('plain', 'markdown', 'html'));
+
+-- restoring a version brings its format back along with its text
+ALTER TABLE chapter_versions ADD COLUMN IF NOT EXISTS format TEXT NOT NULL DEFAULT 'plain' CHECK (format IN ('plain', 'markdown', 'html'));
@@ -0,0 +1,3 @@
+-- the text rendered to sanitized HTML when it was saved, so that reading a chapter doesn't render it again.
+-- NULL for the contents saved before, cmd/render_content_html stores theirs
+ALTER TABLE contents ADD COLUMN IF NOT EXISTS html TEXT;
@@ -0,0 +1,12 @@
+-- what EventSource clients open the live stream with, instead of an access token that would end up
+-- in the logs of the URLs. Single use & short lived, only the hash is stored
+CREATE TABLE IF NOT EXISTS stream_tickets (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	family_id TEXT NOT NULL, -- session the ticket was issued to
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_stream_tickets_expires_at ON stream_tickets (expires_at);
@@ -0,0 +1,6 @@
+INSERT INTO permissions (name, description) VALUES
+	('collaborator:manage', 'See the emails of the collaborators of any book');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'collaborator:manage';